@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"Servus/internal/accesslog"
+	"Servus/internal/metrics"
 	"Servus/internal/request"
+	"Servus/internal/requestid"
 	"Servus/internal/response"
 	"Servus/internal/server"
+	"Servus/internal/tracing"
 	"Servus/internal/html"
 )
 
@@ -45,16 +51,43 @@ func handler(w *response.Writer, req *request.Request) {
 }
 
 func main() {
-	server, err := server.Serve(port, handler)
+	accessLog := accesslog.New(accesslog.Options{Format: accesslog.CombinedFormat})
+	serverMetrics := metrics.NewHTTPMetrics(metrics.NewRegistry(), metrics.HTTPOptions{
+		Routes: []string{"/yourproblem", "/myproblem"},
+	})
+	config := server.Config{Metrics: serverMetrics}
+
+	// spans are sent to a collector only when one is configured
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); endpoint != "" {
+		config.Tracer = tracing.NewTracer(tracing.Options{
+			Exporter: tracing.NewOTLPExporter(tracing.OTLPOptions{Endpoint: endpoint}),
+		})
+	}
+
+	server, err := server.ServeConfig(port, response.Chain(handler,
+		accessLog.Middleware,
+		requestid.Middleware(requestid.Options{}),
+		serverMetrics.Middleware,
+	), config)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 
-	defer server.Close()
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	// let in-flight requests finish before exiting
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("forced shutdown: %v", err)
+	}
+	if config.Tracer != nil {
+		config.Tracer.Shutdown(ctx)
+	}
 	log.Println("Server gracefully stopped")
 }
\ No newline at end of file
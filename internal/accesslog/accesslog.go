@@ -0,0 +1,214 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Servus/internal/request"
+	"Servus/internal/requestid"
+	"Servus/internal/response"
+)
+
+type Format int
+
+const (
+	// host ident authuser [time] "request" status bytes
+	CommonFormat Format = iota
+	// CommonFormat followed by "referer" "user-agent", the request
+	// ID and the duration in milliseconds
+	CombinedFormat
+	// one JSON object per line with every Entry field
+	JSONFormat
+)
+
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+type Options struct {
+	Format Format
+	// where entries go, os.Stdout when nil
+	Output io.Writer
+	// logs one request in N for paths starting with the key,
+	// the longest matching prefix wins. responses with a 5xx
+	// status are always logged
+	Sampling map[string]int
+}
+
+type Entry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Target     string    `json:"target"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	// time spent in the handler chain
+	Duration  time.Duration `json:"-"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	Referer   string        `json:"referer,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+}
+
+// Logger writes one access log entry per request
+type Logger struct {
+	format   Format
+	sampling map[string]int
+
+	mu      sync.Mutex
+	out     io.Writer
+	counter map[string]int
+}
+
+func New(opts Options) *Logger {
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	return &Logger{
+		format:   opts.Format,
+		sampling: opts.Sampling,
+		out:      out,
+		counter:  map[string]int{},
+	}
+}
+
+func (l *Logger) Middleware(next response.Handler) response.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		start := time.Now()
+		next(w, req)
+
+		e := Entry{
+			Time:     start,
+			Method:   req.RequestLine.Method,
+			Target:   req.RequestLine.RequestTarget,
+			Proto:    "HTTP/" + req.RequestLine.HttpVersion,
+			Status:   int(w.StatusCode()),
+			Bytes:    w.BytesWritten(),
+			Duration: time.Since(start),
+		}
+		e.UserAgent, _ = req.Headers.Get("User-Agent")
+		e.Referer, _ = req.Headers.Get("Referer")
+		e.RequestID = requestid.FromRequest(req)
+		if e.RequestID == "" {
+			e.RequestID, _ = req.Headers.Get(requestid.DefaultHeader)
+		}
+
+		if w.Connection != nil && w.Connection.RemoteAddr() != nil {
+			e.RemoteAddr = w.Connection.RemoteAddr().String()
+			if host, _, err := net.SplitHostPort(e.RemoteAddr); err == nil {
+				e.RemoteAddr = host
+			}
+		}
+
+		if !l.sampled(req.Path(), e.Status) {
+			return
+		}
+
+		l.Log(e)
+	}
+}
+
+func (l *Logger) Log(e Entry) {
+	/*
+	* @brief: writes e as a single line, write errors
+	* are dropped
+	*/
+	line := l.formatEntry(e)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.out.Write(line)
+}
+
+func (l *Logger) sampled(path string, status int) bool {
+	if len(l.sampling) == 0 || status >= 500 {
+		return true
+	}
+
+	prefix, every := "", 0
+	for p, n := range l.sampling {
+		if strings.HasPrefix(path, p) && len(p) >= len(prefix) {
+			prefix, every = p, n
+		}
+	}
+
+	if every <= 1 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.counter[prefix]++
+
+	return l.counter[prefix]%every == 1
+}
+
+func (l *Logger) formatEntry(e Entry) []byte {
+	if l.format == JSONFormat {
+		// a plain struct always encodes
+		line, _ := json.Marshal(struct {
+			Entry
+			DurationMS float64 `json:"duration_ms"`
+		}{e, float64(e.Duration.Microseconds()) / 1000})
+
+		return append(line, '\n')
+	}
+
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.FormatInt(e.Bytes, 10)
+	}
+
+	var b strings.Builder
+	b.WriteString(orDash(e.RemoteAddr) + " - - [" + e.Time.Format(clfTime) + "] ")
+	b.WriteString(`"` + escape(e.Method+" "+e.Target+" "+e.Proto) + `" `)
+	b.WriteString(strconv.Itoa(e.Status) + " " + bytes)
+
+	if l.format == CombinedFormat {
+		b.WriteString(` "` + escape(orDash(e.Referer)) + `" "` + escape(orDash(e.UserAgent)) + `"`)
+		b.WriteString(` "` + escape(orDash(e.RequestID)) + `" `)
+		b.WriteString(strconv.FormatFloat(float64(e.Duration.Microseconds())/1000, 'f', 3, 64))
+	}
+	b.WriteString("\n")
+
+	return []byte(b.String())
+}
+
+func escape(s string) string {
+	/*
+	* escapes quotes, backslashes and control characters the
+	* way Apache does, a client cannot forge a log line
+	*/
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == '"' || ch == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case ch < 0x20 || ch == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", ch)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+
+	return b.String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
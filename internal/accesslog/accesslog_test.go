@@ -0,0 +1,207 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+	"Servus/internal/server"
+)
+
+func serveLogged(t *testing.T, opts Options) string {
+	handler := func(w *response.Writer, req *request.Request) {
+		code := response.CodeOK
+		if req.Path() == "/fail" {
+			code = response.CodeInternalServerError
+		}
+
+		body := "hello"
+		w.Response = &response.Response{
+			Code:    code,
+			Message: []byte(body),
+			Headers: headers.GetDefaultHeaders(len(body)),
+		}
+		w.WriteResponse()
+	}
+
+	s, err := server.Serve(0, response.Chain(handler, New(opts).Middleware))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	return fmt.Sprintf("127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+}
+
+func get(t *testing.T, addr, raw string) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	io.ReadAll(conn)
+}
+
+// syncBuffer lets the test read what the server goroutines wrote
+type syncBuffer struct {
+	lines chan string
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lines <- string(p)
+	return len(p), nil
+}
+
+func (b *syncBuffer) next(t *testing.T) string {
+	select {
+	case line := <-b.lines:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("no log entry")
+		return ""
+	}
+}
+
+func TestFormats(t *testing.T) {
+	raw := "GET /items?id=1 HTTP/1.1\r\nHost: localhost\r\nUser-Agent: curl/8.0 \"quoted\"\r\n" +
+		"Referer: http://example.com/\r\nX-Request-Id: abc123\r\n\r\n"
+
+	// test: common log format
+	out := &syncBuffer{lines: make(chan string, 16)}
+	addr := serveLogged(t, Options{Output: out})
+	get(t, addr, raw)
+	assert.Regexp(t, regexp.MustCompile(`^127\.0\.0\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] `+
+		`"GET /items\?id=1 HTTP/1\.1" 200 5\n$`), out.next(t))
+
+	// test: combined log format
+	out = &syncBuffer{lines: make(chan string, 16)}
+	addr = serveLogged(t, Options{Format: CombinedFormat, Output: out})
+	get(t, addr, raw)
+	assert.Regexp(t, regexp.MustCompile(`"GET /items\?id=1 HTTP/1\.1" 200 5 "http://example\.com/" `+
+		`"curl/8\.0 \\"quoted\\"" "abc123" \d+\.\d{3}\n$`), out.next(t))
+
+	// test: JSON lines
+	out = &syncBuffer{lines: make(chan string, 16)}
+	addr = serveLogged(t, Options{Format: JSONFormat, Output: out})
+	get(t, addr, raw)
+	line := out.next(t)
+	assert.True(t, strings.HasSuffix(line, "}\n"))
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal([]byte(line), &entry))
+	assert.Equal(t, "127.0.0.1", entry["remote_addr"])
+	assert.Equal(t, "GET", entry["method"])
+	assert.Equal(t, "/items?id=1", entry["target"])
+	assert.Equal(t, "HTTP/1.1", entry["proto"])
+	assert.Equal(t, float64(200), entry["status"])
+	assert.Equal(t, float64(5), entry["bytes"])
+	assert.Equal(t, `curl/8.0 "quoted"`, entry["user_agent"])
+	assert.Equal(t, "http://example.com/", entry["referer"])
+	assert.Equal(t, "abc123", entry["request_id"])
+	assert.Contains(t, entry, "duration_ms")
+	assert.Contains(t, entry, "time")
+
+	// test: control characters cannot forge lines
+	l := New(Options{Output: io.Discard})
+	formatted := string(l.formatEntry(Entry{Method: "GET", Target: "/a\n127.0.0.1 - - fake", Proto: "HTTP/1.1"}))
+	assert.Equal(t, 1, strings.Count(formatted, "\n"))
+	assert.Contains(t, formatted, `/a\x0a127.0.0.1`)
+}
+
+func TestSampling(t *testing.T) {
+	var out bytes.Buffer
+	l := New(Options{Output: &out, Sampling: map[string]int{"/health": 10, "/health/deep": 1}})
+
+	// test: one request in N is logged on sampled routes
+	logged := 0
+	for i := 0; i < 30; i++ {
+		if l.sampled("/healthz", 200) {
+			logged++
+		}
+	}
+	assert.Equal(t, 3, logged)
+
+	// test: server errors, other routes and longer prefixes are always logged
+	assert.True(t, l.sampled("/healthz", 500))
+	assert.True(t, l.sampled("/health/deep", 200))
+	assert.True(t, l.sampled("/health/deep", 200))
+	assert.True(t, l.sampled("/items", 200))
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	f, err := NewRotatingFile(path, RotateOptions{MaxSize: 10, Interval: time.Hour, MaxBackups: 2})
+	require.NoError(t, err)
+	defer f.Close()
+	f.now = func() time.Time { return now }
+	f.opened = now
+
+	// test: rotation by size
+	_, err = f.Write([]byte("12345678\n"))
+	require.NoError(t, err)
+	now = now.Add(time.Second)
+	_, err = f.Write([]byte("abc\n"))
+	require.NoError(t, err)
+
+	backups, _ := filepath.Glob(path + ".*")
+	require.Len(t, backups, 1)
+	data, _ := os.ReadFile(backups[0])
+	assert.Equal(t, "12345678\n", string(data))
+	data, _ = os.ReadFile(path)
+	assert.Equal(t, "abc\n", string(data))
+
+	// test: rotation by age
+	now = now.Add(time.Hour)
+	_, err = f.Write([]byte("def\n"))
+	require.NoError(t, err)
+	backups, _ = filepath.Glob(path + ".*")
+	assert.Len(t, backups, 2)
+
+	// test: old backups are pruned
+	now = now.Add(time.Second)
+	require.NoError(t, f.Rotate())
+	backups, _ = filepath.Glob(path + ".*")
+	require.Len(t, backups, 2)
+	data, _ = os.ReadFile(backups[0])
+	assert.Equal(t, "abc\n", string(data))
+
+	// test: files that only share the prefix are never pruned
+	other := path + ".old"
+	require.NoError(t, os.WriteFile(other, []byte("keep"), 0o644))
+	now = now.Add(time.Second)
+	require.NoError(t, f.Rotate())
+	assert.FileExists(t, other)
+	backups, _ = filepath.Glob(path + ".2*")
+	assert.Len(t, backups, 2)
+
+	// test: a failed rotation keeps logging to the same file
+	now = now.Add(time.Second)
+	require.NoError(t, os.MkdirAll(filepath.Join(path+"."+now.Format(backupTimeFormat), "x"), 0o755))
+	require.Error(t, f.Rotate())
+	_, err = f.Write([]byte("ghi\n"))
+	require.NoError(t, err)
+	data, _ = os.ReadFile(path)
+	assert.Equal(t, "ghi\n", string(data))
+
+	// test: writes after close fail
+	require.NoError(t, f.Close())
+	_, err = f.Write([]byte("x"))
+	require.Error(t, err)
+}
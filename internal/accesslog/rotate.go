@@ -0,0 +1,181 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// suffix appended to rotated files, it sorts chronologically
+const backupTimeFormat = "20060102-150405.000"
+
+type RotateOptions struct {
+	// rotates before a write would grow the file past MaxSize
+	// bytes, never when zero
+	MaxSize int64
+	// rotates when the file is older than Interval, never when zero
+	Interval time.Duration
+	// rotated files kept, all of them when zero
+	MaxBackups int
+}
+
+// RotatingFile is an io.Writer appending to a file that is renamed
+// to '<path>.<time>' and started over by size or age
+type RotatingFile struct {
+	path string
+	opts RotateOptions
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	now    func() time.Time
+}
+
+func NewRotatingFile(path string, opts RotateOptions) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, opts: opts, now: time.Now}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	tooBig := f.opts.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.opts.MaxSize
+	tooOld := f.opts.Interval > 0 && f.now().Sub(f.opened) >= f.opts.Interval
+	if tooBig || tooOld {
+		// a failed rotation leaves the file reopened when possible,
+		// the line still goes to it
+		err := f.rotate()
+		if f.file == nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+func (f *RotatingFile) Rotate() error {
+	/*
+	* @brief: starts a new file right away, e.g. on SIGHUP
+	*/
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return os.ErrClosed
+	}
+
+	return f.rotate()
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+
+	return err
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.opened = f.now()
+
+	return nil
+}
+
+func (f *RotatingFile) rotate() error {
+	/*
+	* renames the file and opens a new one, when the rename fails
+	* the original path is reopened so logging goes on
+	*/
+	closeErr := f.file.Close()
+	f.file = nil
+
+	renameErr := os.Rename(f.path, f.path+"."+f.now().Format(backupTimeFormat))
+
+	err := f.open()
+	if err != nil {
+		return err
+	}
+
+	if renameErr != nil {
+		return fmt.Errorf("failed to rotate log file: %v", renameErr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to rotate log file: %v", closeErr)
+	}
+
+	f.prune()
+
+	return nil
+}
+
+func (f *RotatingFile) prune() {
+	if f.opts.MaxBackups <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return
+	}
+
+	// only names rotate gave, other files sharing the prefix are
+	// left alone
+	prefix := filepath.Base(f.path) + "."
+	var backups []string
+	for _, entry := range entries {
+		suffix, found := strings.CutPrefix(entry.Name(), prefix)
+		if !found || entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, suffix); err == nil {
+			backups = append(backups, filepath.Join(filepath.Dir(f.path), entry.Name()))
+		}
+	}
+
+	if len(backups) <= f.opts.MaxBackups {
+		return
+	}
+
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-f.opts.MaxBackups] {
+		os.Remove(name)
+	}
+}
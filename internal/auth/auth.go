@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+const DefaultRealm = "restricted"
+
+var (
+	// the request carries no credentials for the scheme, the
+	// next authenticator gets a chance
+	ErrNoCredentials = errors.New("auth: no credentials")
+	// credentials were sent but are wrong or malformed
+	ErrInvalidCredentials = errors.New("auth: invalid credentials")
+)
+
+// Principal is who a request was authenticated as
+type Principal struct {
+	// user name, key owner or token subject
+	ID string
+	// "basic", "bearer" or "apikey"
+	Scheme string
+	// what the validator attached, e.g. roles or token claims
+	Attributes map[string]any
+}
+
+// Authenticator checks the credentials of one scheme
+type Authenticator interface {
+	// returns ErrNoCredentials when the request has none for
+	// the scheme, errors wrap ErrInvalidCredentials otherwise
+	Authenticate(req *request.Request) (*Principal, error)
+	// the 'WWW-Authenticate' challenge, err is the reason the
+	// credentials were refused or nil if there were none
+	Challenge(err error) string
+}
+
+type Options struct {
+	// tried in order, the first one finding credentials decides
+	Authenticators []Authenticator
+	// requests without credentials go through anonymously,
+	// invalid credentials are still refused
+	Optional bool
+}
+
+type contextKey struct{}
+
+func Middleware(opts Options) response.Middleware {
+	/*
+	* @brief: authenticates requests and puts the principal on
+	* the request context, the others get a 401 with the
+	* challenges of every authenticator
+	*/
+	return func(next response.Handler) response.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			for i, a := range opts.Authenticators {
+				p, err := a.Authenticate(req)
+				if errors.Is(err, ErrNoCredentials) {
+					continue
+				}
+				if err != nil {
+					unauthorized(w, opts.Authenticators, i, err)
+					return
+				}
+
+				req.SetContext(context.WithValue(req.Context(), contextKey{}, p))
+				next(w, req)
+				return
+			}
+
+			if opts.Optional {
+				next(w, req)
+				return
+			}
+
+			unauthorized(w, opts.Authenticators, -1, nil)
+		}
+	}
+}
+
+func FromContext(ctx context.Context) *Principal {
+	/*
+	* returns the principal attached by Middleware, nil for
+	* anonymous requests
+	*/
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+
+	return p
+}
+
+func FromRequest(req *request.Request) *Principal {
+	return FromContext(req.Context())
+}
+
+func SecureCompare(a, b string) bool {
+	/*
+	* @brief: compares secrets in constant time, hashing first
+	* so the time does not depend on their lengths either
+	*/
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+func unauthorized(w *response.Writer, authenticators []Authenticator, failed int, err error) {
+	challenges := make([]string, 0, len(authenticators))
+	for i, a := range authenticators {
+		if i == failed {
+			challenges = append(challenges, a.Challenge(err))
+		} else {
+			challenges = append(challenges, a.Challenge(nil))
+		}
+	}
+
+	body := fmt.Sprintf("%d %s", response.CodeUnauthorized, response.StatusText(response.CodeUnauthorized))
+	h := headers.GetDefaultHeaders(len(body))
+	if len(challenges) > 0 {
+		h.AddOverride("WWW-Authenticate", strings.Join(challenges, ", "))
+	}
+	w.Response = &response.Response{
+		Code:    response.CodeUnauthorized,
+		Message: []byte(body),
+		Headers: h,
+	}
+	w.WriteResponse()
+}
+
+func credentials(req *request.Request, scheme string) (string, bool) {
+	/*
+	* returns the credentials of the 'Authorization' header
+	* when it uses scheme
+	*/
+	value, ok := req.Headers.Get("Authorization")
+	given, credentials, _ := strings.Cut(strings.TrimSpace(value), " ")
+	if !ok || !strings.EqualFold(given, scheme) {
+		return "", false
+	}
+
+	return strings.TrimSpace(credentials), true
+}
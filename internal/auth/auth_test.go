@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+	"Servus/internal/server"
+)
+
+func TestHtpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	// test: the '$2y$' prefix of htpasswd is accepted
+	file := "# users\n\nalice:" + string(hash) + "\nbob:$2y$" + string(hash[4:]) + "\n"
+
+	h, err := ParseHtpasswd(strings.NewReader(file))
+	require.NoError(t, err)
+	assert.True(t, h.Verify("alice", "secret"))
+	assert.True(t, h.Verify("bob", "secret"))
+	assert.False(t, h.Verify("alice", "wrong"))
+	assert.False(t, h.Verify("carol", "secret"))
+
+	// test: other hash formats are refused
+	_, err = ParseHtpasswd(strings.NewReader("alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+	assert.Error(t, err)
+	_, err = ParseHtpasswd(strings.NewReader("alice\n"))
+	assert.Error(t, err)
+
+	assert.True(t, SecureCompare("key", "key"))
+	assert.False(t, SecureCompare("key", "key2"))
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		body := "anonymous"
+		if p := FromRequest(req); p != nil {
+			body = p.Scheme + ":" + p.ID
+		}
+		w.Response = &response.Response{
+			Code:    response.CodeOK,
+			Message: []byte(body),
+			Headers: headers.GetDefaultHeaders(len(body)),
+		}
+		w.WriteResponse()
+	}
+
+	serve := func(opts Options) string {
+		s, err := server.Serve(0, response.Chain(handler, Middleware(opts)))
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+
+		return s.Addr().String()
+	}
+	send := func(addr, target, extra string) string {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		_, err = conn.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n" + extra + "\r\n"))
+		require.NoError(t, err)
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+
+		return string(resp)
+	}
+	basic := func(user, password string) string {
+		return "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password)) + "\r\n"
+	}
+
+	verify := func(user, password string) bool {
+		return user == "alice" && SecureCompare(password, "secret")
+	}
+	validate := func(token string) (*Principal, error) {
+		if token != "token" {
+			return nil, errors.New("expired")
+		}
+		return &Principal{ID: "svc", Attributes: map[string]any{"scope": "read"}}, nil
+	}
+
+	addr := serve(Options{Authenticators: []Authenticator{
+		NewBasic("servus", verify),
+		NewBearer("", validate),
+		NewAPIKey(APIKeyOptions{Header: "X-API-Key", Query: "api_key", Validate: StaticKeys(map[string]string{"k1": "robot"})}),
+	}})
+
+	// test: every scheme sets its principal
+	assert.Contains(t, send(addr, "/", basic("alice", "secret")), "\r\n\r\nbasic:alice")
+	assert.Contains(t, send(addr, "/", "Authorization: bearer token\r\n"), "\r\n\r\nbearer:svc")
+	assert.Contains(t, send(addr, "/", "X-API-Key: k1\r\n"), "\r\n\r\napikey:robot")
+	assert.Contains(t, send(addr, "/?api_key=k1", ""), "\r\n\r\napikey:robot")
+
+	// test: without credentials every challenge is sent
+	resp := send(addr, "/", "")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 401 Unauthorized\r\n"), resp)
+	assert.Contains(t, resp, `www-authenticate: Basic realm="servus", charset="UTF-8", Bearer realm="restricted", APIKey header="X-API-Key"`+"\r\n")
+	assert.True(t, strings.HasSuffix(resp, "401 Unauthorized"), resp)
+
+	// test: refused credentials are not tried against the next scheme
+	assert.Contains(t, send(addr, "/", basic("alice", "wrong")), "401 Unauthorized")
+	assert.Contains(t, send(addr, "/", "Authorization: Basic !!!\r\n"), "401 Unauthorized")
+	resp = send(addr, "/", "Authorization: Bearer old\r\nX-API-Key: k1\r\n")
+	assert.Contains(t, resp, "401 Unauthorized")
+	assert.Contains(t, resp, `Bearer realm="restricted", error="invalid_token"`)
+	assert.Contains(t, send(addr, "/?api_key=k2", ""), "401 Unauthorized")
+
+	// test: optional authentication lets anonymous requests through
+	addr = serve(Options{Authenticators: []Authenticator{NewBasic("", verify)}, Optional: true})
+	assert.Contains(t, send(addr, "/", ""), "\r\n\r\nanonymous")
+	assert.Contains(t, send(addr, "/", basic("alice", "secret")), "\r\n\r\nbasic:alice")
+	assert.Contains(t, send(addr, "/", basic("alice", "wrong")), "401 Unauthorized")
+}
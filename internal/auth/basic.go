@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"Servus/internal/request"
+)
+
+// Basic authenticates with HTTP Basic, RFC 7617
+type Basic struct {
+	realm  string
+	verify func(user, password string) bool
+}
+
+// Htpasswd holds the users of an htpasswd file, only bcrypt hashes
+// as written by 'htpasswd -B' are supported
+type Htpasswd struct {
+	users map[string][]byte
+}
+
+var dummyHash struct {
+	once sync.Once
+	hash []byte
+}
+
+func NewBasic(realm string, verify func(user, password string) bool) *Basic {
+	/*
+	* @brief: checks user and password with verify, which should
+	* compare with SecureCompare or Htpasswd.Verify. the realm
+	* is DefaultRealm when empty
+	*/
+	if realm == "" {
+		realm = DefaultRealm
+	}
+
+	return &Basic{realm: realm, verify: verify}
+}
+
+func (b *Basic) Authenticate(req *request.Request) (*Principal, error) {
+	encoded, ok := credentials(req, "Basic")
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed basic credentials", ErrInvalidCredentials)
+	}
+
+	user, password, found := strings.Cut(string(decoded), ":")
+	if !found || !b.verify(user, password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Principal{ID: user, Scheme: "basic"}, nil
+}
+
+func (b *Basic) Challenge(err error) string {
+	return "Basic realm=" + strconv.Quote(b.realm) + `, charset="UTF-8"`
+}
+
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseHtpasswd(f)
+}
+
+func ParseHtpasswd(r io.Reader) (*Htpasswd, error) {
+	/*
+	* @brief: reads 'user:hash' lines, blank lines and the ones
+	* starting with '#' are skipped
+	*/
+	h := &Htpasswd{users: map[string][]byte{}}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, found := strings.Cut(line, ":")
+		if !found || user == "" {
+			return nil, fmt.Errorf("htpasswd line %d: expected user:hash", n)
+		}
+
+		if !strings.HasPrefix(hash, "$2a$") && !strings.HasPrefix(hash, "$2b$") && !strings.HasPrefix(hash, "$2y$") {
+			return nil, fmt.Errorf("htpasswd line %d: only bcrypt hashes are supported", n)
+		}
+
+		// bcrypt only knows the '$2a$' prefix, the variants differ
+		// in bugs of other implementations
+		h.users[user] = []byte("$2a$" + hash[4:])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (h *Htpasswd) Verify(user, password string) bool {
+	/*
+	* @brief: reports whether password is the one of user, unknown
+	* users take as long as known ones
+	*/
+	hash, ok := h.users[user]
+	if !ok {
+		dummyHash.once.Do(func() {
+			dummyHash.hash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash.hash, []byte(password))
+
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+
+	"Servus/internal/request"
+)
+
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// Bearer authenticates with bearer tokens, RFC 6750
+type Bearer struct {
+	realm    string
+	validate func(token string) (*Principal, error)
+}
+
+// APIKey authenticates with a key sent in a header or a query
+// parameter
+type APIKey struct {
+	opts APIKeyOptions
+}
+
+type APIKeyOptions struct {
+	// header carrying the key, DefaultAPIKeyHeader when both
+	// Header and Query are empty
+	Header string
+	// query parameter carrying the key, looked at when the
+	// header is missing
+	Query string
+	// returns the principal owning key, errors refuse it
+	Validate func(key string) (*Principal, error)
+}
+
+func NewBearer(realm string, validate func(token string) (*Principal, error)) *Bearer {
+	/*
+	* @brief: checks tokens with validate, a nil principal is
+	* taken as one with an empty ID. the realm is DefaultRealm
+	* when empty
+	*/
+	if realm == "" {
+		realm = DefaultRealm
+	}
+
+	return &Bearer{realm: realm, validate: validate}
+}
+
+func (b *Bearer) Authenticate(req *request.Request) (*Principal, error) {
+	token, ok := credentials(req, "Bearer")
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	if token == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	p, err := b.validate(token)
+
+	return validated(p, err, "bearer")
+}
+
+func (b *Bearer) Challenge(err error) string {
+	/*
+	* refused tokens get 'error="invalid_token"' so clients know
+	* to get a new one instead of asking the user
+	*/
+	challenge := "Bearer realm=" + strconv.Quote(b.realm)
+	if err != nil {
+		challenge += `, error="invalid_token"`
+	}
+
+	return challenge
+}
+
+func NewAPIKey(opts APIKeyOptions) *APIKey {
+	if opts.Header == "" && opts.Query == "" {
+		opts.Header = DefaultAPIKeyHeader
+	}
+
+	return &APIKey{opts: opts}
+}
+
+func (a *APIKey) Authenticate(req *request.Request) (*Principal, error) {
+	var key string
+	if a.opts.Header != "" {
+		key, _ = req.Headers.Get(a.opts.Header)
+	}
+	if key == "" && a.opts.Query != "" {
+		key = req.Query().Get(a.opts.Query)
+	}
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	p, err := a.opts.Validate(key)
+
+	return validated(p, err, "apikey")
+}
+
+func (a *APIKey) Challenge(err error) string {
+	/*
+	* there is no registered scheme for API keys, the challenge
+	* tells where the key is expected
+	*/
+	if a.opts.Header != "" {
+		return "APIKey header=" + strconv.Quote(a.opts.Header)
+	}
+
+	return "APIKey query=" + strconv.Quote(a.opts.Query)
+}
+
+func StaticKeys(keys map[string]string) func(key string) (*Principal, error) {
+	/*
+	* @brief: validates against a fixed set of keys mapped to the
+	* ID of their owner, every key is compared in constant time
+	*/
+	return func(key string) (*Principal, error) {
+		var owner string
+		found := false
+		for k, id := range keys {
+			if SecureCompare(key, k) && !found {
+				owner = id
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, ErrInvalidCredentials
+		}
+
+		return &Principal{ID: owner}, nil
+	}
+}
+
+func validated(p *Principal, err error, scheme string) (*Principal, error) {
+	if err != nil {
+		if !errors.Is(err, ErrInvalidCredentials) {
+			err = errors.Join(ErrInvalidCredentials, err)
+		}
+		return nil, err
+	}
+
+	if p == nil {
+		p = &Principal{}
+	}
+	p.Scheme = scheme
+
+	return p, nil
+}
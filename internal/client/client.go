@@ -0,0 +1,471 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"Servus/internal/headers"
+)
+
+const (
+	DefaultDialTimeout           = 10 * time.Second
+	DefaultResponseHeaderTimeout = 30 * time.Second
+	DefaultIdleConnTimeout       = 90 * time.Second
+	DefaultMaxIdleConns          = 4
+	DefaultMaxRedirects          = 10
+
+	userAgent = "servus-client"
+)
+
+var ErrTooManyRedirects = errors.New("client: too many redirects")
+
+type Options struct {
+	DialTimeout time.Duration
+	// time allowed between sending the request and receiving
+	// the response head
+	ResponseHeaderTimeout time.Duration
+	// time allowed between reads of the response body, no
+	// limit when zero
+	ReadTimeout     time.Duration
+	IdleConnTimeout time.Duration
+	// idle connections kept per host
+	MaxIdleConns int
+	// redirects followed before giving up, DefaultMaxRedirects
+	// if zero and none if negative
+	MaxRedirects int
+	// used for 'https' urls
+	TLSConfig *tls.Config
+	// replaces net.DialTimeout when set
+	Dial func(addr string) (net.Conn, error)
+}
+
+// DialError is a failure to connect, including the TLS
+// handshake, the request was not sent
+type DialError struct {
+	Err error
+}
+
+// Client sends HTTP/1.1 requests, keeping idle connections
+// around for reuse. It is safe for concurrent use
+type Client struct {
+	opts Options
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+type Request struct {
+	Method string
+	URL    *url.URL
+	// 'Host', 'User-Agent' and 'Content-Length' are filled
+	// in when missing, an empty 'User-Agent' is not sent
+	Headers headers.Headers
+	Body    []byte
+}
+
+type persistConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	key       string
+	idleSince time.Time
+}
+
+func New(opts Options) *Client {
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = DefaultDialTimeout
+	}
+	if opts.ResponseHeaderTimeout == 0 {
+		opts.ResponseHeaderTimeout = DefaultResponseHeaderTimeout
+	}
+	if opts.IdleConnTimeout == 0 {
+		opts.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+	if opts.MaxIdleConns == 0 {
+		opts.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if opts.MaxRedirects == 0 {
+		opts.MaxRedirects = DefaultMaxRedirects
+	}
+
+	return &Client{opts: opts, idle: map[string][]*persistConn{}}
+}
+
+func NewRequest(method, rawURL string, body []byte) (*Request, error) {
+	/*
+	* @brief: builds a request to an absolute 'http' or
+	* 'https' url
+	*/
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %q: %v", rawURL, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid url %q: unsupported scheme", rawURL)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q: missing host", rawURL)
+	}
+
+	return &Request{Method: method, URL: u, Headers: headers.Headers{}, Body: body}, nil
+}
+
+func (r *Request) Write(w io.Writer) error {
+	/*
+	* @brief: serializes the request in origin-form
+	*/
+	h := headers.Headers{}
+	for key, value := range r.Headers {
+		h.AddOverride(key, value)
+	}
+
+	if _, ok := h.Get("Host"); !ok {
+		h.AddOverride("Host", r.URL.Host)
+	}
+
+	if value, ok := h.Get("User-Agent"); !ok {
+		h.AddOverride("User-Agent", userAgent)
+	} else if value == "" {
+		h.Delete("User-Agent")
+	}
+
+	if len(r.Body) > 0 || r.Method == "POST" || r.Method == "PUT" {
+		h.AddOverride("Content-Length", strconv.Itoa(len(r.Body)))
+	}
+
+	var b bytes.Buffer
+	b.WriteString(r.Method + " " + r.URL.RequestURI() + " HTTP/1.1\r\n")
+	for key, value := range h {
+		b.WriteString(key + ": " + value + "\r\n")
+	}
+	b.WriteString("\r\n")
+	b.Write(r.Body)
+
+	_, err := w.Write(b.Bytes())
+
+	return err
+}
+
+func (c *Client) Get(rawURL string) (*Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Do(req)
+}
+
+func (c *Client) Post(rawURL, contentType string, body []byte) (*Response, error) {
+	req, err := NewRequest("POST", rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Headers.AddOverride("Content-Type", contentType)
+
+	return c.Do(req)
+}
+
+func (c *Client) Do(req *Request) (*Response, error) {
+	/*
+	* @brief: sends req and returns the response, following
+	* redirects. the caller must close the response body
+	*/
+	for redirects := 0; ; redirects++ {
+		resp, err := c.roundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		next := redirectRequest(req, resp)
+		if next == nil || c.opts.MaxRedirects < 0 {
+			return resp, nil
+		}
+
+		// a small body is drained so the connection can be reused
+		io.CopyN(io.Discard, resp.Body, 4<<10)
+		resp.Body.Close()
+
+		if redirects >= c.opts.MaxRedirects {
+			return nil, ErrTooManyRedirects
+		}
+		req = next
+	}
+}
+
+func (c *Client) Close() {
+	/*
+	* @brief: closes the idle connections
+	*/
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, conns := range c.idle {
+		for _, pc := range conns {
+			pc.conn.Close()
+		}
+		delete(c.idle, key)
+	}
+}
+
+func redirectRequest(req *Request, resp *Response) *Request {
+	/*
+	* returns the request a redirect asks for, nil when resp
+	* is not a redirect that can be followed
+	*
+	* 303, and 301 or 302 to a POST, continue with a GET without
+	* body, 307 and 308 repeat the request as it was
+	*/
+	switch resp.StatusCode {
+	case 301, 302, 303, 307, 308:
+	default:
+		return nil
+	}
+
+	location, ok := resp.Headers.Get("Location")
+	if !ok {
+		return nil
+	}
+
+	target, err := req.URL.Parse(location)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		return nil
+	}
+
+	next := &Request{Method: req.Method, URL: target, Headers: headers.Headers{}, Body: req.Body}
+	for key, value := range req.Headers {
+		next.Headers[key] = value
+	}
+	next.Headers.Delete("Host")
+
+	code := resp.StatusCode
+	if (code == 303 && req.Method != "HEAD") || ((code == 301 || code == 302) && req.Method == "POST") {
+		next.Method = "GET"
+		next.Body = nil
+		next.Headers.Delete("Content-Type")
+		next.Headers.Delete("Content-Length")
+	}
+
+	// credentials are not handed to another host
+	if target.Host != req.URL.Host {
+		next.Headers.Delete("Authorization")
+		next.Headers.Delete("Cookie")
+	}
+
+	return next
+}
+
+func (c *Client) roundTrip(req *Request) (*Response, error) {
+	/*
+	* a pooled connection the server closed in the meantime is
+	* retried once on a fresh one when the request is idempotent
+	*/
+	for attempt := 0; ; attempt++ {
+		pc, reused, err := c.get(req.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, retry, err := c.exchange(pc, req)
+		if err == nil {
+			resp.Request = req
+			return resp, nil
+		}
+
+		pc.conn.Close()
+		if !(reused && retry && attempt == 0) {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) exchange(pc *persistConn, req *Request) (*Response, bool, error) {
+	pc.conn.SetDeadline(time.Now().Add(c.opts.ResponseHeaderTimeout))
+
+	err := req.Write(pc.conn)
+	if err != nil {
+		return nil, true, err
+	}
+
+	_, err = pc.reader.Peek(1)
+	if err != nil {
+		// nothing was read, the server closed an idle connection
+		stale := errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)
+		return nil, stale && isIdempotent(req.Method), err
+	}
+
+	resp, err := ReadResponse(pc.reader, req.Method)
+	if err != nil {
+		return nil, false, err
+	}
+	pc.conn.SetDeadline(time.Time{})
+
+	if resp.StatusCode == 101 {
+		resp.Body = &upgradedBody{Reader: pc.reader, conn: pc.conn}
+		return resp, false, nil
+	}
+
+	resp.Body = &bodyReader{c: c, pc: pc, r: resp.Body, reusable: resp.reusable}
+
+	return resp, false, nil
+}
+
+func (c *Client) get(u *url.URL) (*persistConn, bool, error) {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	key := u.Scheme + "://" + addr
+
+	c.mu.Lock()
+	for len(c.idle[key]) > 0 {
+		conns := c.idle[key]
+		pc := conns[len(conns)-1]
+		c.idle[key] = conns[:len(conns)-1]
+
+		if time.Since(pc.idleSince) < c.opts.IdleConnTimeout {
+			c.mu.Unlock()
+			return pc, true, nil
+		}
+		pc.conn.Close()
+	}
+	c.mu.Unlock()
+
+	var conn net.Conn
+	var err error
+	if c.opts.Dial != nil {
+		conn, err = c.opts.Dial(addr)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, c.opts.DialTimeout)
+	}
+	if err != nil {
+		return nil, false, &DialError{err}
+	}
+
+	if u.Scheme == "https" {
+		config := &tls.Config{}
+		if c.opts.TLSConfig != nil {
+			config = c.opts.TLSConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		if len(config.NextProtos) == 0 {
+			config.NextProtos = []string{"http/1.1"}
+		}
+
+		tlsConn := tls.Client(conn, config)
+		tlsConn.SetDeadline(time.Now().Add(c.opts.DialTimeout))
+		err = tlsConn.Handshake()
+		if err != nil {
+			conn.Close()
+			return nil, false, &DialError{err}
+		}
+		conn = tlsConn
+	}
+
+	return &persistConn{conn: conn, reader: bufio.NewReader(conn), key: key}, false, nil
+}
+
+func (c *Client) put(pc *persistConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.opts.MaxIdleConns < 0 || len(c.idle[pc.key]) >= c.opts.MaxIdleConns {
+		pc.conn.Close()
+		return
+	}
+
+	pc.idleSince = time.Now()
+	c.idle[pc.key] = append(c.idle[pc.key], pc)
+}
+
+func isIdempotent(method string) bool {
+	switch strings.ToUpper(method) {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+
+	return false
+}
+
+func (e *DialError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+// bodyReader hands the connection back to the pool once the whole
+// body was read, reads are bounded by Options.ReadTimeout
+type bodyReader struct {
+	c        *Client
+	pc       *persistConn
+	r        io.Reader
+	reusable bool
+	done     bool
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.done {
+		return 0, io.EOF
+	}
+
+	if b.c.opts.ReadTimeout > 0 {
+		b.pc.conn.SetReadDeadline(time.Now().Add(b.c.opts.ReadTimeout))
+	}
+
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		b.done = true
+		b.pc.conn.SetReadDeadline(time.Time{})
+		if b.reusable && b.pc.reader.Buffered() == 0 {
+			b.c.put(b.pc)
+		} else {
+			b.pc.conn.Close()
+		}
+	}
+
+	return n, err
+}
+
+func (b *bodyReader) Close() error {
+	// a body that was not read to the end leaves the connection unusable
+	if !b.done {
+		b.done = true
+		return b.pc.conn.Close()
+	}
+
+	return nil
+}
+
+// upgradedBody is the connection after a 101, reads go through
+// the buffered reader first
+type upgradedBody struct {
+	io.Reader
+	conn net.Conn
+}
+
+func (u *upgradedBody) Write(p []byte) (int, error) {
+	return u.conn.Write(p)
+}
+
+func (u *upgradedBody) Close() error {
+	return u.conn.Close()
+}
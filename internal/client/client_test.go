@@ -0,0 +1,259 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Servus/internal/request"
+)
+
+func parse(t *testing.T, raw, method string) (*Response, string) {
+	resp, err := ReadResponse(bufio.NewReader(strings.NewReader(raw)), method)
+	require.NoError(t, err)
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+
+	return resp, string(body)
+}
+
+// rawServer answers each request on a keep-alive connection
+// with the raw response returned by respond
+func rawServer(t *testing.T, respond func(req *request.Request) string) (string, *atomic.Int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+
+			go func() {
+				defer conn.Close()
+				for {
+					req, err := request.RequestFromReader(conn)
+					if err != nil {
+						return
+					}
+
+					raw := respond(req)
+					if raw == "" {
+						return
+					}
+					conn.Write([]byte(raw))
+				}
+			}()
+		}
+	}()
+
+	return "http://" + l.Addr().String(), &accepted
+}
+
+func TestReadResponse(t *testing.T) {
+	// test: content length, cookies kept apart from the headers
+	resp, body := parse(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nX-Test: a\r\n"+
+		"Set-Cookie: a=1; Path=/\r\nSet-Cookie: b=2; HttpOnly\r\n\r\nhello", "GET")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "OK", resp.Status)
+	assert.Equal(t, "HTTP/1.1", resp.Proto)
+	assert.Equal(t, int64(5), resp.ContentLength)
+	assert.Equal(t, "a", resp.Headers["x-test"])
+	assert.Equal(t, "hello", body)
+	require.Len(t, resp.Cookies, 2)
+	assert.Equal(t, "a", resp.Cookies[0].Name)
+	assert.True(t, resp.Cookies[1].HttpOnly)
+	assert.Equal(t, []string{"a=1; Path=/", "b=2; HttpOnly"}, resp.SetCookies)
+	assert.True(t, resp.reusable)
+
+	// test: chunked body with extensions and trailers
+	resp, body = parse(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5;ext=1\r\nhello\r\n7\r\n, world\r\n0\r\nX-Trailer: 1\r\n\r\n", "GET")
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Equal(t, "hello, world", body)
+
+	// test: body delimited by the connection closing
+	resp, body = parse(t, "HTTP/1.0 200 OK\r\n\r\nuntil the end", "GET")
+	assert.Equal(t, "until the end", body)
+	assert.False(t, resp.reusable)
+
+	// test: interim responses are skipped
+	resp, body = parse(t, "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </a.css>\r\n\r\n"+
+		"HTTP/1.1 201 Created\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok", "POST")
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "ok", body)
+	assert.False(t, resp.reusable)
+
+	// test: responses without a body
+	resp, body = parse(t, "HTTP/1.1 200 OK\r\nContent-Length: 42\r\n\r\n", "HEAD")
+	assert.Equal(t, int64(42), resp.ContentLength)
+	assert.Equal(t, "", body)
+	resp, body = parse(t, "HTTP/1.1 304 Not Modified\r\n\r\n", "GET")
+	assert.Equal(t, 304, resp.StatusCode)
+	assert.Equal(t, "", body)
+
+	// test: a protocol switch leaves the rest of the stream in the body
+	resp, body = parse(t, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\n\r\nraw bytes", "GET")
+	assert.Equal(t, 101, resp.StatusCode)
+	assert.Equal(t, "raw bytes", body)
+
+	// test: malformed responses
+	for _, raw := range []string{
+		"SSH-2.0-OpenSSH\r\n\r\n",
+		"HTTP/1.1 2000 OK\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nbad header\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n",
+	} {
+		_, err := ReadResponse(bufio.NewReader(strings.NewReader(raw)), "GET")
+		assert.ErrorIs(t, err, ErrMalformedResponse, raw)
+	}
+
+	// test: truncated bodies
+	resp, err := ReadResponse(bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort")), "GET")
+	require.NoError(t, err)
+	_, err = resp.ReadBody()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	resp, err = ReadResponse(bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel")), "GET")
+	require.NoError(t, err)
+	_, err = resp.ReadBody()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestClientReuse(t *testing.T) {
+	base, accepted := rawServer(t, func(req *request.Request) string {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	})
+	c := New(Options{})
+	defer c.Close()
+
+	// test: read bodies give the connection back
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(base + "/")
+		require.NoError(t, err)
+		body, err := resp.ReadBody()
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(body))
+	}
+	assert.Equal(t, int32(1), accepted.Load())
+
+	// test: a body closed early discards the connection
+	resp, err := c.Get(base + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	resp, err = c.Get(base + "/")
+	require.NoError(t, err)
+	resp.ReadBody()
+	assert.Equal(t, int32(2), accepted.Load())
+
+	// test: a connection closed by the server is retried
+	var served atomic.Int32
+	base, accepted = rawServer(t, func(req *request.Request) string {
+		if served.Add(1)%2 == 0 {
+			// drop the connection instead of answering
+			return ""
+		}
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	})
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(base + "/")
+		require.NoError(t, err)
+		body, err := resp.ReadBody()
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(body))
+	}
+	assert.Equal(t, int32(2), accepted.Load())
+
+	// test: non idempotent requests are not retried
+	_, err = c.Post(base+"/", "text/plain", []byte("x"))
+	require.Error(t, err)
+	assert.Equal(t, int32(2), accepted.Load())
+}
+
+func TestClientRedirects(t *testing.T) {
+	base, _ := rawServer(t, func(req *request.Request) string {
+		target := req.RequestLine.RequestTarget
+		switch {
+		case target == "/see-other":
+			return "HTTP/1.1 303 See Other\r\nLocation: /final\r\nContent-Length: 0\r\n\r\n"
+		case target == "/temporary":
+			return "HTTP/1.1 307 Temporary Redirect\r\nLocation: final\r\nContent-Length: 4\r\n\r\nmove"
+		case target == "/loop":
+			return "HTTP/1.1 302 Found\r\nLocation: /loop\r\nContent-Length: 0\r\n\r\n"
+		}
+
+		body := req.RequestLine.Method + " " + target + " " + string(req.Body) + " " + req.Headers["authorization"]
+		return "HTTP/1.1 200 OK\r\nContent-Length: " + fmt.Sprint(len(body)) + "\r\n\r\n" + body
+	})
+
+	c := New(Options{MaxRedirects: 3})
+	defer c.Close()
+
+	// test: 303 continues with a GET
+	resp, err := c.Post(base+"/see-other", "text/plain", []byte("data"))
+	require.NoError(t, err)
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "GET /final  ", string(body))
+	assert.Equal(t, "/final", resp.Request.URL.Path)
+
+	// test: 307 repeats the request with its body and headers
+	req, err := NewRequest("PUT", base+"/temporary", []byte("data"))
+	require.NoError(t, err)
+	req.Headers.Add("Authorization", "Bearer t")
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	body, err = resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "PUT /final data Bearer t", string(body))
+
+	// test: redirect loops give up
+	_, err = c.Get(base + "/loop")
+	assert.ErrorIs(t, err, ErrTooManyRedirects)
+
+	// test: redirects are returned as is when disabled
+	c = New(Options{MaxRedirects: -1})
+	defer c.Close()
+	resp, err = c.Get(base + "/loop")
+	require.NoError(t, err)
+	resp.ReadBody()
+	assert.Equal(t, 302, resp.StatusCode)
+}
+
+func TestClientTimeouts(t *testing.T) {
+	base, _ := rawServer(t, func(req *request.Request) string {
+		if req.RequestLine.RequestTarget == "/slow-head" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n"
+	})
+
+	c := New(Options{ResponseHeaderTimeout: 50 * time.Millisecond, ReadTimeout: 50 * time.Millisecond})
+	defer c.Close()
+
+	// test: the head takes too long
+	_, err := c.Get(base + "/slow-head")
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr), err)
+	assert.True(t, netErr.Timeout())
+
+	// test: the body stalls
+	resp, err := c.Get(base + "/")
+	require.NoError(t, err)
+	_, err = resp.ReadBody()
+	require.True(t, errors.As(err, &netErr), err)
+	assert.True(t, netErr.Timeout())
+}
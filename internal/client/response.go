@@ -0,0 +1,315 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"Servus/internal/cookie"
+	"Servus/internal/headers"
+)
+
+// longest status or header line accepted
+const maxLineSize = 64 << 10
+
+var ErrMalformedResponse = errors.New("client: malformed response")
+
+type Response struct {
+	StatusCode int
+	// reason phrase of the status line
+	Status string
+	// 'HTTP/1.1' or 'HTTP/1.0'
+	Proto   string
+	Headers headers.Headers
+	// parsed 'Set-Cookie' lines, they cannot be folded into
+	// a single headers entry. the lines that do not parse are
+	// only in SetCookies
+	Cookies []*cookie.Cookie
+	// the 'Set-Cookie' values as received, for relaying them
+	SetCookies []string
+	// -1 when the length is not known in advance
+	ContentLength int64
+	// for a 101 the body is the upgraded connection, it also
+	// implements io.Writer
+	Body io.ReadCloser
+	// the request answered, the last one when redirects
+	// were followed
+	Request *Request
+
+	// the connection can carry another request once the
+	// body is read
+	reusable bool
+}
+
+func (r *Response) ReadBody() ([]byte, error) {
+	/*
+	* @brief: reads the whole body and closes it
+	*/
+	defer r.Body.Close()
+
+	return io.ReadAll(r.Body)
+}
+
+func ReadResponse(r *bufio.Reader, method string) (*Response, error) {
+	/*
+	* @brief: parses a response to a request with the given
+	* method, the body is left in r and read through Body
+	*
+	* interim 1xx responses are skipped except 101, after
+	* which r carries the upgraded protocol
+	*/
+	for {
+		resp, err := readHead(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != 101 {
+			continue
+		}
+
+		err = resp.attachBody(r, method)
+		if err != nil {
+			return nil, err
+		}
+
+		return resp, nil
+	}
+}
+
+func readHead(r *bufio.Reader) (*Response, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	proto, status, _ := strings.Cut(line, " ")
+	if proto != "HTTP/1.1" && proto != "HTTP/1.0" {
+		return nil, fmt.Errorf("%w: invalid status line %q", ErrMalformedResponse, line)
+	}
+
+	codeString, reason, _ := strings.Cut(status, " ")
+	code, err := strconv.Atoi(codeString)
+	if err != nil || len(codeString) != 3 || code < 100 {
+		return nil, fmt.Errorf("%w: invalid status code %q", ErrMalformedResponse, codeString)
+	}
+
+	resp := &Response{
+		StatusCode: code,
+		Status:     reason,
+		Proto:      proto,
+		Headers:    headers.Headers{},
+	}
+
+	for {
+		line, err = readLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if line == "" {
+			return resp, nil
+		}
+
+		name, value, _ := strings.Cut(line, ":")
+		if strings.EqualFold(name, "set-cookie") {
+			resp.SetCookies = append(resp.SetCookies, strings.TrimSpace(value))
+			c, err := cookie.ParseSetCookie(strings.TrimSpace(value))
+			if err == nil {
+				resp.Cookies = append(resp.Cookies, c)
+			}
+			continue
+		}
+
+		_, _, err = resp.Headers.Parse([]byte(line + "\r\n"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+		}
+	}
+}
+
+func (resp *Response) attachBody(r *bufio.Reader, method string) error {
+	/*
+	* picks how the body is delimited, RFC 9112 section 6.3
+	*/
+	resp.ContentLength = -1
+	connection, _ := resp.Headers.Get("Connection")
+	if resp.Proto == "HTTP/1.0" {
+		resp.reusable = hasToken(connection, "keep-alive")
+	} else {
+		resp.reusable = !hasToken(connection, "close")
+	}
+
+	if resp.StatusCode == 101 {
+		resp.reusable = false
+		resp.Body = io.NopCloser(r)
+		return nil
+	}
+
+	transferEncoding, _ := resp.Headers.Get("Transfer-Encoding")
+	contentLength, hasLength := resp.Headers.Get("Content-Length")
+
+	switch {
+	case method == "HEAD" || resp.StatusCode == 204 || resp.StatusCode == 304:
+		resp.Body = io.NopCloser(strings.NewReader(""))
+		if method == "HEAD" {
+			// the length describes the body a GET would get
+			resp.ContentLength, _ = strconv.ParseInt(contentLength, 10, 64)
+		} else {
+			resp.ContentLength = 0
+		}
+
+	case hasToken(transferEncoding, "chunked"):
+		resp.Body = io.NopCloser(&chunkedReader{r: r})
+
+	case hasLength:
+		length, err := strconv.ParseInt(contentLength, 10, 64)
+		if err != nil || length < 0 {
+			return fmt.Errorf("%w: invalid content length %q", ErrMalformedResponse, contentLength)
+		}
+		resp.ContentLength = length
+		resp.Body = io.NopCloser(&exactReader{r: r, remaining: length})
+
+	default:
+		// delimited by the server closing the connection
+		resp.Body = io.NopCloser(r)
+		resp.reusable = false
+	}
+
+	return nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxLineSize {
+			return "", fmt.Errorf("%w: line too long", ErrMalformedResponse)
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+// exactReader reads a body of known length, a connection
+// closing early is an error
+type exactReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (er *exactReader) Read(p []byte) (int, error) {
+	if er.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > er.remaining {
+		p = p[:er.remaining]
+	}
+
+	n, err := er.r.Read(p)
+	er.remaining -= int64(n)
+	if err == io.EOF && er.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// chunkedReader decodes a body sent with 'Transfer-Encoding: chunked'
+type chunkedReader struct {
+	r         *bufio.Reader
+	remaining int64
+	done      bool
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if cr.done {
+		return 0, io.EOF
+	}
+
+	if cr.remaining == 0 {
+		line, err := readLine(cr.r)
+		if err != nil {
+			return 0, unexpected(err)
+		}
+
+		sizeField, _, _ := strings.Cut(line, ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+		if err != nil || size < 0 {
+			return 0, fmt.Errorf("%w: invalid chunk size %q", ErrMalformedResponse, line)
+		}
+
+		if size == 0 {
+			// trailers are dropped
+			for {
+				line, err = readLine(cr.r)
+				if err != nil {
+					return 0, unexpected(err)
+				}
+				if line == "" {
+					break
+				}
+			}
+			cr.done = true
+			return 0, io.EOF
+		}
+
+		cr.remaining = size
+	}
+
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+
+	n, err := cr.r.Read(p)
+	cr.remaining -= int64(n)
+	if err != nil {
+		return n, unexpected(err)
+	}
+
+	if cr.remaining == 0 {
+		line, err := readLine(cr.r)
+		if err != nil {
+			return n, unexpected(err)
+		}
+		if line != "" {
+			return n, fmt.Errorf("%w: missing chunk terminator", ErrMalformedResponse)
+		}
+	}
+
+	return n, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+func hasToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+
+	return false
+}
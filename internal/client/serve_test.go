@@ -0,0 +1,89 @@
+package client_test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Servus/internal/client"
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+	"Servus/internal/server"
+)
+
+// against the real server, outside the package since the server
+// depends on the client through tracing
+func TestClientServe(t *testing.T) {
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		body := req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + req.Headers["host"] +
+			" " + req.Headers["user-agent"] + " " + string(req.Body)
+		w.Response = &response.Response{
+			Code:    response.CodeOK,
+			Message: []byte(body),
+			Headers: headers.GetDefaultHeaders(len(body)),
+		}
+		w.WriteResponse()
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	c := client.New(client.Options{})
+	defer c.Close()
+	addr := fmt.Sprintf("127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+
+	// test: requests against server.Serve
+	resp, err := c.Get("http://" + addr + "/items?id=1")
+	require.NoError(t, err)
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "GET /items?id=1 "+addr+" servus-client ", string(body))
+
+	resp, err = c.Post("http://"+addr+"/items", "text/plain", []byte("hello"))
+	require.NoError(t, err)
+	body, err = resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "POST /items "+addr+" servus-client hello", string(body))
+
+	// test: an empty 'User-Agent' is not sent
+	req, err := client.NewRequest("GET", "http://"+addr+"/", nil)
+	require.NoError(t, err)
+	req.Headers.AddOverride("User-Agent", "")
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	body, err = resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "GET / "+addr+"  ", string(body))
+
+	// test: Dial replaces the dialer, its failures are a DialError
+	var dialed []string
+	refused := errors.New("refused")
+	dialing := client.New(client.Options{Dial: func(a string) (net.Conn, error) {
+		dialed = append(dialed, a)
+		if a == addr {
+			return net.Dial("tcp", a)
+		}
+		return nil, refused
+	}})
+	defer dialing.Close()
+
+	resp, err = dialing.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	resp.ReadBody()
+	_, err = dialing.Get("http://example.com/")
+	var de *client.DialError
+	require.ErrorAs(t, err, &de)
+	assert.ErrorIs(t, err, refused)
+	assert.Equal(t, []string{addr, "example.com:80"}, dialed)
+
+	// test: invalid urls
+	_, err = c.Get("ftp://" + addr)
+	require.Error(t, err)
+	_, err = c.Get("/relative")
+	require.Error(t, err)
+}
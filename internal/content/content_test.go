@@ -0,0 +1,130 @@
+package content
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+type item struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func newRequest(contentType, body string) *request.Request {
+	h := headers.Headers{}
+	if contentType != "" {
+		h.Add("Content-Type", contentType)
+	}
+
+	return &request.Request{Headers: h, Body: []byte(body)}
+}
+
+func requireCode(t *testing.T, err error, code response.StatusCode) {
+	var contentErr *Error
+	require.ErrorAs(t, err, &contentErr)
+	assert.Equal(t, code, contentErr.Code)
+}
+
+func TestDecodeJSON(t *testing.T) {
+	// test: valid body
+	var it item
+	err := DecodeJSON(newRequest("application/json; charset=utf-8", `{"name":"coffee","count":2}`), &it, 0)
+	require.NoError(t, err)
+	assert.Equal(t, item{Name: "coffee", Count: 2}, it)
+
+	// test: wrong content type
+	err = DecodeJSON(newRequest("text/plain", `{"name":"coffee"}`), &it, 0)
+	requireCode(t, err, response.CodeUnsupportedMediaType)
+
+	// test: body over the limit
+	err = DecodeJSON(newRequest("application/json", `{"name":"coffee"}`), &it, 4)
+	requireCode(t, err, response.CodeRequestEntityTooLarge)
+
+	// test: unknown field
+	err = DecodeJSON(newRequest("application/json", `{"name":"coffee","size":"L"}`), &it, 0)
+	requireCode(t, err, response.CodeUnprocessableEntity)
+
+	// test: wrong field type
+	err = DecodeJSON(newRequest("application/json", `{"count":"two"}`), &it, 0)
+	requireCode(t, err, response.CodeUnprocessableEntity)
+
+	// test: malformed json
+	err = DecodeJSON(newRequest("application/json", `{"name":`), &it, 0)
+	requireCode(t, err, response.CodeBadRequest)
+
+	// test: trailing value
+	err = DecodeJSON(newRequest("application/json", `{"name":"a"} {"name":"b"}`), &it, 0)
+	requireCode(t, err, response.CodeBadRequest)
+}
+
+func TestWriteProblem(t *testing.T) {
+	w := response.Writer{}
+	p := NewProblem(response.CodeNotFound, "no such coffee")
+	p.Extensions = map[string]any{"coffee": "espresso"}
+	require.NoError(t, WriteProblem(&w, p))
+
+	assert.Equal(t, response.CodeNotFound, w.Response.Code)
+	contentType, _ := w.Response.Headers.Get("Content-Type")
+	assert.Equal(t, "application/problem+json", contentType)
+
+	body := map[string]any{}
+	require.NoError(t, json.Unmarshal(w.Response.Message, &body))
+	assert.Equal(t, "about:blank", body["type"])
+	assert.Equal(t, "Not Found", body["title"])
+	assert.Equal(t, float64(404), body["status"])
+	assert.Equal(t, "no such coffee", body["detail"])
+	assert.Equal(t, "espresso", body["coffee"])
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"application/json", "text/plain", "text/html"}
+
+	// test: no header picks the first offer
+	chosen, ok := Negotiate("", offers)
+	require.True(t, ok)
+	assert.Equal(t, "application/json", chosen)
+
+	// test: browser style header
+	chosen, ok = Negotiate("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", offers)
+	require.True(t, ok)
+	assert.Equal(t, "text/html", chosen)
+
+	// test: more specific range overrides the wildcard
+	chosen, ok = Negotiate("text/*;q=0.5, text/plain;q=0.1, application/json;q=0.3", offers)
+	require.True(t, ok)
+	assert.Equal(t, "text/html", chosen)
+
+	// test: explicitly refused
+	_, ok = Negotiate("image/png, application/json;q=0", offers)
+	assert.False(t, ok)
+}
+
+func TestRender(t *testing.T) {
+	// test: plain text
+	w := response.Writer{}
+	req := newRequest("", "")
+	req.Headers.Add("Accept", "text/plain")
+	require.NoError(t, Render(&w, req, response.CodeOK, "hello <world>"))
+	assert.Equal(t, "hello <world>", string(w.Response.Message))
+
+	// test: html escapes the value
+	w = response.Writer{}
+	req = newRequest("", "")
+	req.Headers.Add("Accept", "text/html")
+	require.NoError(t, Render(&w, req, response.CodeOK, "hello <world>"))
+	assert.Contains(t, string(w.Response.Message), "hello &lt;world&gt;")
+
+	// test: nothing acceptable
+	w = response.Writer{}
+	req = newRequest("", "")
+	req.Headers.Add("Accept", "image/png")
+	require.NoError(t, Render(&w, req, response.CodeOK, item{Name: "coffee"}))
+	assert.Equal(t, response.CodeNotAcceptable, w.Response.Code)
+}
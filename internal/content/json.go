@@ -0,0 +1,146 @@
+package content
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+const DefaultMaxBodyBytes = 1 << 20
+
+// Error is returned by the decoding helpers and carries the status
+// code that should be sent back to the client
+type Error struct {
+	Code   response.StatusCode
+	Detail string
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+func DecodeJSON(req *request.Request, v any, maxBytes int) error {
+	/*
+	* @brief: decodes the JSON body of req into v
+	*
+	* the body must be declared as 'application/json' (or a '+json'
+	* media type), must not exceed maxBytes (DefaultMaxBodyBytes if <= 0),
+	* must contain exactly one JSON value and must not carry fields
+	* unknown to v
+	*/
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBodyBytes
+	}
+
+	contentType, _ := req.Headers.Get("Content-Type")
+	if !isJSONMediaType(contentType) {
+		return &Error{
+			Code:   response.CodeUnsupportedMediaType,
+			Detail: fmt.Sprintf("unsupported content type %q, expected application/json", contentType),
+		}
+	}
+
+	if len(req.Body) > maxBytes {
+		return &Error{
+			Code:   response.CodeRequestEntityTooLarge,
+			Detail: fmt.Sprintf("request body must not be larger than %d bytes", maxBytes),
+		}
+	}
+
+	if len(req.Body) == 0 {
+		return &Error{Code: response.CodeBadRequest, Detail: "request body is empty"}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(req.Body))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err != nil {
+		return decodeError(err)
+	}
+
+	// anything but EOF after the first value means trailing data
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return &Error{Code: response.CodeBadRequest, Detail: "request body must contain a single JSON value"}
+	}
+
+	return nil
+}
+
+func JSON(w *response.Writer, code response.StatusCode, v any) error {
+	/*
+	* @brief: fills the writer's response with the JSON encoding of v,
+	* the response is then sent with w.WriteResponse()
+	*/
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode json: %v", err)
+	}
+
+	setResponse(w, code, "application/json; charset=utf-8", body)
+
+	return nil
+}
+
+func setResponse(w *response.Writer, code response.StatusCode, contentType string, body []byte) {
+	h := headers.GetDefaultHeaders(len(body))
+	h.AddOverride("Content-Type", contentType)
+
+	if w.Response == nil {
+		w.Response = &response.Response{}
+	}
+
+	w.Response.Code = code
+	w.Response.Message = body
+	w.Response.Headers = h
+}
+
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		return &Error{
+			Code:   response.CodeBadRequest,
+			Detail: fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset),
+		}
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &Error{Code: response.CodeBadRequest, Detail: "malformed JSON: unexpected end of body"}
+
+	case errors.As(err, &typeErr):
+		return &Error{
+			Code:   response.CodeUnprocessableEntity,
+			Detail: fmt.Sprintf("invalid value for field %q: expected %s", typeErr.Field, typeErr.Type),
+		}
+
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for unknown fields
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return &Error{
+			Code:   response.CodeUnprocessableEntity,
+			Detail: fmt.Sprintf("unknown field %s", field),
+		}
+
+	default:
+		return &Error{Code: response.CodeBadRequest, Detail: err.Error()}
+	}
+}
+
+func isJSONMediaType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,193 @@
+package content
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+type Renderer func(v any) ([]byte, error)
+
+type Offer struct {
+	MediaType string
+	Render    Renderer
+}
+
+// DefaultOffers lists the representations Render can produce,
+// earlier entries win when the client has no preference
+var DefaultOffers = []Offer{
+	{MediaType: "application/json", Render: renderJSON},
+	{MediaType: "text/plain", Render: renderText},
+	{MediaType: "text/html", Render: renderHTML},
+}
+
+type mediaRange struct {
+	typ     string
+	subtype string
+	q       float64
+}
+
+func Render(w *response.Writer, req *request.Request, code response.StatusCode, v any) error {
+	return RenderWith(w, req, code, v, DefaultOffers)
+}
+
+func RenderWith(w *response.Writer, req *request.Request, code response.StatusCode, v any, offers []Offer) error {
+	/*
+	* @brief: fills the writer's response with v rendered in the
+	* representation preferred by the request's 'Accept' header,
+	* a 406 problem is produced if none of the offers is acceptable
+	*/
+	mediaTypes := make([]string, len(offers))
+	for i, offer := range offers {
+		mediaTypes[i] = offer.MediaType
+	}
+
+	accept, _ := req.Headers.Get("Accept")
+	chosen, ok := Negotiate(accept, mediaTypes)
+	if !ok {
+		detail := "acceptable representations: " + strings.Join(mediaTypes, ", ")
+		return WriteProblem(w, NewProblem(response.CodeNotAcceptable, detail))
+	}
+
+	for _, offer := range offers {
+		if offer.MediaType != chosen {
+			continue
+		}
+
+		body, err := offer.Render(v)
+		if err != nil {
+			return fmt.Errorf("failed to render %s: %v", chosen, err)
+		}
+
+		setResponse(w, code, chosen+"; charset=utf-8", body)
+		break
+	}
+
+	return nil
+}
+
+func Negotiate(accept string, offers []string) (string, bool) {
+	/*
+	* @brief: returns the offer with the highest quality in the
+	* 'Accept' header value, ties are broken by offer order
+	*
+	* an empty header accepts anything, the most specific media range
+	* matching an offer determines its quality
+	*/
+	if len(offers) == 0 {
+		return "", false
+	}
+
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+
+	ranges := parseAccept(accept)
+	best := ""
+	bestQ := 0.0
+	for _, offer := range offers {
+		q := quality(offer, ranges)
+		if q > bestQ {
+			best = offer
+			bestQ = q
+		}
+	}
+
+	return best, bestQ > 0
+}
+
+func parseAccept(accept string) []mediaRange {
+	ranges := []mediaRange{}
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		typ, subtype, found := strings.Cut(strings.TrimSpace(params[0]), "/")
+		if !found || typ == "" || subtype == "" {
+			continue
+		}
+
+		r := mediaRange{
+			typ:     strings.ToLower(typ),
+			subtype: strings.ToLower(subtype),
+			q:       1,
+		}
+
+		for _, param := range params[1:] {
+			key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(key) != "q" {
+				continue
+			}
+
+			q, err := strconv.ParseFloat(val, 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			r.q = q
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return ranges
+}
+
+func quality(offer string, ranges []mediaRange) float64 {
+	typ, subtype, _ := strings.Cut(strings.ToLower(offer), "/")
+
+	// 0 = */*, 1 = type/*, 2 = type/subtype
+	bestSpecificity := -1
+	q := 0.0
+	for _, r := range ranges {
+		specificity := -1
+		switch {
+		case r.typ == typ && r.subtype == subtype:
+			specificity = 2
+		case r.typ == typ && r.subtype == "*":
+			specificity = 1
+		case r.typ == "*" && r.subtype == "*":
+			specificity = 0
+		}
+
+		if specificity > bestSpecificity {
+			bestSpecificity = specificity
+			q = r.q
+		}
+	}
+
+	return q
+}
+
+func renderJSON(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func renderText(v any) ([]byte, error) {
+	switch val := v.(type) {
+	case string:
+		return []byte(val), nil
+	case []byte:
+		return val, nil
+	case fmt.Stringer:
+		return []byte(val.String()), nil
+	case error:
+		return []byte(val.Error()), nil
+	}
+
+	// structured values read best as indented JSON
+	return json.MarshalIndent(v, "", "  ")
+}
+
+func renderHTML(v any) ([]byte, error) {
+	text, err := renderText(v)
+	if err != nil {
+		return nil, err
+	}
+
+	page := "<html>\n  <body>\n    <pre>" + html.EscapeString(string(text)) + "</pre>\n  </body>\n</html>"
+
+	return []byte(page), nil
+}
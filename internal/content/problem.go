@@ -0,0 +1,103 @@
+package content
+
+import (
+	"encoding/json"
+	"errors"
+
+	"Servus/internal/response"
+)
+
+const problemContentType = "application/problem+json"
+
+// Problem is an RFC 9457 problem details object
+type Problem struct {
+	Type       string
+	Title      string
+	Status     response.StatusCode
+	Detail     string
+	Instance   string
+	Extensions map[string]any
+}
+
+func NewProblem(code response.StatusCode, detail string) Problem {
+	return Problem{
+		Type:   "about:blank",
+		Title:  response.StatusText(code),
+		Status: code,
+		Detail: detail,
+	}
+}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	/*
+	* extension members are serialized next to the standard ones,
+	* standard members win on name clashes
+	*/
+	obj := make(map[string]any, len(p.Extensions)+5)
+	for key, val := range p.Extensions {
+		obj[key] = val
+	}
+
+	if p.Type != "" {
+		obj["type"] = p.Type
+	}
+	if p.Title != "" {
+		obj["title"] = p.Title
+	}
+	if p.Status != 0 {
+		obj["status"] = int(p.Status)
+	}
+	if p.Detail != "" {
+		obj["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		obj["instance"] = p.Instance
+	}
+
+	return json.Marshal(obj)
+}
+
+func WriteProblem(w *response.Writer, p Problem) error {
+	/*
+	* @brief: fills the writer's response with an
+	* 'application/problem+json' body describing p
+	*
+	* the request ID of the writer, if any, is added
+	* as the 'request_id' extension member
+	*/
+	if p.Status == 0 {
+		p.Status = response.CodeInternalServerError
+	}
+
+	if w.RequestID != "" {
+		if _, ok := p.Extensions["request_id"]; !ok {
+			extensions := map[string]any{"request_id": w.RequestID}
+			for key, val := range p.Extensions {
+				extensions[key] = val
+			}
+			p.Extensions = extensions
+		}
+	}
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	setResponse(w, p.Status, problemContentType, body)
+
+	return nil
+}
+
+func WriteError(w *response.Writer, err error) error {
+	/*
+	* @brief: fills the writer's response with a problem derived from err,
+	* errors that are not *Error are reported as 500 without details
+	*/
+	var contentErr *Error
+	if errors.As(err, &contentErr) {
+		return WriteProblem(w, NewProblem(contentErr.Code, contentErr.Detail))
+	}
+
+	return WriteProblem(w, NewProblem(response.CodeInternalServerError, ""))
+}
@@ -0,0 +1,347 @@
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"Servus/internal/request"
+)
+
+type SameSite int
+
+const (
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+const expiresFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+var ErrNoCookie = errors.New("named cookie not present")
+
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time
+	// 0 means no 'Max-Age' attribute, negative values delete the cookie
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+func Parse(header string) []*Cookie {
+	/*
+	* @brief: parses the value of a 'Cookie' request header
+	*
+	* pairs with an invalid name or value are skipped, several
+	* 'Cookie' headers merged by headers.Add are handled too
+	*/
+	cookies := []*Cookie{}
+
+	for _, field := range splitPairs(header) {
+		name, value, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found || !isToken(name) {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+
+		if !isCookieValue(value) {
+			continue
+		}
+
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+
+	return cookies
+}
+
+func ParseSetCookie(line string) (*Cookie, error) {
+	/*
+	* @brief: parses the value of a 'Set-Cookie' response header,
+	* unknown attributes are ignored
+	*/
+	parts := strings.Split(line, ";")
+	name, value, found := strings.Cut(strings.TrimSpace(parts[0]), "=")
+	name = strings.TrimSpace(name)
+	if !found || !isToken(name) {
+		return nil, fmt.Errorf("invalid set-cookie header %q", line)
+	}
+
+	value = strings.TrimSpace(value)
+	if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
+	if !isCookieValue(value) {
+		return nil, fmt.Errorf("invalid value for cookie %q", name)
+	}
+
+	c := &Cookie{Name: name, Value: value}
+	for _, attr := range parts[1:] {
+		key, val, _ := strings.Cut(strings.TrimSpace(attr), "=")
+		val = strings.TrimSpace(val)
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "path":
+			c.Path = val
+		case "domain":
+			c.Domain = val
+		case "expires":
+			expires, err := parseExpires(val)
+			if err == nil {
+				c.Expires = expires
+			}
+		case "max-age":
+			maxAge, err := strconv.Atoi(val)
+			if err != nil {
+				continue
+			}
+			if maxAge <= 0 {
+				maxAge = -1
+			}
+			c.MaxAge = maxAge
+		case "secure":
+			c.Secure = true
+		case "httponly":
+			c.HttpOnly = true
+		case "samesite":
+			switch strings.ToLower(val) {
+			case "lax":
+				c.SameSite = SameSiteLax
+			case "strict":
+				c.SameSite = SameSiteStrict
+			case "none":
+				c.SameSite = SameSiteNone
+			}
+		case "partitioned":
+			c.Partitioned = true
+		}
+	}
+
+	return c, nil
+}
+
+func parseExpires(value string) (time.Time, error) {
+	// older servers still use the RFC 850 style with dashes
+	for _, layout := range []string{expiresFormat, time.RFC1123, "Mon, 02-Jan-2006 15:04:05 MST", time.RFC850} {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid expiry %q", value)
+}
+
+func FromRequest(req *request.Request) []*Cookie {
+	header, ok := req.Headers.Get("Cookie")
+	if !ok {
+		return []*Cookie{}
+	}
+
+	return Parse(header)
+}
+
+func Get(req *request.Request, name string) (*Cookie, error) {
+	for _, c := range FromRequest(req) {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+
+	return nil, ErrNoCookie
+}
+
+func (c *Cookie) Valid() error {
+	if !isToken(c.Name) {
+		return fmt.Errorf("invalid cookie name %q", c.Name)
+	}
+
+	if !isCookieValue(c.Value) {
+		return fmt.Errorf("invalid value for cookie %q", c.Name)
+	}
+
+	if strings.ContainsAny(c.Path, ";\r\n") || hasCTL(c.Path) {
+		return fmt.Errorf("invalid path for cookie %q", c.Name)
+	}
+
+	if c.Domain != "" && !isDomain(c.Domain) {
+		return fmt.Errorf("invalid domain for cookie %q", c.Name)
+	}
+
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return fmt.Errorf("cookie %q with SameSite=None must be Secure", c.Name)
+	}
+
+	if c.Partitioned && !c.Secure {
+		return fmt.Errorf("partitioned cookie %q must be Secure", c.Name)
+	}
+
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return fmt.Errorf("invalid expiry for cookie %q", c.Name)
+	}
+
+	return nil
+}
+
+func (c *Cookie) String() string {
+	/*
+	* @brief: serializes the cookie for a 'Set-Cookie' header,
+	* the cookie is expected to be valid
+	*/
+	var b strings.Builder
+	b.WriteString(c.Name + "=" + quoteIfNeeded(c.Value))
+
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(expiresFormat))
+	}
+
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+
+	switch c.SameSite {
+	case SameSiteLax:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		b.WriteString("; SameSite=None")
+	}
+
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+
+	return b.String()
+}
+
+func splitPairs(header string) []string {
+	/*
+	* splits on ';' and on the ',' joining merged headers, but
+	* not on commas inside a quoted value such as 'a="x,y"'
+	*/
+	fields := []string{}
+	quoted := false
+	start := 0
+	for i := 0; i < len(header); i++ {
+		switch header[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if quoted {
+				continue
+			}
+			fallthrough
+		case ';':
+			fields = append(fields, header[start:i])
+			start = i + 1
+			quoted = false
+		}
+	}
+
+	return append(fields, header[start:])
+}
+
+func quoteIfNeeded(value string) string {
+	// spaces and commas are valid only inside a quoted value
+	if strings.ContainsAny(value, " ,") {
+		return `"` + value + `"`
+	}
+
+	return value
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, ch := range s {
+		if !((ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') ||
+			(ch >= '0' && ch <= '9') || strings.ContainsRune("-!#$%&'*+.^_`|~", ch)) {
+
+			return false
+		}
+	}
+
+	return true
+}
+
+func isCookieValue(s string) bool {
+	/*
+	* cookie-octets are printable US-ASCII characters excluding
+	* '"', ',', ';' and '\', spaces and commas are tolerated so
+	* that they can be sent quoted
+	*/
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch < 0x20 || ch >= 0x7f || ch == '"' || ch == ';' || ch == '\\' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isDomain(s string) bool {
+	s = strings.TrimPrefix(s, ".")
+	if s == "" || len(s) > 255 {
+		return false
+	}
+
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+
+		for _, ch := range label {
+			if !((ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') || ch == '-') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func hasCTL(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] == 0x7f {
+			return true
+		}
+	}
+
+	return false
+}
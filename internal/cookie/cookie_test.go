@@ -0,0 +1,117 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+)
+
+func TestParse(t *testing.T) {
+	// test: several pairs
+	cookies := Parse(`session=abc123; theme="dark"; lang=it`)
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "session", cookies[0].Name)
+	assert.Equal(t, "abc123", cookies[0].Value)
+	assert.Equal(t, "dark", cookies[1].Value)
+	assert.Equal(t, "it", cookies[2].Value)
+
+	// test: invalid pairs are skipped
+	cookies = Parse(`bad name=1; =2; novalue; ok=3`)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "ok", cookies[0].Name)
+
+	// test: merged 'Cookie' headers
+	h := headers.Headers{}
+	h.Add("Cookie", "a=1; b=2")
+	h.Add("Cookie", "c=3")
+	req := &request.Request{Headers: h}
+	c, err := Get(req, "c")
+	require.NoError(t, err)
+	assert.Equal(t, "3", c.Value)
+
+	_, err = Get(req, "d")
+	require.ErrorIs(t, err, ErrNoCookie)
+}
+
+func TestString(t *testing.T) {
+	// test: every attribute
+	c := &Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Path:        "/",
+		Domain:      ".example.com",
+		Expires:     time.Date(2030, time.January, 2, 15, 4, 5, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, "session=abc123; Path=/; Domain=example.com; Expires=Wed, 02 Jan 2030 15:04:05 GMT; "+
+		"Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned", c.String())
+
+	// test: deletion and quoting
+	c = &Cookie{Name: "theme", Value: "dark mode", MaxAge: -1, SameSite: SameSiteLax}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, `theme="dark mode"; Max-Age=0; SameSite=Lax`, c.String())
+}
+
+func TestParseSetCookie(t *testing.T) {
+	// test: round trip through String
+	line := "session=abc123; Path=/; Domain=example.com; Expires=Wed, 02 Jan 2030 15:04:05 GMT; " +
+		"Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned"
+	c, err := ParseSetCookie(line)
+	require.NoError(t, err)
+	assert.Equal(t, line, c.String())
+
+	// test: deletion, legacy expiry and unknown attributes
+	c, err = ParseSetCookie(`theme="dark mode"; max-age=0; expires=Wed, 02-Jan-2030 15:04:05 GMT; Priority=High`)
+	require.NoError(t, err)
+	assert.Equal(t, "dark mode", c.Value)
+	assert.Equal(t, -1, c.MaxAge)
+	assert.Equal(t, 2030, c.Expires.Year())
+
+	// test: invalid pair
+	_, err = ParseSetCookie("no value")
+	require.Error(t, err)
+
+	// test: quoted values make it back in a 'Cookie' header, the
+	// browser sends them as they were set
+	for _, value := range []string{"a,b", "dark mode", "x, y"} {
+		set := (&Cookie{Name: "pref", Value: value}).String()
+		c, err = ParseSetCookie(set)
+		require.NoError(t, err)
+		require.Equal(t, value, c.Value)
+
+		h := headers.Headers{}
+		h.Add("Cookie", "a=1; "+set)
+		h.Add("Cookie", "b=2")
+		cookies := Parse(h["cookie"])
+		require.Len(t, cookies, 3, set)
+		assert.Equal(t, value, cookies[1].Value)
+		assert.Equal(t, "2", cookies[2].Value)
+	}
+}
+
+func TestValid(t *testing.T) {
+	// test: invalid name
+	require.Error(t, (&Cookie{Name: "se;ssion", Value: "a"}).Valid())
+
+	// test: invalid value
+	require.Error(t, (&Cookie{Name: "session", Value: "a;b"}).Valid())
+
+	// test: invalid domain
+	require.Error(t, (&Cookie{Name: "session", Value: "a", Domain: "exa_mple.com"}).Valid())
+
+	// test: SameSite=None without Secure
+	require.Error(t, (&Cookie{Name: "session", Value: "a", SameSite: SameSiteNone}).Valid())
+
+	// test: Partitioned without Secure
+	require.Error(t, (&Cookie{Name: "session", Value: "a", Partitioned: true}).Valid())
+}
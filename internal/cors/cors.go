@@ -0,0 +1,237 @@
+package cors
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+var (
+	DefaultMethods = []string{"GET", "HEAD", "POST"}
+	DefaultHeaders = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type"}
+)
+
+type Options struct {
+	// origins allowed, "*" allows any and a single "*" in an
+	// entry matches a part of the origin, e.g.
+	// "https://*.example.com"
+	AllowedOrigins []string
+	// origins allowed when matching one of the expressions,
+	// anchor them so "example.com.evil.org" does not match
+	AllowedOriginPatterns []*regexp.Regexp
+	// DefaultMethods when empty
+	AllowedMethods []string
+	// request headers allowed, DefaultHeaders when empty and
+	// whatever the client asks for with "*"
+	AllowedHeaders []string
+	// response headers scripts may read besides the safelisted
+	// ones
+	ExposedHeaders []string
+	// lets requests carry cookies and authorization, the origin
+	// is then echoed. it cannot be combined with "*", which
+	// would let any site read responses as the user
+	AllowCredentials bool
+	// time browsers may cache a preflight answer, not sent when
+	// zero
+	MaxAge time.Duration
+}
+
+type policy struct {
+	opts      Options
+	anyOrigin bool
+	wildcards [][2]string
+	methods   []string
+	headers   []string
+	anyHeader bool
+}
+
+func Middleware(opts Options) response.Middleware {
+	/*
+	* @brief: answers preflight requests and adds the CORS
+	* headers to the responses of allowed origins
+	*
+	* preflights are answered with a 204 whether the origin is
+	* allowed or not, a refused one just gets no CORS headers
+	* and the browser blocks the request. OPTIONS requests that
+	* are not preflights reach the handler
+	*
+	* panics when credentials are allowed for any origin
+	*/
+	p := newPolicy(opts)
+
+	return func(next response.Handler) response.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			origin, hasOrigin := req.Headers.Get("Origin")
+			requested, isPreflight := req.Headers.Get("Access-Control-Request-Method")
+
+			if hasOrigin && isPreflight && req.RequestLine.Method == "OPTIONS" {
+				p.preflight(w, req, origin, requested)
+				return
+			}
+
+			w.OnWriteHeaders(func(h headers.Headers) {
+				p.actual(h, origin, hasOrigin)
+			})
+			next(w, req)
+		}
+	}
+}
+
+func newPolicy(opts Options) *policy {
+	p := &policy{opts: opts, methods: opts.AllowedMethods, headers: opts.AllowedHeaders}
+
+	for _, origin := range opts.AllowedOrigins {
+		if origin == "*" {
+			if opts.AllowCredentials {
+				panic(`cors: AllowCredentials needs explicit origins, not "*"`)
+			}
+			p.anyOrigin = true
+		} else if prefix, suffix, found := strings.Cut(origin, "*"); found {
+			p.wildcards = append(p.wildcards, [2]string{strings.ToLower(prefix), strings.ToLower(suffix)})
+		}
+	}
+
+	if len(p.methods) == 0 {
+		p.methods = DefaultMethods
+	}
+	if len(p.headers) == 0 {
+		p.headers = DefaultHeaders
+	}
+	p.anyHeader = slices.Contains(p.headers, "*")
+
+	return p
+}
+
+func (p *policy) allowOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+
+	for _, allowed := range p.opts.AllowedOrigins {
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	// the wildcard has to match something, "https://.example.com"
+	// is not a subdomain
+	lower := strings.ToLower(origin)
+	for _, w := range p.wildcards {
+		if len(lower) > len(w[0])+len(w[1]) && strings.HasPrefix(lower, w[0]) && strings.HasSuffix(lower, w[1]) {
+			return true
+		}
+	}
+
+	for _, pattern := range p.opts.AllowedOriginPatterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *policy) allowHeaders(requested string) bool {
+	if p.anyHeader || requested == "" {
+		return true
+	}
+
+	for _, name := range strings.Split(requested, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.ContainsFunc(p.headers, func(h string) bool { return strings.EqualFold(h, name) }) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *policy) allowedOrigin(origin string) string {
+	/*
+	* the value of 'Access-Control-Allow-Origin'
+	*/
+	if p.anyOrigin {
+		return "*"
+	}
+
+	return origin
+}
+
+func (p *policy) varies() bool {
+	/*
+	* the headers depend on the origin unless every origin gets
+	* the same "*", caches then have to key on it
+	*/
+	return !p.anyOrigin
+}
+
+func (p *policy) preflight(w *response.Writer, req *request.Request, origin, method string) {
+	h := headers.GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Delete("Content-Type")
+	h.AddOverride("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+
+	requestedHeaders, _ := req.Headers.Get("Access-Control-Request-Headers")
+	if p.allowOrigin(origin) && slices.Contains(p.methods, method) && p.allowHeaders(requestedHeaders) {
+		h.AddOverride("Access-Control-Allow-Origin", p.allowedOrigin(origin))
+		h.AddOverride("Access-Control-Allow-Methods", strings.Join(p.methods, ", "))
+		// "*" is not a wildcard for requests with credentials,
+		// the requested headers are echoed instead
+		if requestedHeaders != "" {
+			h.AddOverride("Access-Control-Allow-Headers", requestedHeaders)
+		}
+		if p.opts.AllowCredentials {
+			h.AddOverride("Access-Control-Allow-Credentials", "true")
+		}
+		if p.opts.MaxAge > 0 {
+			h.AddOverride("Access-Control-Max-Age", strconv.Itoa(int(p.opts.MaxAge.Seconds())))
+		}
+	}
+
+	w.Response = &response.Response{
+		Code:    response.CodeNoContent,
+		Headers: h,
+	}
+	w.WriteResponse()
+}
+
+func (p *policy) actual(h headers.Headers, origin string, hasOrigin bool) {
+	if p.varies() {
+		vary, _ := h.Get("Vary")
+		if !hasToken(vary, "Origin") {
+			h.Add("Vary", "Origin")
+		}
+	}
+
+	if !hasOrigin || !p.allowOrigin(origin) {
+		return
+	}
+
+	h.AddOverride("Access-Control-Allow-Origin", p.allowedOrigin(origin))
+	if p.opts.AllowCredentials {
+		h.AddOverride("Access-Control-Allow-Credentials", "true")
+	}
+	if len(p.opts.ExposedHeaders) > 0 {
+		h.AddOverride("Access-Control-Expose-Headers", strings.Join(p.opts.ExposedHeaders, ", "))
+	}
+}
+
+func hasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		t = strings.TrimSpace(t)
+		if strings.EqualFold(t, token) || t == "*" {
+			return true
+		}
+	}
+
+	return false
+}
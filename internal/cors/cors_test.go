@@ -0,0 +1,130 @@
+package cors
+
+import (
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+	"Servus/internal/server"
+)
+
+func TestMiddleware(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		body := req.RequestLine.Method
+		h := headers.GetDefaultHeaders(len(body))
+		h.AddOverride("Vary", "Accept-Encoding")
+		w.Response = &response.Response{
+			Code:    response.CodeOK,
+			Message: []byte(body),
+			Headers: h,
+		}
+		w.WriteResponse()
+	}
+
+	serve := func(opts Options) string {
+		s, err := server.Serve(0, response.Chain(handler, Middleware(opts)))
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+
+		return s.Addr().String()
+	}
+	send := func(addr, method, extra string) string {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		_, err = conn.Write([]byte(method + " /api HTTP/1.1\r\nHost: localhost\r\n" + extra + "\r\n"))
+		require.NoError(t, err)
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+
+		return string(resp)
+	}
+	preflight := func(origin, method, requestHeaders string) string {
+		extra := "Origin: " + origin + "\r\nAccess-Control-Request-Method: " + method + "\r\n"
+		if requestHeaders != "" {
+			extra += "Access-Control-Request-Headers: " + requestHeaders + "\r\n"
+		}
+		return extra
+	}
+
+	addr := serve(Options{
+		AllowedOrigins:        []string{"https://app.example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`^http://localhost:\d+$`)},
+		AllowedMethods:        []string{"GET", "PUT", "DELETE"},
+		AllowedHeaders:        []string{"Content-Type", "Authorization"},
+		ExposedHeaders:        []string{"X-Request-ID"},
+		AllowCredentials:      true,
+		MaxAge:                10 * time.Minute,
+	})
+
+	// test: preflights are answered without reaching the handler
+	resp := send(addr, "OPTIONS", preflight("https://app.example.com", "PUT", "content-type, authorization"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 204 No Content\r\n"), resp)
+	assert.Contains(t, resp, "access-control-allow-origin: https://app.example.com\r\n")
+	assert.Contains(t, resp, "access-control-allow-methods: GET, PUT, DELETE\r\n")
+	assert.Contains(t, resp, "access-control-allow-headers: content-type, authorization\r\n")
+	assert.Contains(t, resp, "access-control-allow-credentials: true\r\n")
+	assert.Contains(t, resp, "access-control-max-age: 600\r\n")
+	assert.Contains(t, resp, "vary: Origin, Access-Control-Request-Method, Access-Control-Request-Headers\r\n")
+	assert.NotContains(t, resp, "content-length")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"), resp)
+
+	// test: wildcard and pattern origins
+	assert.Contains(t, send(addr, "OPTIONS", preflight("https://a.b.example.org", "GET", "")), "access-control-allow-origin: https://a.b.example.org\r\n")
+	assert.NotContains(t, send(addr, "OPTIONS", preflight("https://.example.org", "GET", "")), "access-control-allow-origin")
+	assert.Contains(t, send(addr, "OPTIONS", preflight("http://localhost:3000", "GET", "")), "access-control-allow-origin: http://localhost:3000\r\n")
+
+	// test: refused preflights get no CORS headers
+	for _, extra := range []string{
+		preflight("https://evil.example.com", "GET", ""),
+		preflight("https://app.example.com", "PATCH", ""),
+		preflight("https://app.example.com", "GET", "X-Custom"),
+	} {
+		resp = send(addr, "OPTIONS", extra)
+		assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 204 No Content\r\n"), resp)
+		assert.NotContains(t, resp, "access-control-allow")
+	}
+
+	// test: OPTIONS requests that are not preflights reach the handler
+	assert.True(t, strings.HasSuffix(send(addr, "OPTIONS", "Origin: https://app.example.com\r\n"), "OPTIONS"))
+
+	// test: actual requests get the headers, Vary is merged
+	resp = send(addr, "GET", "Origin: https://app.example.com\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nGET"), resp)
+	assert.Contains(t, resp, "access-control-allow-origin: https://app.example.com\r\n")
+	assert.Contains(t, resp, "access-control-allow-credentials: true\r\n")
+	assert.Contains(t, resp, "access-control-expose-headers: X-Request-ID\r\n")
+	assert.Contains(t, resp, "vary: Accept-Encoding, Origin\r\n")
+
+	// test: other origins and requests without one still vary
+	resp = send(addr, "GET", "Origin: https://evil.example.com\r\n")
+	assert.NotContains(t, resp, "access-control-allow")
+	assert.Contains(t, resp, "vary: Accept-Encoding, Origin\r\n")
+	assert.Contains(t, send(addr, "GET", ""), "vary: Accept-Encoding, Origin\r\n")
+
+	// test: any origin without credentials is "*" and does not vary
+	addr = serve(Options{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}})
+	resp = send(addr, "GET", "Origin: https://anyone.example\r\n")
+	assert.Contains(t, resp, "access-control-allow-origin: *\r\n")
+	assert.Contains(t, resp, "vary: Accept-Encoding\r\n")
+	resp = send(addr, "OPTIONS", preflight("https://anyone.example", "POST", "X-Custom"))
+	assert.Contains(t, resp, "access-control-allow-origin: *\r\n")
+	assert.Contains(t, resp, "access-control-allow-headers: X-Custom\r\n")
+	assert.NotContains(t, resp, "access-control-max-age")
+
+	// test: credentials cannot be allowed for any origin
+	assert.Panics(t, func() {
+		Middleware(Options{AllowedOrigins: []string{"https://app.example.com", "*"}, AllowCredentials: true})
+	})
+}
@@ -9,10 +9,6 @@ type Headers map[string]string
 
 func (h* Headers) Parse(data []byte) (n int, done bool, err error) {	
 	headersString := string(data)
-	if strings.Contains(headersString, "::") {
-		return 0, false, fmt.Errorf("invalid header: double colon")
-	}
-
 	crlfIndex := strings.Index(headersString, "\r\n")
 	
 	// not enough data to have a full header
@@ -26,6 +22,16 @@ func (h* Headers) Parse(data []byte) (n int, done bool, err error) {
 	}
 
 	parts := strings.SplitN(headersString[:crlfIndex], ":", 2)
+	if len(parts) != 2 {
+		return 0, false, fmt.Errorf("invalid header: missing colon")
+	}
+
+	// only the name separator is checked, values such as
+	// IPv6 addresses may contain '::'
+	if strings.HasPrefix(parts[1], ":") {
+		return 0, false, fmt.Errorf("invalid header: double colon")
+	}
+
 	key := strings.ToLower(parts[0])
 
 	if key != strings.TrimRight(key, " ") {
@@ -53,6 +59,7 @@ func (h* Headers) Add(key, value string) {
 	*@brief: add a (key,value) pair and append the additional value
 	* if key is already present
 	*/
+	key = strings.ToLower(key)
 	prevVal, ok := h.Get(key)
 	if ok {
 		(*h)[key] = prevVal + ", " + value
@@ -66,7 +73,11 @@ func (h* Headers) AddOverride(key, value string) {
 	*@brief: add a (key, value) pair overriding an eventual
 	* preexisting value
 	*/
-	(*h)[key] = value
+	(*h)[strings.ToLower(key)] = value
+}
+
+func (h *Headers) Delete(key string) {
+	delete(*h, strings.ToLower(key))
 }
 
 func (h *Headers) Get(key string) (string, bool) {
@@ -84,6 +95,17 @@ func GetDefaultHeaders(contentLen int) Headers {
 	return headers
 }
 
+func ValidName(name string) bool {
+	return name != "" && isValidHeaderFieldName(name)
+}
+
+func ValidValue(value string) bool {
+	/*
+	* values must not break out of their line once serialized
+	*/
+	return !strings.ContainsAny(value, "\r\n\x00")
+}
+
 func isValidHeaderFieldName(s string) bool {
 	/*
 	* field names must contain only:
@@ -125,3 +125,19 @@ func TestHeaderParser(t *testing.T) {
 	assert.Equal(t, 24, n)
 	assert.False(t, done)
 }
+
+func TestParseIPv6Value(t *testing.T) {
+	// test: '::' inside a value is not a double colon
+	headers := Headers{}
+	data := []byte("X-Forwarded-For: ::1\r\n\r\n")
+	n, done, err := headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, 22, n)
+	assert.False(t, done)
+	assert.Equal(t, "::1", headers["x-forwarded-for"])
+
+	// test: line without a colon
+	headers = Headers{}
+	_, _, err = headers.Parse([]byte("NoColon\r\n\r\n"))
+	require.Error(t, err)
+}
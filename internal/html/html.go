@@ -21,7 +21,7 @@ func WriteResponse(w *response.Writer, fileName string) error {
 	}
 	code, err := extractCode(status)
 	if err != nil {
-		fmt.Errorf("failed to parse status code: %v", err)
+		return fmt.Errorf("failed to parse status code: %v", err)
 	}
 
 	w.Response.Code = response.StatusCode(code)
@@ -36,19 +36,19 @@ func WriteResponse(w *response.Writer, fileName string) error {
 func extractTitleAndBodyFromFile(htmlFile string) (string, []byte, error) {
 	file, err := os.Open(htmlFile)
 	if err != nil {
-		return "", []byte{}, fmt.Errorf("Error opening file:", err)
+		return "", []byte{}, fmt.Errorf("Error opening file: %v", err)
 	}
 	defer file.Close()
 
 	content, err := io.ReadAll(file)
 	if err != nil {
-		return "", []byte{}, fmt.Errorf("Error reading file:", err)
+		return "", []byte{}, fmt.Errorf("Error reading file: %v", err)
 		
 	}
 
 	title, body, err := extractTitleAndBody(content)
 	if err != nil {
-		return "", []byte{}, fmt.Errorf("Error parsing HTML:", err)
+		return "", []byte{}, fmt.Errorf("Error parsing HTML: %v", err)
 	}
 
 	return title, body, nil
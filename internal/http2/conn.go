@@ -0,0 +1,985 @@
+package http2
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http2/hpack"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+const (
+	maxConcurrentStreams = 250
+	maxHeaderListSize    = 1 << 20
+
+	DefaultMaxBodySize = 10 << 20
+)
+
+type ServerConn struct {
+	// request bodies are buffered in memory, longer ones are
+	// answered with a 413. DefaultMaxBodySize when zero, set
+	// before serving
+	MaxBodySize int64
+
+	conn     net.Conn
+	reader   io.Reader
+	handler  response.Handler
+	tlsState *tls.ConnectionState
+
+	// serializes frames on the wire, the hpack encoder state
+	// must follow the order in which header blocks are sent
+	writeMu sync.Mutex
+	encoder *hpack.Encoder
+	encBuf  bytes.Buffer
+
+	// only used by the reading goroutine
+	decoder            *hpack.Decoder
+	fields             []hpack.HeaderField
+	fieldsSize         int
+	continuationStream uint32
+	headerBlock        []byte
+	headerEndStream    bool
+	connRecvWindow     int64
+
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*stream
+	lastStreamID      uint32
+	connSendWindow    int64
+	peerInitialWindow int64
+	peerMaxFrameSize  uint32
+	goingAway         bool
+	closed            bool
+
+	handlers sync.WaitGroup
+}
+
+func NewServerConn(conn net.Conn, handler response.Handler, tlsState *tls.ConnectionState) *ServerConn {
+	sc := &ServerConn{
+		conn:              conn,
+		reader:            conn,
+		handler:           handler,
+		tlsState:          tlsState,
+		connRecvWindow:    defaultInitialWindowSize,
+		streams:           map[uint32]*stream{},
+		connSendWindow:    defaultInitialWindowSize,
+		peerInitialWindow: defaultInitialWindowSize,
+		peerMaxFrameSize:  defaultMaxFrameSize,
+	}
+
+	sc.cond = sync.NewCond(&sc.mu)
+	sc.decoder = hpack.NewDecoder(defaultHeaderTableSize, sc.collectField)
+	sc.encoder = hpack.NewEncoder(&sc.encBuf)
+	sc.decoder.SetMaxStringLength(maxHeaderListSize)
+
+	return sc
+}
+
+func SniffPreface(r io.Reader) ([]byte, bool, error) {
+	/*
+	* @brief: reads from r until the bytes either match the HTTP/2
+	* client preface or diverge from it
+	*
+	* returns the bytes read, which must be replayed to whichever
+	* parser handles the connection
+	*/
+	buf := make([]byte, 0, len(ClientPreface))
+	tmp := make([]byte, len(ClientPreface))
+	for len(buf) < len(ClientPreface) {
+		n, err := r.Read(tmp[:len(ClientPreface)-len(buf)])
+		buf = append(buf, tmp[:n]...)
+		if !strings.HasPrefix(ClientPreface, string(buf)) {
+			return buf, false, nil
+		}
+
+		if err != nil {
+			return buf, false, err
+		}
+	}
+
+	return buf, true, nil
+}
+
+func IsUpgradeRequest(req *request.Request) bool {
+	/*
+	* reports whether req asks to switch to cleartext HTTP/2
+	* with 'Upgrade: h2c' and a single 'HTTP2-Settings' header
+	*/
+	upgrade, _ := req.Headers.Get("Upgrade")
+	if !headerHasToken(upgrade, "h2c") {
+		return false
+	}
+
+	connection, _ := req.Headers.Get("Connection")
+	settings, ok := req.Headers.Get("HTTP2-Settings")
+
+	return ok && !strings.Contains(settings, ",") &&
+		headerHasToken(connection, "upgrade") && headerHasToken(connection, "http2-settings")
+}
+
+func (sc *ServerConn) Serve(prefix []byte) error {
+	/*
+	* @brief: serves an HTTP/2 connection whose client preface
+	* starts with prefix, the bytes already read from the connection
+	*/
+	sc.reader = io.MultiReader(bytes.NewReader(prefix), sc.conn)
+
+	err := sc.writeFrame(&Frame{Type: FrameSettings, Payload: sc.localSettings()})
+	if err != nil {
+		return err
+	}
+
+	return sc.serve()
+}
+
+func (sc *ServerConn) ServeUpgrade(prefix []byte, req *request.Request) error {
+	/*
+	* @brief: switches an HTTP/1.1 connection to HTTP/2 after an
+	* 'Upgrade: h2c' request, the request is answered on stream 1
+	*
+	* prefix holds the bytes read past the upgrade request
+	*/
+	sc.reader = io.MultiReader(bytes.NewReader(prefix), sc.conn)
+
+	encoded, _ := req.Headers.Get("HTTP2-Settings")
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(encoded), "="))
+	if err != nil {
+		return fmt.Errorf("invalid HTTP2-Settings header: %v", err)
+	}
+
+	settings, err := parseSettings(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = sc.conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nconnection: Upgrade\r\nupgrade: h2c\r\n\r\n"))
+	if err != nil {
+		return err
+	}
+
+	err = sc.writeFrame(&Frame{Type: FrameSettings, Payload: sc.localSettings()})
+	if err != nil {
+		return err
+	}
+
+	err = sc.applySettings(settings)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{"Connection", "Upgrade", "HTTP2-Settings", "Keep-Alive"} {
+		req.Headers.Delete(name)
+	}
+	req.RequestLine.HttpVersion = "2"
+	req.TLS = sc.tlsState
+
+	st := sc.newStream(1)
+	st.req = req
+	st.recvClosed = true
+	st.isHead = req.RequestLine.Method == "HEAD"
+
+	sc.mu.Lock()
+	sc.lastStreamID = 1
+	sc.streams[1] = st
+	sc.mu.Unlock()
+
+	sc.dispatch(st)
+
+	return sc.serve()
+}
+
+func (sc *ServerConn) Shutdown() {
+	/*
+	* @brief: starts a graceful shutdown, no new streams are accepted
+	* and the connection closes once the open ones are done
+	*/
+	sc.mu.Lock()
+	sc.goingAway = true
+	lastStreamID := sc.lastStreamID
+	idle := len(sc.streams) == 0
+	sc.mu.Unlock()
+
+	sc.writeFrame(&Frame{Type: FrameGoAway, Payload: goAwayPayload(lastStreamID, ErrCodeNo, "")})
+
+	if idle {
+		sc.conn.Close()
+	}
+}
+
+func (sc *ServerConn) serve() error {
+	defer func() {
+		sc.mu.Lock()
+		sc.closed = true
+		for _, st := range sc.streams {
+			if st.cancel != nil {
+				st.cancel(ErrConnClosed)
+			}
+		}
+		sc.cond.Broadcast()
+		sc.mu.Unlock()
+
+		sc.conn.Close()
+		sc.handlers.Wait()
+	}()
+
+	preface := make([]byte, len(ClientPreface))
+	_, err := io.ReadFull(sc.reader, preface)
+	if err != nil {
+		return err
+	}
+
+	if string(preface) != ClientPreface {
+		return sc.fail(connError{ErrCodeProtocol, "invalid client preface"})
+	}
+
+	first := true
+	for {
+		f, err := ReadFrame(sc.reader, defaultMaxFrameSize)
+		if err != nil {
+			var ce connError
+			if errors.As(err, &ce) {
+				return sc.fail(ce)
+			}
+
+			if sc.isGoingAway() {
+				return nil
+			}
+
+			return err
+		}
+
+		// the client preface ends with a SETTINGS frame
+		if first && f.Type != FrameSettings {
+			return sc.fail(connError{ErrCodeProtocol, "first frame is not SETTINGS"})
+		}
+		first = false
+
+		err = sc.processFrame(f)
+		if err == io.EOF {
+			return nil
+		}
+
+		var se streamError
+		if errors.As(err, &se) {
+			sc.resetStream(se)
+			continue
+		}
+
+		var ce connError
+		if errors.As(err, &ce) {
+			return sc.fail(ce)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (sc *ServerConn) fail(err connError) error {
+	sc.mu.Lock()
+	lastStreamID := sc.lastStreamID
+	sc.mu.Unlock()
+
+	sc.writeFrame(&Frame{Type: FrameGoAway, Payload: goAwayPayload(lastStreamID, err.code, err.reason)})
+
+	return err
+}
+
+func (sc *ServerConn) processFrame(f *Frame) error {
+	if sc.continuationStream != 0 && f.Type != FrameContinuation {
+		return connError{ErrCodeProtocol, "expected CONTINUATION frame"}
+	}
+
+	switch f.Type {
+	case FrameData:
+		return sc.processData(f)
+
+	case FrameHeaders:
+		return sc.processHeaders(f)
+
+	case FrameContinuation:
+		return sc.processContinuation(f)
+
+	case FramePriority:
+		if f.StreamID == 0 {
+			return connError{ErrCodeProtocol, "PRIORITY on stream 0"}
+		}
+		if len(f.Payload) != 5 {
+			return streamError{f.StreamID, ErrCodeFrameSize, "invalid PRIORITY length"}
+		}
+
+		// priorities are advisory and ignored
+		return nil
+
+	case FrameRSTStream:
+		return sc.processRSTStream(f)
+
+	case FrameSettings:
+		return sc.processSettings(f)
+
+	case FramePushPromise:
+		return connError{ErrCodeProtocol, "clients cannot push"}
+
+	case FramePing:
+		if f.StreamID != 0 {
+			return connError{ErrCodeProtocol, "PING on a stream"}
+		}
+		if len(f.Payload) != 8 {
+			return connError{ErrCodeFrameSize, "invalid PING length"}
+		}
+		if f.Has(FlagAck) {
+			return nil
+		}
+
+		return sc.writeFrame(&Frame{Type: FramePing, Flags: FlagAck, Payload: f.Payload})
+
+	case FrameGoAway:
+		if f.StreamID != 0 {
+			return connError{ErrCodeProtocol, "GOAWAY on a stream"}
+		}
+
+		sc.mu.Lock()
+		sc.goingAway = true
+		idle := len(sc.streams) == 0
+		sc.mu.Unlock()
+
+		if idle {
+			return io.EOF
+		}
+		return nil
+
+	case FrameWindowUpdate:
+		return sc.processWindowUpdate(f)
+	}
+
+	// unknown frame types must be ignored
+	return nil
+}
+
+func (sc *ServerConn) processData(f *Frame) error {
+	if f.StreamID == 0 {
+		return connError{ErrCodeProtocol, "DATA on stream 0"}
+	}
+
+	// flow control accounts for the whole payload, padding included
+	size := int64(len(f.Payload))
+	if size > sc.connRecvWindow {
+		return connError{ErrCodeFlowControl, "connection receive window exceeded"}
+	}
+	sc.connRecvWindow -= size
+
+	data, err := stripPadding(f)
+	if err != nil {
+		return err
+	}
+
+	if size > 0 {
+		// bodies are buffered in memory, so the window is
+		// given back as soon as the data is read
+		sc.connRecvWindow += size
+		sc.writeFrame(&Frame{Type: FrameWindowUpdate, Payload: uint32Payload(uint32(size))})
+	}
+
+	sc.mu.Lock()
+	st, ok := sc.streams[f.StreamID]
+	lastStreamID := sc.lastStreamID
+	sc.mu.Unlock()
+
+	if !ok {
+		if f.StreamID > lastStreamID {
+			return connError{ErrCodeProtocol, "DATA on idle stream"}
+		}
+		return streamError{f.StreamID, ErrCodeStreamClosed, "DATA on closed stream"}
+	}
+
+	if st.recvClosed {
+		return streamError{f.StreamID, ErrCodeStreamClosed, "DATA after END_STREAM"}
+	}
+
+	if size > st.recvWindow {
+		return streamError{f.StreamID, ErrCodeFlowControl, "stream receive window exceeded"}
+	}
+	st.recvWindow -= size
+
+	st.body = append(st.body, data...)
+	maxBody := sc.maxBodySize()
+	if int64(len(st.body)) > maxBody {
+		return sc.refuseBody(st)
+	}
+
+	if f.Has(FlagEndStream) {
+		st.recvClosed = true
+		return sc.endOfRequest(st)
+	}
+
+	// the window is given back until the body could pass the
+	// limit by a byte, which is then answered with a 413
+	credit := min(size, maxBody+1-int64(len(st.body))-st.recvWindow)
+	if credit > 0 {
+		st.recvWindow += credit
+		sc.writeFrame(&Frame{Type: FrameWindowUpdate, StreamID: f.StreamID, Payload: uint32Payload(uint32(credit))})
+	}
+
+	return nil
+}
+
+func (sc *ServerConn) maxBodySize() int64 {
+	if sc.MaxBodySize <= 0 {
+		return DefaultMaxBodySize
+	}
+
+	return sc.MaxBodySize
+}
+
+func (sc *ServerConn) refuseBody(st *stream) error {
+	/*
+	* answers a request whose body is over the limit with a 413,
+	* the stream is then reset so the client stops sending
+	*/
+	st.body = nil
+	sc.writeHeaders(st.id, []hpack.HeaderField{
+		{Name: ":status", Value: "413"},
+		{Name: "content-length", Value: "0"},
+	}, true)
+
+	return streamError{st.id, ErrCodeNo, "request body too large"}
+}
+
+func (sc *ServerConn) processHeaders(f *Frame) error {
+	if f.StreamID == 0 {
+		return connError{ErrCodeProtocol, "HEADERS on stream 0"}
+	}
+
+	if f.StreamID%2 == 0 {
+		return connError{ErrCodeProtocol, "client stream ids must be odd"}
+	}
+
+	block, err := stripPadding(f)
+	if err != nil {
+		return err
+	}
+
+	if f.Has(FlagPriority) {
+		if len(block) < 5 {
+			return connError{ErrCodeFrameSize, "HEADERS too short for priority"}
+		}
+		block = block[5:]
+	}
+
+	sc.headerBlock = append([]byte{}, block...)
+	sc.headerEndStream = f.Has(FlagEndStream)
+	if !f.Has(FlagEndHeaders) {
+		sc.continuationStream = f.StreamID
+		return nil
+	}
+
+	return sc.processHeaderBlock(f.StreamID)
+}
+
+func (sc *ServerConn) processContinuation(f *Frame) error {
+	if f.StreamID == 0 || f.StreamID != sc.continuationStream {
+		return connError{ErrCodeProtocol, "unexpected CONTINUATION frame"}
+	}
+
+	sc.headerBlock = append(sc.headerBlock, f.Payload...)
+	if len(sc.headerBlock) > maxHeaderListSize {
+		return connError{ErrCodeEnhanceYourCalm, "header block too large"}
+	}
+
+	if !f.Has(FlagEndHeaders) {
+		return nil
+	}
+
+	sc.continuationStream = 0
+
+	return sc.processHeaderBlock(f.StreamID)
+}
+
+func (sc *ServerConn) collectField(f hpack.HeaderField) {
+	/*
+	* sizes the decoded list the way SETTINGS_MAX_HEADER_LIST_SIZE
+	* does, fields past the limit are decoded but not kept
+	*/
+	sc.fieldsSize += len(f.Name) + len(f.Value) + 32
+	if sc.fieldsSize <= maxHeaderListSize {
+		sc.fields = append(sc.fields, f)
+	}
+}
+
+func (sc *ServerConn) processHeaderBlock(streamID uint32) error {
+	// the block is decoded even for refused streams
+	// to keep the hpack state in sync with the peer
+	sc.fields, sc.fieldsSize = nil, 0
+	_, err := sc.decoder.Write(sc.headerBlock)
+	if err == nil {
+		err = sc.decoder.Close()
+	}
+	fields, tooLarge := sc.fields, sc.fieldsSize > maxHeaderListSize
+	sc.fields, sc.headerBlock = nil, nil
+	if err != nil {
+		return connError{ErrCodeCompression, err.Error()}
+	}
+
+	sc.mu.Lock()
+	st, exists := sc.streams[streamID]
+	lastStreamID := sc.lastStreamID
+	goingAway := sc.goingAway
+	active := len(sc.streams)
+	sc.mu.Unlock()
+
+	if exists {
+		// trailers, which are accepted and dropped
+		if st.recvClosed || !sc.headerEndStream {
+			return streamError{streamID, ErrCodeProtocol, "unexpected HEADERS on open stream"}
+		}
+
+		st.recvClosed = true
+		return sc.endOfRequest(st)
+	}
+
+	if streamID <= lastStreamID {
+		return connError{ErrCodeStreamClosed, "HEADERS on closed stream"}
+	}
+
+	sc.mu.Lock()
+	sc.lastStreamID = streamID
+	sc.mu.Unlock()
+
+	if goingAway {
+		return nil
+	}
+
+	if active >= maxConcurrentStreams {
+		return streamError{streamID, ErrCodeRefusedStream, "too many concurrent streams"}
+	}
+
+	if tooLarge {
+		sc.writeHeaders(streamID, []hpack.HeaderField{
+			{Name: ":status", Value: "431"},
+			{Name: "content-length", Value: "0"},
+		}, true)
+		return streamError{streamID, ErrCodeNo, "header list too large"}
+	}
+
+	req, err := buildRequest(fields)
+	if err != nil {
+		return streamError{streamID, ErrCodeProtocol, err.Error()}
+	}
+	req.TLS = sc.tlsState
+
+	st = sc.newStream(streamID)
+	st.req = req
+	st.isHead = req.RequestLine.Method == "HEAD"
+
+	sc.mu.Lock()
+	sc.streams[streamID] = st
+	sc.mu.Unlock()
+
+	if sc.headerEndStream {
+		st.recvClosed = true
+		return sc.endOfRequest(st)
+	}
+
+	return nil
+}
+
+func (sc *ServerConn) processRSTStream(f *Frame) error {
+	if f.StreamID == 0 {
+		return connError{ErrCodeProtocol, "RST_STREAM on stream 0"}
+	}
+	if len(f.Payload) != 4 {
+		return connError{ErrCodeFrameSize, "invalid RST_STREAM length"}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if f.StreamID > sc.lastStreamID {
+		return connError{ErrCodeProtocol, "RST_STREAM on idle stream"}
+	}
+
+	st, ok := sc.streams[f.StreamID]
+	if !ok {
+		return nil
+	}
+
+	st.reset = true
+	if st.cancel != nil {
+		st.cancel(ErrStreamReset)
+	}
+	if !st.dispatched {
+		delete(sc.streams, f.StreamID)
+	}
+	sc.cond.Broadcast()
+
+	return nil
+}
+
+func (sc *ServerConn) processSettings(f *Frame) error {
+	if f.StreamID != 0 {
+		return connError{ErrCodeProtocol, "SETTINGS on a stream"}
+	}
+
+	if f.Has(FlagAck) {
+		if len(f.Payload) != 0 {
+			return connError{ErrCodeFrameSize, "SETTINGS ack with payload"}
+		}
+		return nil
+	}
+
+	settings, err := parseSettings(f.Payload)
+	if err != nil {
+		return err
+	}
+
+	err = sc.applySettings(settings)
+	if err != nil {
+		return err
+	}
+
+	return sc.writeFrame(&Frame{Type: FrameSettings, Flags: FlagAck})
+}
+
+func (sc *ServerConn) applySettings(settings []Setting) error {
+	for _, s := range settings {
+		switch s.ID {
+		case SettingHeaderTableSize:
+			sc.writeMu.Lock()
+			sc.encoder.SetMaxDynamicTableSizeLimit(s.Val)
+			sc.writeMu.Unlock()
+
+		case SettingEnablePush:
+			if s.Val > 1 {
+				return connError{ErrCodeProtocol, "invalid ENABLE_PUSH value"}
+			}
+
+		case SettingInitialWindowSize:
+			if s.Val > maxWindowSize {
+				return connError{ErrCodeFlowControl, "initial window size too large"}
+			}
+
+			sc.mu.Lock()
+			delta := int64(s.Val) - sc.peerInitialWindow
+			sc.peerInitialWindow = int64(s.Val)
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					sc.mu.Unlock()
+					return connError{ErrCodeFlowControl, "stream window overflow"}
+				}
+			}
+			sc.cond.Broadcast()
+			sc.mu.Unlock()
+
+		case SettingMaxFrameSize:
+			if s.Val < defaultMaxFrameSize || s.Val > maxAllowedFrameSize {
+				return connError{ErrCodeProtocol, "invalid MAX_FRAME_SIZE value"}
+			}
+
+			sc.mu.Lock()
+			sc.peerMaxFrameSize = s.Val
+			sc.mu.Unlock()
+		}
+	}
+
+	return nil
+}
+
+func (sc *ServerConn) processWindowUpdate(f *Frame) error {
+	if len(f.Payload) != 4 {
+		return connError{ErrCodeFrameSize, "invalid WINDOW_UPDATE length"}
+	}
+
+	increment := int64(uint32(f.Payload[0]&0x7f)<<24 | uint32(f.Payload[1])<<16 | uint32(f.Payload[2])<<8 | uint32(f.Payload[3]))
+	if increment == 0 {
+		if f.StreamID == 0 {
+			return connError{ErrCodeProtocol, "zero window increment"}
+		}
+		return streamError{f.StreamID, ErrCodeProtocol, "zero window increment"}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if f.StreamID == 0 {
+		sc.connSendWindow += increment
+		if sc.connSendWindow > maxWindowSize {
+			return connError{ErrCodeFlowControl, "connection window overflow"}
+		}
+		sc.cond.Broadcast()
+		return nil
+	}
+
+	st, ok := sc.streams[f.StreamID]
+	if !ok {
+		return nil
+	}
+
+	st.sendWindow += increment
+	if st.sendWindow > maxWindowSize {
+		return streamError{f.StreamID, ErrCodeFlowControl, "stream window overflow"}
+	}
+	sc.cond.Broadcast()
+
+	return nil
+}
+
+func (sc *ServerConn) endOfRequest(st *stream) error {
+	contentLength, ok := st.req.Headers.Get("Content-Length")
+	if ok && contentLength != fmt.Sprint(len(st.body)) {
+		return streamError{st.id, ErrCodeProtocol, "body does not match Content-Length"}
+	}
+
+	st.req.Body = st.body
+	sc.dispatch(st)
+
+	return nil
+}
+
+func (sc *ServerConn) dispatch(st *stream) {
+	/*
+	* runs the handler of st, its request context is cancelled
+	* when the stream is reset or the connection goes away
+	*/
+	ctx, cancel := context.WithCancelCause(st.req.Context())
+	st.req.SetContext(ctx)
+
+	sc.mu.Lock()
+	st.dispatched = true
+	st.cancel = cancel
+	sc.mu.Unlock()
+
+	sc.handlers.Add(1)
+	go sc.runHandler(st)
+}
+
+func (sc *ServerConn) runHandler(st *stream) {
+	defer sc.handlers.Done()
+	defer st.cancel(context.Canceled)
+
+	w := response.NewResponseWriter(&streamConn{st: st})
+	sc.handler(&w, st.req)
+
+	if st.req.MultipartForm != nil {
+		st.req.MultipartForm.RemoveAll()
+	}
+
+	st.finish()
+}
+
+func (sc *ServerConn) resetStream(err streamError) {
+	sc.writeFrame(&Frame{Type: FrameRSTStream, StreamID: err.streamID, Payload: uint32Payload(uint32(err.code))})
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	st, ok := sc.streams[err.streamID]
+	if !ok {
+		return
+	}
+
+	st.reset = true
+	if st.cancel != nil {
+		st.cancel(ErrStreamReset)
+	}
+	if !st.dispatched {
+		delete(sc.streams, err.streamID)
+	}
+	sc.cond.Broadcast()
+}
+
+func (sc *ServerConn) closeStream(st *stream) {
+	sc.mu.Lock()
+	st.closed = true
+	delete(sc.streams, st.id)
+	sc.cond.Broadcast()
+	done := sc.goingAway && len(sc.streams) == 0
+	sc.mu.Unlock()
+
+	if done {
+		sc.conn.Close()
+	}
+}
+
+func (sc *ServerConn) isGoingAway() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return sc.goingAway
+}
+
+func (sc *ServerConn) newStream(id uint32) *stream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return &stream{
+		id:         id,
+		sc:         sc,
+		sendWindow: sc.peerInitialWindow,
+		recvWindow: defaultInitialWindowSize,
+	}
+}
+
+func (sc *ServerConn) localSettings() []byte {
+	return settingsPayload(
+		Setting{SettingMaxConcurrentStreams, maxConcurrentStreams},
+		Setting{SettingMaxHeaderListSize, maxHeaderListSize},
+		Setting{SettingEnablePush, 0},
+	)
+}
+
+func (sc *ServerConn) writeFrame(f *Frame) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	return WriteFrame(sc.conn, f)
+}
+
+func (sc *ServerConn) writeHeaders(streamID uint32, fields []hpack.HeaderField, endStream bool) error {
+	sc.mu.Lock()
+	maxFrameSize := int(sc.peerMaxFrameSize)
+	sc.mu.Unlock()
+
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	sc.encBuf.Reset()
+	for _, field := range fields {
+		err := sc.encoder.WriteField(field)
+		if err != nil {
+			return err
+		}
+	}
+
+	// blocks larger than a frame continue in CONTINUATION frames
+	block := sc.encBuf.Bytes()
+	frameType := FrameHeaders
+	for first := true; first || len(block) > 0; first = false {
+		chunk := block
+		if len(chunk) > maxFrameSize {
+			chunk = chunk[:maxFrameSize]
+		}
+		block = block[len(chunk):]
+
+		flags := uint8(0)
+		if first && endStream {
+			flags |= FlagEndStream
+		}
+		if len(block) == 0 {
+			flags |= FlagEndHeaders
+		}
+
+		err := WriteFrame(sc.conn, &Frame{Type: frameType, Flags: flags, StreamID: streamID, Payload: chunk})
+		if err != nil {
+			return err
+		}
+
+		frameType = FrameContinuation
+	}
+
+	return nil
+}
+
+func buildRequest(fields []hpack.HeaderField) (*request.Request, error) {
+	/*
+	* @brief: maps a decoded header list to a request, enforcing
+	* the HTTP/2 rules on pseudo and connection-specific headers
+	*/
+	req := &request.Request{
+		Headers: headers.Headers{},
+		Body:    []byte{},
+	}
+	req.RequestLine.HttpVersion = "2"
+
+	pseudo := map[string]string{}
+	// repeated fields are joined once, not concatenated one by one
+	values := map[string][]string{}
+	regularSeen := false
+	for _, field := range fields {
+		if !headers.ValidValue(field.Value) {
+			return nil, fmt.Errorf("invalid value for header %s", field.Name)
+		}
+
+		if strings.HasPrefix(field.Name, ":") {
+			if regularSeen {
+				return nil, fmt.Errorf("pseudo header %s after regular headers", field.Name)
+			}
+
+			switch field.Name {
+			case ":method", ":scheme", ":authority", ":path":
+			default:
+				return nil, fmt.Errorf("unknown pseudo header %s", field.Name)
+			}
+
+			if _, dup := pseudo[field.Name]; dup {
+				return nil, fmt.Errorf("duplicate pseudo header %s", field.Name)
+			}
+
+			pseudo[field.Name] = field.Value
+			continue
+		}
+
+		regularSeen = true
+		if !headers.ValidName(field.Name) {
+			return nil, fmt.Errorf("invalid header name %q", field.Name)
+		}
+		if field.Name != strings.ToLower(field.Name) {
+			return nil, fmt.Errorf("uppercase header name %s", field.Name)
+		}
+
+		switch field.Name {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			return nil, fmt.Errorf("connection-specific header %s", field.Name)
+		case "te":
+			if field.Value != "trailers" {
+				return nil, fmt.Errorf("invalid te header")
+			}
+		}
+
+		values[field.Name] = append(values[field.Name], field.Value)
+	}
+
+	for name, vals := range values {
+		req.Headers[name] = strings.Join(vals, ", ")
+	}
+
+	if pseudo[":method"] == "" || pseudo[":path"] == "" || pseudo[":scheme"] == "" {
+		return nil, fmt.Errorf("missing pseudo headers")
+	}
+
+	err := request.ValidateRequestLine(pseudo[":method"], pseudo[":path"])
+	if err != nil {
+		return nil, err
+	}
+
+	req.RequestLine.Method = pseudo[":method"]
+	req.RequestLine.RequestTarget = pseudo[":path"]
+
+	if authority := pseudo[":authority"]; authority != "" {
+		req.Headers.AddOverride("Host", authority)
+	}
+
+	return req, nil
+}
+
+func headerHasToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,216 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	frameHeaderLen = 9
+
+	// defaults from RFC 9113 section 6.5.2
+	defaultMaxFrameSize      = 16384
+	maxAllowedFrameSize      = 1<<24 - 1
+	defaultInitialWindowSize = 65535
+	maxWindowSize            = 1<<31 - 1
+	defaultHeaderTableSize   = 4096
+)
+
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+type FrameType uint8
+
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+const (
+	FlagEndStream  uint8 = 0x1
+	FlagAck        uint8 = 0x1
+	FlagEndHeaders uint8 = 0x4
+	FlagPadded     uint8 = 0x8
+	FlagPriority   uint8 = 0x20
+)
+
+type ErrCode uint32
+
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrCode = 0xb
+	ErrCodeInadequateSecurity ErrCode = 0xc
+	ErrCodeHTTP11Required     ErrCode = 0xd
+)
+
+type SettingID uint16
+
+const (
+	SettingHeaderTableSize      SettingID = 0x1
+	SettingEnablePush           SettingID = 0x2
+	SettingMaxConcurrentStreams SettingID = 0x3
+	SettingInitialWindowSize    SettingID = 0x4
+	SettingMaxFrameSize         SettingID = 0x5
+	SettingMaxHeaderListSize    SettingID = 0x6
+)
+
+type Setting struct {
+	ID  SettingID
+	Val uint32
+}
+
+type Frame struct {
+	Type     FrameType
+	Flags    uint8
+	StreamID uint32
+	Payload  []byte
+}
+
+// connError terminates the whole connection with a GOAWAY
+type connError struct {
+	code   ErrCode
+	reason string
+}
+
+// streamError resets a single stream with RST_STREAM
+type streamError struct {
+	streamID uint32
+	code     ErrCode
+	reason   string
+}
+
+func (e connError) Error() string {
+	return fmt.Sprintf("http2 connection error %d: %s", e.code, e.reason)
+}
+
+func (e streamError) Error() string {
+	return fmt.Sprintf("http2 stream %d error %d: %s", e.streamID, e.code, e.reason)
+}
+
+func (f *Frame) Has(flag uint8) bool {
+	return f.Flags&flag != 0
+}
+
+func ReadFrame(r io.Reader, maxFrameSize uint32) (*Frame, error) {
+	/*
+	* @brief: reads a single frame, payloads larger than
+	* maxFrameSize are a connection error
+	*/
+	header := make([]byte, frameHeaderLen)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	length := uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+	if length > maxFrameSize {
+		return nil, connError{ErrCodeFrameSize, fmt.Sprintf("frame of %d bytes exceeds the maximum size", length)}
+	}
+
+	f := &Frame{
+		Type:  FrameType(header[3]),
+		Flags: header[4],
+		// the reserved bit is ignored on receipt
+		StreamID: binary.BigEndian.Uint32(header[5:]) & (1<<31 - 1),
+		Payload:  make([]byte, length),
+	}
+
+	_, err = io.ReadFull(r, f.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func WriteFrame(w io.Writer, f *Frame) error {
+	buf := make([]byte, frameHeaderLen, frameHeaderLen+len(f.Payload))
+	length := len(f.Payload)
+	buf[0] = byte(length >> 16)
+	buf[1] = byte(length >> 8)
+	buf[2] = byte(length)
+	buf[3] = byte(f.Type)
+	buf[4] = f.Flags
+	binary.BigEndian.PutUint32(buf[5:], f.StreamID&(1<<31-1))
+	buf = append(buf, f.Payload...)
+
+	_, err := w.Write(buf)
+
+	return err
+}
+
+func settingsPayload(settings ...Setting) []byte {
+	payload := make([]byte, 0, 6*len(settings))
+	for _, s := range settings {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(s.ID))
+		payload = binary.BigEndian.AppendUint32(payload, s.Val)
+	}
+
+	return payload
+}
+
+func parseSettings(payload []byte) ([]Setting, error) {
+	if len(payload)%6 != 0 {
+		return nil, connError{ErrCodeFrameSize, "settings payload is not a multiple of 6"}
+	}
+
+	settings := make([]Setting, 0, len(payload)/6)
+	for i := 0; i < len(payload); i += 6 {
+		settings = append(settings, Setting{
+			ID:  SettingID(binary.BigEndian.Uint16(payload[i:])),
+			Val: binary.BigEndian.Uint32(payload[i+2:]),
+		})
+	}
+
+	return settings, nil
+}
+
+func goAwayPayload(lastStreamID uint32, code ErrCode, debug string) []byte {
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+
+	return append(payload, debug...)
+}
+
+func uint32Payload(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func stripPadding(f *Frame) ([]byte, error) {
+	/*
+	* returns the payload of a DATA or HEADERS frame
+	* without the padding length and the padding
+	*/
+	if !f.Has(FlagPadded) {
+		return f.Payload, nil
+	}
+
+	if len(f.Payload) == 0 {
+		return nil, connError{ErrCodeProtocol, "padded frame without padding length"}
+	}
+
+	padLen := int(f.Payload[0])
+	if padLen >= len(f.Payload) {
+		return nil, connError{ErrCodeProtocol, "padding longer than the frame payload"}
+	}
+
+	return f.Payload[1 : len(f.Payload)-padLen], nil
+}
@@ -0,0 +1,534 @@
+package http2
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2/hpack"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	encBuf bytes.Buffer
+	enc    *hpack.Encoder
+	dec    *hpack.Decoder
+}
+
+type testResponse struct {
+	status  string
+	headers map[string]string
+	body    string
+}
+
+func newTestClient(t *testing.T, handler response.Handler, settings ...Setting) *testClient {
+	/*
+	* serves handler over HTTP/2 on a loopback connection and
+	* returns a client that already sent the preface and settings
+	*/
+	return newTestClientWith(t, func(conn net.Conn) *ServerConn {
+		return NewServerConn(conn, handler, nil)
+	}, settings...)
+}
+
+func newTestClientWith(t *testing.T, newConn func(conn net.Conn) *ServerConn, settings ...Setting) *testClient {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		newConn(conn).Serve(nil)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	c := &testClient{t: t, conn: conn, dec: hpack.NewDecoder(defaultHeaderTableSize, nil)}
+	c.enc = hpack.NewEncoder(&c.encBuf)
+
+	_, err = conn.Write([]byte(ClientPreface))
+	require.NoError(t, err)
+	c.write(&Frame{Type: FrameSettings, Payload: settingsPayload(settings...)})
+
+	return c
+}
+
+func (c *testClient) write(f *Frame) {
+	require.NoError(c.t, WriteFrame(c.conn, f))
+}
+
+func (c *testClient) request(streamID uint32, method, path string, endStream bool, extra ...hpack.HeaderField) {
+	c.encBuf.Reset()
+	fields := []hpack.HeaderField{
+		{Name: ":method", Value: method},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":authority", Value: "localhost"},
+		{Name: ":path", Value: path},
+	}
+	for _, field := range append(fields, extra...) {
+		require.NoError(c.t, c.enc.WriteField(field))
+	}
+
+	flags := FlagEndHeaders
+	if endStream {
+		flags |= FlagEndStream
+	}
+	c.write(&Frame{Type: FrameHeaders, Flags: flags, StreamID: streamID, Payload: append([]byte{}, c.encBuf.Bytes()...)})
+}
+
+func (c *testClient) readFrame() *Frame {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	f, err := ReadFrame(c.conn, maxAllowedFrameSize)
+	require.NoError(c.t, err)
+
+	return f
+}
+
+func (c *testClient) readResponses(count int) map[uint32]*testResponse {
+	/*
+	* reads frames until count streams have ended, settings
+	* are acknowledged and window updates skipped
+	*/
+	responses := map[uint32]*testResponse{}
+	ended := 0
+	for ended < count {
+		f := c.readFrame()
+		switch f.Type {
+		case FrameSettings:
+			if !f.Has(FlagAck) {
+				c.write(&Frame{Type: FrameSettings, Flags: FlagAck})
+			}
+			continue
+
+		case FrameHeaders:
+			fields, err := c.dec.DecodeFull(f.Payload)
+			require.NoError(c.t, err)
+			resp := &testResponse{headers: map[string]string{}}
+			for _, field := range fields {
+				if field.Name == ":status" {
+					resp.status = field.Value
+				} else {
+					resp.headers[field.Name] = field.Value
+				}
+			}
+			responses[f.StreamID] = resp
+
+		case FrameData:
+			responses[f.StreamID].body += string(f.Payload)
+
+		case FrameGoAway, FrameRSTStream:
+			c.t.Fatalf("unexpected frame type %d", f.Type)
+
+		default:
+			continue
+		}
+
+		if f.Has(FlagEndStream) {
+			ended++
+		}
+	}
+
+	return responses
+}
+
+func textHandler(body string) response.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		h := headers.GetDefaultHeaders(len(body))
+		w.Response = &response.Response{Code: response.CodeOK, Message: []byte(body), Headers: h}
+		w.WriteResponse()
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	f := &Frame{Type: FrameHeaders, Flags: FlagEndHeaders | FlagEndStream, StreamID: 7, Payload: []byte("abc")}
+	require.NoError(t, WriteFrame(&buf, f))
+	assert.Equal(t, frameHeaderLen+3, buf.Len())
+
+	read, err := ReadFrame(&buf, defaultMaxFrameSize)
+	require.NoError(t, err)
+	assert.Equal(t, f, read)
+
+	// test: frame over the maximum size
+	big := &Frame{Type: FrameData, StreamID: 1, Payload: make([]byte, defaultMaxFrameSize+1)}
+	require.NoError(t, WriteFrame(&buf, big))
+	_, err = ReadFrame(&buf, defaultMaxFrameSize)
+	var ce connError
+	require.ErrorAs(t, err, &ce)
+	assert.Equal(t, ErrCodeFrameSize, ce.code)
+}
+
+func TestSniffPreface(t *testing.T) {
+	// test: HTTP/1.1 requests shorter than the preface do not block
+	prefix, ok, err := SniffPreface(bytes.NewReader([]byte("GET / HTTP/1.1\r\n\r\n")))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", string(prefix))
+
+	// test: preface
+	prefix, ok, err = SniffPreface(bytes.NewReader([]byte(ClientPreface + "rest")))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, ClientPreface, string(prefix))
+}
+
+func TestServeRequest(t *testing.T) {
+	c := newTestClient(t, textHandler("hello h2"))
+	c.request(1, "GET", "/coffee", true)
+
+	responses := c.readResponses(1)
+	require.Contains(t, responses, uint32(1))
+	assert.Equal(t, "200", responses[1].status)
+	assert.Equal(t, "hello h2", responses[1].body)
+	assert.Equal(t, "8", responses[1].headers["content-length"])
+	assert.NotContains(t, responses[1].headers, "connection")
+
+	// test: HEAD responses carry no body
+	c.request(3, "HEAD", "/coffee", true)
+	responses = c.readResponses(1)
+	assert.Equal(t, "200", responses[3].status)
+	assert.Empty(t, responses[3].body)
+}
+
+func TestRequestBody(t *testing.T) {
+	echo := func(w *response.Writer, req *request.Request) {
+		body := req.RequestLine.Method + " " + string(req.Body)
+		w.Response = &response.Response{Code: response.CodeOK, Message: []byte(body), Headers: headers.GetDefaultHeaders(len(body))}
+		w.WriteResponse()
+	}
+
+	c := newTestClient(t, echo)
+	c.request(1, "POST", "/submit", false, hpack.HeaderField{Name: "content-length", Value: "11"})
+	c.write(&Frame{Type: FrameData, StreamID: 1, Payload: []byte("hello ")})
+	c.write(&Frame{Type: FrameData, Flags: FlagEndStream, StreamID: 1, Payload: []byte("world")})
+
+	responses := c.readResponses(1)
+	assert.Equal(t, "POST hello world", responses[1].body)
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	c := newTestClientWith(t, func(conn net.Conn) *ServerConn {
+		sc := NewServerConn(conn, func(w *response.Writer, req *request.Request) {
+			body := fmt.Sprint(len(req.Body))
+			w.Response = &response.Response{Code: response.CodeOK, Message: []byte(body), Headers: headers.GetDefaultHeaders(len(body))}
+			w.WriteResponse()
+		}, nil)
+		sc.MaxBodySize = 100_000
+		return sc
+	})
+
+	connWindow := int64(defaultInitialWindowSize)
+	send := func(streamID uint32, n int) (int, *Frame) {
+		/*
+		* sends up to n bytes within the windows the server opens,
+		* stops at the first HEADERS or RST_STREAM of the stream
+		*/
+		streamWindow := int64(defaultInitialWindowSize)
+		sent := 0
+		for sent < n {
+			chunk := min(int64(defaultMaxFrameSize), int64(n-sent), streamWindow, connWindow)
+			if chunk > 0 {
+				flags := uint8(0)
+				if sent+int(chunk) == n {
+					flags = FlagEndStream
+				}
+				c.write(&Frame{Type: FrameData, Flags: flags, StreamID: streamID, Payload: make([]byte, chunk)})
+				sent += int(chunk)
+				streamWindow -= chunk
+				connWindow -= chunk
+				continue
+			}
+
+			f := c.readFrame()
+			switch {
+			case f.Type == FrameSettings && !f.Has(FlagAck):
+				c.write(&Frame{Type: FrameSettings, Flags: FlagAck})
+			case f.Type == FrameWindowUpdate && f.StreamID == 0:
+				connWindow += int64(binary.BigEndian.Uint32(f.Payload))
+			case f.Type == FrameWindowUpdate && f.StreamID == streamID:
+				streamWindow += int64(binary.BigEndian.Uint32(f.Payload))
+			case f.StreamID == streamID:
+				return sent, f
+			}
+		}
+		return sent, nil
+	}
+
+	// test: bodies longer than the initial window get through as
+	// the window is given back
+	c.request(1, "POST", "/", false)
+	sent, _ := send(1, 80_000)
+	assert.Equal(t, 80_000, sent)
+	responses := c.readResponses(1)
+	assert.Equal(t, "200", responses[1].status)
+	assert.Equal(t, "80000", responses[1].body)
+
+	// test: the window opens a byte past the limit, then the
+	// request is answered with a 413 and the stream reset
+	c.request(3, "POST", "/", false)
+	sent, f := send(3, 150_000)
+	assert.Equal(t, 100_001, sent)
+	require.NotNil(t, f)
+	require.Equal(t, FrameHeaders, f.Type)
+	fields, err := c.dec.DecodeFull(f.Payload)
+	require.NoError(t, err)
+	assert.Equal(t, "413", fields[0].Value)
+
+	for {
+		f = c.readFrame()
+		if f.Type == FrameRSTStream {
+			assert.Equal(t, uint32(3), f.StreamID)
+			assert.Equal(t, uint32(ErrCodeNo), binary.BigEndian.Uint32(f.Payload))
+			break
+		}
+	}
+
+	// test: sending past the window resets the stream, before
+	// the body reaches the limit
+	c.request(5, "POST", "/", false)
+	for i := 0; i < 7; i++ {
+		c.write(&Frame{Type: FrameData, StreamID: 5, Payload: make([]byte, defaultMaxFrameSize)})
+	}
+	for {
+		f = c.readFrame()
+		if f.Type == FrameRSTStream && f.StreamID == 5 {
+			assert.Equal(t, uint32(ErrCodeFlowControl), binary.BigEndian.Uint32(f.Payload))
+			break
+		}
+	}
+}
+
+func TestMultiplexing(t *testing.T) {
+	fastDone := make(chan struct{})
+	handler := func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			<-fastDone
+		}
+		textHandler(req.RequestLine.RequestTarget)(w, req)
+		if req.RequestLine.RequestTarget == "/fast" {
+			close(fastDone)
+		}
+	}
+
+	c := newTestClient(t, handler)
+	c.request(1, "GET", "/slow", true)
+	c.request(3, "GET", "/fast", true)
+
+	responses := c.readResponses(2)
+	assert.Equal(t, "/slow", responses[1].body)
+	assert.Equal(t, "/fast", responses[3].body)
+}
+
+func TestFlowControl(t *testing.T) {
+	body := "0123456789abcdefghijklmno"
+	c := newTestClient(t, textHandler(body), Setting{SettingInitialWindowSize, 10})
+	c.request(1, "GET", "/", true)
+
+	received := ""
+	for len(received) < 10 {
+		f := c.readFrame()
+		switch f.Type {
+		case FrameSettings:
+			if !f.Has(FlagAck) {
+				c.write(&Frame{Type: FrameSettings, Flags: FlagAck})
+			}
+		case FrameHeaders:
+			_, err := c.dec.DecodeFull(f.Payload)
+			require.NoError(t, err)
+		case FrameData:
+			received += string(f.Payload)
+		}
+	}
+	assert.Equal(t, body[:10], received)
+
+	// test: nothing more is sent until the window grows
+	c.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := ReadFrame(c.conn, maxAllowedFrameSize)
+	require.Error(t, err)
+
+	c.write(&Frame{Type: FrameWindowUpdate, StreamID: 1, Payload: binary.BigEndian.AppendUint32(nil, 100)})
+	for {
+		f := c.readFrame()
+		if f.Type == FrameData {
+			received += string(f.Payload)
+			if f.Has(FlagEndStream) {
+				break
+			}
+		}
+	}
+	assert.Equal(t, body, received)
+}
+
+func TestConnectionErrors(t *testing.T) {
+	// test: DATA on stream 0 ends the connection
+	c := newTestClient(t, textHandler("unused"))
+	c.write(&Frame{Type: FrameData, StreamID: 0, Payload: []byte("x")})
+
+	for {
+		f := c.readFrame()
+		if f.Type == FrameGoAway {
+			assert.Equal(t, uint32(ErrCodeProtocol), binary.BigEndian.Uint32(f.Payload[4:8]))
+			break
+		}
+	}
+
+	// test: malformed requests reset their stream only
+	c = newTestClient(t, textHandler("still alive"))
+	c.request(1, "GET", "/", true, hpack.HeaderField{Name: "connection", Value: "keep-alive"})
+	for {
+		f := c.readFrame()
+		if f.Type == FrameRSTStream {
+			assert.Equal(t, uint32(1), f.StreamID)
+			assert.Equal(t, uint32(ErrCodeProtocol), binary.BigEndian.Uint32(f.Payload))
+			break
+		}
+	}
+
+	c.request(3, "GET", "/", true)
+	responses := c.readResponses(1)
+	assert.Equal(t, "still alive", responses[3].body)
+
+	// test: invalid methods, targets, names and values cannot
+	// reach the handler
+	bad := []struct {
+		method, path string
+		extra        []hpack.HeaderField
+	}{
+		{"GET\r\nX-Smuggled: 1", "/", nil},
+		{"BREW", "/", nil},
+		{"GET", "/a b", nil},
+		{"GET", "/\r\nHost: evil", nil},
+		{"GET", "/", []hpack.HeaderField{{Name: "x-a", Value: "1\r\nx-b: 2"}}},
+		{"GET", "/", []hpack.HeaderField{{Name: "x-a", Value: "1\x00"}}},
+		{"GET", "/", []hpack.HeaderField{{Name: "x a", Value: "1"}}},
+		{"GET", "/", []hpack.HeaderField{{Name: "x-a\r\nx-b", Value: "1"}}},
+	}
+	for i, r := range bad {
+		id := uint32(5 + 2*i)
+		c.request(id, r.method, r.path, true, r.extra...)
+		for {
+			f := c.readFrame()
+			if f.Type == FrameRSTStream {
+				assert.Equal(t, id, f.StreamID)
+				assert.Equal(t, uint32(ErrCodeProtocol), binary.BigEndian.Uint32(f.Payload), r)
+				break
+			}
+			require.NotEqual(t, FrameHeaders, f.Type, r)
+		}
+	}
+
+	// test: header lists past the advertised size are answered
+	// with a 431, however small their compressed block
+	c = newTestClient(t, textHandler("still alive"))
+	big := hpack.HeaderField{Name: "x-big", Value: strings.Repeat("a", 4000)}
+	repeated := make([]hpack.HeaderField, 300)
+	for i := range repeated {
+		repeated[i] = big
+	}
+	c.request(1, "GET", "/", true, repeated...)
+	for {
+		f := c.readFrame()
+		if f.Type == FrameHeaders {
+			assert.Less(t, c.encBuf.Len(), 8000)
+			fields, err := c.dec.DecodeFull(f.Payload)
+			require.NoError(t, err)
+			assert.Equal(t, "431", fields[0].Value)
+			break
+		}
+	}
+	for {
+		if f := c.readFrame(); f.Type == FrameRSTStream {
+			break
+		}
+	}
+
+	c.request(3, "GET", "/", true)
+	responses = c.readResponses(1)
+	assert.Equal(t, "still alive", responses[3].body)
+
+	// test: ping
+	c.write(&Frame{Type: FramePing, Payload: []byte("12345678")})
+	for {
+		f := c.readFrame()
+		if f.Type == FramePing {
+			assert.True(t, f.Has(FlagAck))
+			assert.Equal(t, "12345678", string(f.Payload))
+			break
+		}
+	}
+}
+
+func TestChunkedBody(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.CodeOK)
+		w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked"})
+		w.WriteChunkedBody([]byte("first\r\n"))
+		w.WriteChunkedBody([]byte("second"))
+		w.WriteChunkedBodyDone()
+	}
+
+	c := newTestClient(t, handler)
+	c.request(1, "GET", "/", true)
+
+	responses := c.readResponses(1)
+	assert.Equal(t, "first\r\nsecond", responses[1].body)
+	assert.NotContains(t, responses[1].headers, "transfer-encoding")
+
+	// test: chunks split across writes
+	d := &chunkedDecoder{}
+	out := ""
+	for _, part := range []string{"a", "\r\n0123", "456789\r", "\nb;ext=1\r\nhello", " world\r\n0\r\n\r\n"} {
+		p, err := d.decode([]byte(part))
+		require.NoError(t, err)
+		out += string(p)
+	}
+	assert.Equal(t, "0123456789hello world", out)
+}
+
+func TestStreamContext(t *testing.T) {
+	causes := make(chan error, 2)
+	handler := func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+		causes <- context.Cause(req.Context())
+	}
+
+	c := newTestClient(t, handler)
+
+	// test: a reset stream cancels its request context
+	c.request(1, "GET", "/wait", true)
+	c.write(&Frame{Type: FrameRSTStream, StreamID: 1, Payload: uint32Payload(uint32(ErrCodeCancel))})
+	select {
+	case err := <-causes:
+		assert.ErrorIs(t, err, ErrStreamReset)
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not cancelled on RST_STREAM")
+	}
+
+	// test: so does the connection going away
+	c.request(3, "GET", "/wait", true)
+	time.Sleep(50 * time.Millisecond)
+	c.conn.Close()
+	select {
+	case err := <-causes:
+		assert.ErrorIs(t, err, ErrConnClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not cancelled on connection close")
+	}
+}
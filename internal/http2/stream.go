@@ -0,0 +1,365 @@
+package http2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+
+	"Servus/internal/request"
+)
+
+var (
+	errStreamClosed = errors.New("http2: stream closed")
+
+	// causes of the request context cancellation
+	ErrStreamReset = errors.New("http2: stream reset")
+	ErrConnClosed  = errors.New("http2: connection closed")
+)
+
+type stream struct {
+	id uint32
+	sc *ServerConn
+
+	// owned by the reading goroutine until dispatch
+	req        *request.Request
+	body       []byte
+	recvClosed bool
+	recvWindow int64
+
+	// guarded by sc.mu
+	sendWindow int64
+	reset      bool
+	dispatched bool
+	closed     bool
+	// cancels the request context, set on dispatch
+	cancel context.CancelCauseFunc
+
+	// owned by the handler goroutine
+	isHead       bool
+	head         bytes.Buffer
+	headDone     bool
+	ended        bool
+	suppressBody bool
+	chunked      *chunkedDecoder
+}
+
+// streamConn is the net.Conn handed to response.Writer for a stream,
+// it turns the HTTP/1.1 response the writer serializes into frames
+type streamConn struct {
+	st *stream
+}
+
+func (c *streamConn) Write(p []byte) (int, error) {
+	st := c.st
+	if st.headDone {
+		err := st.writeData(p)
+		if err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	st.head.Write(p)
+	for !st.headDone {
+		buf := st.head.Bytes()
+		end := bytes.Index(buf, []byte("\r\n\r\n"))
+		if end < 0 {
+			return len(p), nil
+		}
+
+		head := string(buf[:end])
+		rest := append([]byte{}, buf[end+4:]...)
+		st.head.Reset()
+
+		final, err := st.writeHead(head)
+		if err != nil {
+			return 0, err
+		}
+
+		if !final {
+			// an informational response, the final one follows
+			st.head.Write(rest)
+			continue
+		}
+
+		st.headDone = true
+		err = st.writeData(rest)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (c *streamConn) Read(p []byte) (int, error) {
+	/*
+	* the request body is fully read before the handler runs,
+	* reads block until the stream goes away so handlers can
+	* use them to notice a client that is gone
+	*/
+	sc := c.st.sc
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for !sc.closed && !c.st.reset && !c.st.closed {
+		sc.cond.Wait()
+	}
+
+	return 0, io.EOF
+}
+
+func (c *streamConn) Close() error {
+	// the stream ends when the handler returns
+	return nil
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return c.st.sc.conn.LocalAddr()
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.st.sc.conn.RemoteAddr()
+}
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *streamConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (st *stream) writeHead(head string) (bool, error) {
+	/*
+	* @brief: sends the status line and headers written by
+	* response.Writer as a HEADERS frame
+	*
+	* returns false for informational (1xx) responses
+	*/
+	lines := strings.Split(head, "\r\n")
+	statusParts := strings.SplitN(lines[0], " ", 3)
+	if len(statusParts) < 2 {
+		return false, fmt.Errorf("http2: malformed status line %q", lines[0])
+	}
+
+	code, err := strconv.Atoi(statusParts[1])
+	if err != nil || code < 100 || code > 999 {
+		return false, fmt.Errorf("http2: malformed status line %q", lines[0])
+	}
+
+	fields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(code)}}
+	contentLength := -1
+	for _, line := range lines[1:] {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "transfer-encoding":
+			// HTTP/2 has its own framing, chunks are unwrapped
+			if strings.Contains(strings.ToLower(value), "chunked") {
+				st.chunked = &chunkedDecoder{}
+			}
+			continue
+		case "connection", "keep-alive", "proxy-connection", "upgrade":
+			// meaningless in HTTP/2
+			continue
+		case "content-length":
+			contentLength, _ = strconv.Atoi(value)
+		}
+
+		fields = append(fields, hpack.HeaderField{Name: key, Value: value})
+	}
+
+	if st.isReset() {
+		return false, errStreamClosed
+	}
+
+	if code < 200 {
+		return false, st.sc.writeHeaders(st.id, fields, false)
+	}
+
+	endStream := st.isHead || code == 204 || code == 304 || contentLength == 0
+	err = st.sc.writeHeaders(st.id, fields, endStream)
+	if err != nil {
+		return false, err
+	}
+
+	if endStream {
+		st.ended = true
+		st.suppressBody = true
+	}
+
+	return true, nil
+}
+
+func (st *stream) writeData(p []byte) error {
+	if st.suppressBody {
+		return nil
+	}
+
+	if st.ended {
+		return errStreamClosed
+	}
+
+	if st.chunked != nil {
+		var err error
+		p, err = st.chunked.decode(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	for len(p) > 0 {
+		n, err := st.reserve(len(p))
+		if err != nil {
+			return err
+		}
+
+		err = st.sc.writeFrame(&Frame{Type: FrameData, StreamID: st.id, Payload: p[:n]})
+		if err != nil {
+			return err
+		}
+
+		p = p[n:]
+	}
+
+	return nil
+}
+
+func (st *stream) reserve(want int) (int, error) {
+	/*
+	* blocks until the flow control windows allow sending
+	* some data, returns how many bytes may be sent
+	*/
+	sc := st.sc
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for {
+		if sc.closed || st.reset {
+			return 0, errStreamClosed
+		}
+
+		n := min(int64(want), sc.connSendWindow, st.sendWindow, int64(sc.peerMaxFrameSize))
+		if n > 0 {
+			sc.connSendWindow -= n
+			st.sendWindow -= n
+			return int(n), nil
+		}
+
+		sc.cond.Wait()
+	}
+}
+
+func (st *stream) finish() {
+	/*
+	* ends the stream once the handler returns, a handler that
+	* wrote no response gets the stream reset
+	*/
+	defer st.sc.closeStream(st)
+
+	if st.isReset() {
+		return
+	}
+
+	if !st.headDone {
+		st.sc.resetStream(streamError{st.id, ErrCodeInternal, "handler wrote no response"})
+		return
+	}
+
+	if !st.ended {
+		st.ended = true
+		st.sc.writeFrame(&Frame{Type: FrameData, Flags: FlagEndStream, StreamID: st.id})
+	}
+}
+
+// chunkedDecoder strips the chunked transfer coding from a body that
+// may arrive split at any point
+type chunkedDecoder struct {
+	pending   []byte
+	remaining int
+	// the CRLF after a chunk's data is still expected
+	dataEnd bool
+	// the zero sized last chunk was seen, only trailers follow
+	last bool
+}
+
+func (d *chunkedDecoder) decode(p []byte) ([]byte, error) {
+	/*
+	* returns the body bytes carried by p, incomplete size
+	* lines are kept until the next write
+	*/
+	d.pending = append(d.pending, p...)
+	var out []byte
+
+	for len(d.pending) > 0 {
+		if d.remaining > 0 {
+			n := min(d.remaining, len(d.pending))
+			out = append(out, d.pending[:n]...)
+			d.pending = d.pending[n:]
+			d.remaining -= n
+			continue
+		}
+
+		end := bytes.Index(d.pending, []byte("\r\n"))
+		if end < 0 {
+			break
+		}
+		line := string(d.pending[:end])
+		d.pending = d.pending[end+2:]
+
+		if d.last {
+			// trailers are dropped
+			continue
+		}
+
+		if d.dataEnd {
+			if line != "" {
+				return nil, fmt.Errorf("http2: malformed chunk")
+			}
+			d.dataEnd = false
+			continue
+		}
+
+		sizeField, _, _ := strings.Cut(line, ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("http2: malformed chunk size %q", line)
+		}
+
+		if size == 0 {
+			d.last = true
+			continue
+		}
+
+		d.remaining = int(size)
+		d.dataEnd = true
+	}
+
+	return out, nil
+}
+
+func (st *stream) isReset() bool {
+	st.sc.mu.Lock()
+	defer st.sc.mu.Unlock()
+
+	return st.reset
+}
@@ -0,0 +1,254 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"Servus/internal/client"
+)
+
+const (
+	DefaultJWKSRefresh    = time.Hour
+	DefaultJWKSMinRefresh = time.Minute
+	DefaultJWKSTimeout    = 5 * time.Second
+)
+
+// keySet caches the keys of a JWKS. Keys are fetched again when the
+// cache is stale or a token names an unknown 'kid', which is how
+// issuers rotate keys
+type keySet struct {
+	url        string
+	refresh    time.Duration
+	minRefresh time.Duration
+	client     *client.Client
+
+	mu      sync.RWMutex
+	keys    map[string]jwk
+	fetched time.Time
+
+	// one fetch at a time, the others wait for its keys
+	fetchMu   sync.Mutex
+	attempted time.Time
+}
+
+type jwk struct {
+	key crypto.PublicKey
+	// 'alg' of the key, any algorithm of its type if empty
+	alg string
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func newKeySet(opts Options) (*keySet, error) {
+	if _, err := client.NewRequest("GET", opts.JWKSURL, nil); err != nil {
+		return nil, fmt.Errorf("jwks url: %w", err)
+	}
+
+	s := &keySet{
+		url:        opts.JWKSURL,
+		refresh:    opts.JWKSRefresh,
+		minRefresh: opts.JWKSMinRefresh,
+		keys:       map[string]jwk{},
+	}
+	if s.refresh <= 0 {
+		s.refresh = DefaultJWKSRefresh
+	}
+	if s.minRefresh <= 0 {
+		s.minRefresh = DefaultJWKSMinRefresh
+	}
+
+	timeout := opts.JWKSTimeout
+	if timeout <= 0 {
+		timeout = DefaultJWKSTimeout
+	}
+	s.client = client.New(client.Options{
+		DialTimeout:           timeout,
+		ResponseHeaderTimeout: timeout,
+		ReadTimeout:           timeout,
+	})
+
+	return s, nil
+}
+
+func (s *keySet) key(kid, alg string) (crypto.PublicKey, error) {
+	/*
+	* @brief: returns the key for kid, fetching the set when it
+	* is stale or does not know kid. a stale key is still used
+	* when the fetch fails
+	*/
+	key, ok, stale := s.lookup(kid)
+	if !ok || stale {
+		err := s.fetch()
+		if err != nil && !ok {
+			return nil, err
+		}
+		if err == nil {
+			key, ok, _ = s.lookup(kid)
+		}
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+	if key.alg != "" && key.alg != alg {
+		return nil, fmt.Errorf("%w: key %q is for %s", ErrAlgorithm, kid, key.alg)
+	}
+
+	return key.key, nil
+}
+
+func (s *keySet) lookup(kid string) (jwk, bool, bool) {
+	/*
+	* tokens without 'kid' are accepted when the set has a
+	* single key
+	*/
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stale := s.fetched.IsZero() || time.Since(s.fetched) > s.refresh
+
+	key, ok := s.keys[kid]
+	if !ok && kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			key, ok = k, true
+		}
+	}
+
+	return key, ok, stale
+}
+
+func (s *keySet) fetch() error {
+	/*
+	* fetches are spaced by minRefresh so tokens with made up
+	* 'kid's cannot hammer the issuer, and a failing issuer is
+	* not asked on every request. those waiting on a fetch use
+	* its keys
+	*/
+	s.fetchMu.Lock()
+	defer s.fetchMu.Unlock()
+
+	if time.Since(s.attempted) < s.minRefresh {
+		return nil
+	}
+	s.attempted = time.Now()
+
+	keys, err := s.download()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.fetched = time.Now()
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *keySet) download() (map[string]jwk, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, fmt.Errorf("fetching jwks: %w", err)
+	}
+	body, err := resp.ReadBody()
+	if err != nil {
+		return nil, fmt.Errorf("fetching jwks: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("fetching jwks: status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("decoding jwks: %w", err)
+	}
+
+	// keys of unsupported types are skipped, the set may hold
+	// some for other consumers
+	keys := map[string]jwk{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = jwk{key: key, alg: k.Alg}
+	}
+
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !key.Curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on the curve")
+		}
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func (s *keySet) close() {
+	s.client.Close()
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,331 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"Servus/internal/auth"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+const (
+	// clock difference tolerated with the issuer
+	DefaultLeeway = 30 * time.Second
+
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var (
+	ErrMalformed    = errors.New("jwt: malformed token")
+	ErrAlgorithm    = errors.New("jwt: algorithm not accepted")
+	ErrUnknownKey   = errors.New("jwt: unknown signing key")
+	ErrSignature    = errors.New("jwt: invalid signature")
+	ErrExpired      = errors.New("jwt: token expired")
+	ErrNotYetValid  = errors.New("jwt: token not valid yet")
+	ErrIssuer       = errors.New("jwt: unexpected issuer")
+	ErrAudience     = errors.New("jwt: unexpected audience")
+	ErrInvalidClaim = errors.New("jwt: invalid claim")
+)
+
+// Claims are the verified claims of a token, numbers are float64
+// as decoded by encoding/json
+type Claims map[string]any
+
+type Options struct {
+	// key of HS256 tokens, they are refused when empty
+	Secret []byte
+	// url of the JWKS holding the RS256 and ES256 keys
+	JWKSURL string
+	// time the keys are cached, DefaultJWKSRefresh if zero
+	JWKSRefresh time.Duration
+	// least time between fetches caused by an unknown 'kid',
+	// DefaultJWKSMinRefresh if zero
+	JWKSMinRefresh time.Duration
+	// timeout of a fetch, DefaultJWKSTimeout if zero
+	JWKSTimeout time.Duration
+	// required 'iss' when not empty
+	Issuer string
+	// required among 'aud' when not empty
+	Audience string
+	// DefaultLeeway if zero, none if negative
+	Leeway time.Duration
+	// realm of the 'WWW-Authenticate' challenge
+	Realm string
+}
+
+// Verifier checks the signature and the registered claims of
+// tokens. It is safe for concurrent use
+type Verifier struct {
+	opts Options
+	keys *keySet
+	now  func() time.Time
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func NewVerifier(opts Options) (*Verifier, error) {
+	/*
+	* @brief: creates a verifier for the algorithms the options
+	* provide keys for, HS256 with Secret and RS256 and ES256
+	* with JWKSURL. the keys are fetched on first use
+	*/
+	if len(opts.Secret) == 0 && opts.JWKSURL == "" {
+		return nil, errors.New("jwt: either a secret or a JWKS url is needed")
+	}
+	if opts.Leeway == 0 {
+		opts.Leeway = DefaultLeeway
+	}
+	if opts.Leeway < 0 {
+		opts.Leeway = 0
+	}
+
+	v := &Verifier{opts: opts, now: time.Now}
+	if opts.JWKSURL != "" {
+		keys, err := newKeySet(opts)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = keys
+	}
+
+	return v, nil
+}
+
+func (v *Verifier) Verify(token string) (Claims, error) {
+	/*
+	* @brief: returns the claims of token once its signature,
+	* 'exp', 'nbf', 'iss' and 'aud' are checked. 'exp' and 'nbf'
+	* are optional, 'iss' and 'aud' required when configured
+	*/
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrMalformed, err)
+	}
+
+	if err := v.verifySignature(h, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := v.validate(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (v *Verifier) Validate(token string) (*auth.Principal, error) {
+	/*
+	* @brief: verifies token for auth.NewBearer, the principal
+	* is the subject and carries the claims as attributes
+	*/
+	claims, err := v.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.Principal{ID: claims.Subject(), Attributes: claims}, nil
+}
+
+func (v *Verifier) Middleware() response.Middleware {
+	/*
+	* @brief: requires a valid bearer token, handlers get the
+	* claims with FromRequest
+	*/
+	return auth.Middleware(auth.Options{
+		Authenticators: []auth.Authenticator{auth.NewBearer(v.opts.Realm, v.Validate)},
+	})
+}
+
+func (v *Verifier) Close() {
+	if v.keys != nil {
+		v.keys.close()
+	}
+}
+
+func FromRequest(req *request.Request) Claims {
+	/*
+	* returns the claims verified by Middleware, nil if the
+	* request was not authenticated with a token
+	*/
+	p := auth.FromRequest(req)
+	if p == nil || p.Scheme != "bearer" {
+		return nil
+	}
+
+	return Claims(p.Attributes)
+}
+
+func (c Claims) Subject() string {
+	s, _ := c["sub"].(string)
+
+	return s
+}
+
+func (c Claims) Issuer() string {
+	s, _ := c["iss"].(string)
+
+	return s
+}
+
+func (c Claims) Audience() []string {
+	/*
+	* 'aud' is either a single string or an array of them
+	*/
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []any:
+		audience := make([]string, 0, len(aud))
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audience = append(audience, s)
+			}
+		}
+		return audience
+	}
+
+	return nil
+}
+
+func (c Claims) Time(name string) (time.Time, bool, error) {
+	/*
+	* @brief: reads a NumericDate claim such as 'exp', false if
+	* it is absent
+	*/
+	value, ok := c[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	seconds, ok := value.(float64)
+	if !ok || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrInvalidClaim, name)
+	}
+	whole, frac := math.Modf(seconds)
+
+	return time.Unix(int64(whole), int64(frac*1e9)), true, nil
+}
+
+func (v *Verifier) verifySignature(h header, signed string, signature []byte) error {
+	/*
+	* the key is picked by the algorithm of the header but must
+	* be of its type, so an RSA public key is never taken as an
+	* HMAC secret
+	*/
+	switch h.Alg {
+	case HS256:
+		if len(v.opts.Secret) == 0 {
+			return fmt.Errorf("%w: %s", ErrAlgorithm, h.Alg)
+		}
+		mac := hmac.New(sha256.New, v.opts.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrSignature
+		}
+		return nil
+
+	case RS256, ES256:
+		if v.keys == nil {
+			return fmt.Errorf("%w: %s", ErrAlgorithm, h.Alg)
+		}
+		key, err := v.keys.key(h.Kid, h.Alg)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256([]byte(signed))
+
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			if h.Alg != RS256 || rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+				return ErrSignature
+			}
+			return nil
+		case *ecdsa.PublicKey:
+			// r and s are concatenated, 32 bytes each for P-256
+			if h.Alg != ES256 || len(signature) != 64 {
+				return ErrSignature
+			}
+			r := new(big.Int).SetBytes(signature[:32])
+			s := new(big.Int).SetBytes(signature[32:])
+			if !ecdsa.Verify(key, digest[:], r, s) {
+				return ErrSignature
+			}
+			return nil
+		}
+		return ErrSignature
+	}
+
+	return fmt.Errorf("%w: %q", ErrAlgorithm, h.Alg)
+}
+
+func (v *Verifier) validate(claims Claims) error {
+	now := v.now()
+
+	exp, ok, err := claims.Time("exp")
+	if err != nil {
+		return err
+	}
+	if ok && !now.Before(exp.Add(v.opts.Leeway)) {
+		return ErrExpired
+	}
+
+	nbf, ok, err := claims.Time("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.opts.Leeway).Before(nbf) {
+		return ErrNotYetValid
+	}
+
+	if v.opts.Issuer != "" && claims.Issuer() != v.opts.Issuer {
+		return ErrIssuer
+	}
+	if v.opts.Audience != "" && !slices.Contains(claims.Audience(), v.opts.Audience) {
+		return ErrAudience
+	}
+
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,276 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+	"Servus/internal/server"
+)
+
+// jwksServer stands in for an identity provider
+type jwksServer struct {
+	mu      sync.Mutex
+	keys    []map[string]string
+	fetches atomic.Int32
+}
+
+func (j *jwksServer) set(keys ...map[string]string) {
+	j.mu.Lock()
+	j.keys = keys
+	j.mu.Unlock()
+}
+
+func (j *jwksServer) serve(t *testing.T) string {
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		j.fetches.Add(1)
+		j.mu.Lock()
+		body, _ := json.Marshal(map[string]any{"keys": j.keys})
+		j.mu.Unlock()
+
+		h := headers.GetDefaultHeaders(len(body))
+		h.AddOverride("Content-Type", "application/json")
+		w.Response = &response.Response{Code: response.CodeOK, Message: body, Headers: h}
+		w.WriteResponse()
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	return "http://" + s.Addr().String() + "/jwks.json"
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA", "kid": kid, "use": "sig", "alg": RS256,
+		"n": encode(key.N.Bytes()),
+		"e": encode(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": encode(key.X.FillBytes(make([]byte, 32))),
+		"y": encode(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func sign(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signed := encode(h) + "." + encode(c)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		require.NoError(t, err)
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+
+	return signed + "." + encode(signature)
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	secret := []byte("shared secret")
+
+	jwks := &jwksServer{}
+	jwks.set(rsaJWK("r1", rsaKey), ecJWK("e1", ecKey))
+
+	v, err := NewVerifier(Options{
+		Secret:   secret,
+		JWKSURL:  jwks.serve(t),
+		Issuer:   "https://id.example",
+		Audience: "servus",
+		Leeway:   time.Minute,
+	})
+	require.NoError(t, err)
+	defer v.Close()
+	now := time.Unix(1_700_000_000, 0)
+	v.now = func() time.Time { return now }
+
+	claims := map[string]any{
+		"sub": "alice",
+		"iss": "https://id.example",
+		"aud": []string{"other", "servus"},
+		"exp": now.Add(time.Hour).Unix(),
+		"nbf": now.Unix(),
+	}
+	with := func(name string, value any) map[string]any {
+		c := map[string]any{}
+		for k, v := range claims {
+			c[k] = v
+		}
+		if value == nil {
+			delete(c, name)
+		} else {
+			c[name] = value
+		}
+		return c
+	}
+
+	// test: every algorithm verifies
+	for _, token := range []string{
+		sign(t, HS256, "", secret, claims),
+		sign(t, RS256, "r1", rsaKey, claims),
+		sign(t, ES256, "e1", ecKey, claims),
+	} {
+		c, err := v.Verify(token)
+		require.NoError(t, err)
+		assert.Equal(t, "alice", c.Subject())
+		assert.Equal(t, []string{"other", "servus"}, c.Audience())
+	}
+
+	// test: the registered claims are checked with the leeway
+	_, err = v.Verify(sign(t, HS256, "", secret, with("exp", now.Add(-30*time.Second).Unix())))
+	assert.NoError(t, err)
+	_, err = v.Verify(sign(t, HS256, "", secret, with("exp", now.Add(-2*time.Minute).Unix())))
+	assert.ErrorIs(t, err, ErrExpired)
+	_, err = v.Verify(sign(t, HS256, "", secret, with("nbf", now.Add(2*time.Minute).Unix())))
+	assert.ErrorIs(t, err, ErrNotYetValid)
+	_, err = v.Verify(sign(t, HS256, "", secret, with("iss", "https://evil.example")))
+	assert.ErrorIs(t, err, ErrIssuer)
+	_, err = v.Verify(sign(t, HS256, "", secret, with("aud", "other")))
+	assert.ErrorIs(t, err, ErrAudience)
+	_, err = v.Verify(sign(t, HS256, "", secret, with("exp", "tomorrow")))
+	assert.ErrorIs(t, err, ErrInvalidClaim)
+	_, err = v.Verify(sign(t, HS256, "", secret, with("exp", nil)))
+	assert.NoError(t, err)
+
+	// test: signatures must match the key and its type
+	_, err = v.Verify(sign(t, HS256, "", []byte("guess"), claims))
+	assert.ErrorIs(t, err, ErrSignature)
+	_, err = v.Verify(sign(t, ES256, "r1", ecKey, claims))
+	assert.ErrorIs(t, err, ErrAlgorithm)
+	_, err = v.Verify(sign(t, "none", "", nil, claims))
+	assert.ErrorIs(t, err, ErrAlgorithm)
+	token := sign(t, RS256, "r1", rsaKey, claims)
+	_, err = v.Verify(token[:len(token)-4] + "AAAA")
+	assert.ErrorIs(t, err, ErrSignature)
+	_, err = v.Verify("not.a-token")
+	assert.ErrorIs(t, err, ErrMalformed)
+
+	_, err = NewVerifier(Options{})
+	assert.Error(t, err)
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	jwks := &jwksServer{}
+	jwks.set(ecJWK("k1", oldKey))
+	url := jwks.serve(t)
+	claims := map[string]any{"sub": "alice"}
+
+	v, err := NewVerifier(Options{JWKSURL: url, JWKSMinRefresh: 100 * time.Millisecond})
+	require.NoError(t, err)
+	defer v.Close()
+
+	// test: the keys are fetched once and cached
+	for i := 0; i < 3; i++ {
+		_, err = v.Verify(sign(t, ES256, "k1", oldKey, claims))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), jwks.fetches.Load())
+
+	// test: tokens without 'kid' use the only key
+	_, err = v.Verify(sign(t, ES256, "", oldKey, claims))
+	assert.NoError(t, err)
+
+	// test: an unknown 'kid' fetches the rotated set, but not
+	// again before the minimum interval
+	jwks.set(ecJWK("k2", newKey))
+	time.Sleep(150 * time.Millisecond)
+	_, err = v.Verify(sign(t, ES256, "k2", newKey, claims))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), jwks.fetches.Load())
+
+	_, err = v.Verify(sign(t, ES256, "k3", newKey, claims))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	_, err = v.Verify(sign(t, ES256, "k1", oldKey, claims))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, int32(2), jwks.fetches.Load())
+}
+
+func TestMiddleware(t *testing.T) {
+	secret := []byte("shared secret")
+	v, err := NewVerifier(Options{Secret: secret, Realm: "api"})
+	require.NoError(t, err)
+
+	s, err := server.Serve(0, response.Chain(func(w *response.Writer, req *request.Request) {
+		claims := FromRequest(req)
+		body := fmt.Sprintf("%s %v", claims.Subject(), claims["role"])
+		w.Response = &response.Response{
+			Code:    response.CodeOK,
+			Message: []byte(body),
+			Headers: headers.GetDefaultHeaders(len(body)),
+		}
+		w.WriteResponse()
+	}, v.Middleware()))
+	require.NoError(t, err)
+	defer s.Close()
+
+	send := func(token string) string {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer " + token + "\r\n\r\n"))
+		require.NoError(t, err)
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+
+		return string(resp)
+	}
+
+	// test: handlers see the verified claims
+	resp := send(sign(t, HS256, "", secret, map[string]any{"sub": "alice", "role": "admin"}))
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nalice admin"), resp)
+
+	// test: bad tokens get a 401 with an invalid_token challenge
+	resp = send(sign(t, HS256, "", secret, map[string]any{"sub": "alice", "exp": 1}))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 401 Unauthorized\r\n"), resp)
+	assert.Contains(t, resp, `www-authenticate: Bearer realm="api", error="invalid_token"`+"\r\n")
+}
@@ -0,0 +1,233 @@
+package metrics
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+const (
+	DefaultPath      = "/metrics"
+	DefaultMaxRoutes = 64
+
+	// route label of the requests of no declared route, or
+	// past MaxRoutes
+	otherRoute = "other"
+)
+
+type HTTPOptions struct {
+	// where Middleware serves the registry, DefaultPath when empty
+	Path string
+	// route label of a request, the first path segment when nil.
+	// it must not return values chosen by clients
+	Route func(req *request.Request) string
+	// route labels the application serves, the requests labeled
+	// otherwise are counted as 'other'. required when Route is
+	// nil, since path segments are chosen by clients: without it
+	// every request is counted as 'other'
+	Routes []string
+	// distinct routes tracked when Routes is empty, later ones
+	// are counted as 'other' so the series cannot grow without
+	// bound
+	MaxRoutes int
+}
+
+// HTTPMetrics is the set of metrics of a server, the request ones
+// are recorded by Middleware and the connection ones by the server
+// when set in server.Config
+type HTTPMetrics struct {
+	registry *Registry
+	path     string
+	route    func(req *request.Request) string
+
+	// only the routes stored at creation are counted
+	declared   bool
+	maxRoutes  int
+	routesMu   sync.Mutex
+	routes     sync.Map
+	routeCount int
+
+	requests        *CounterVec
+	duration        *HistogramVec
+	requestSize     *HistogramVec
+	responseSize    *HistogramVec
+	inFlight        *Gauge
+	connsActive     *Gauge
+	connsTotal      *Counter
+	connReuses      *Counter
+	connsRejected   *Counter
+	parseErrors     *CounterVec
+	queueWait       *Histogram
+	queueRejected   *Counter
+	panicsRecovered *Counter
+}
+
+func NewHTTPMetrics(registry *Registry, opts HTTPOptions) *HTTPMetrics {
+	m := &HTTPMetrics{
+		registry:  registry,
+		path:      opts.Path,
+		route:     opts.Route,
+		maxRoutes: opts.MaxRoutes,
+	}
+
+	if m.path == "" {
+		m.path = DefaultPath
+	}
+	if m.route == nil {
+		m.route = firstSegment
+		m.declared = true
+	}
+	if m.maxRoutes <= 0 {
+		m.maxRoutes = DefaultMaxRoutes
+	}
+	for _, route := range opts.Routes {
+		m.routes.Store(route, struct{}{})
+		m.declared = true
+	}
+
+	m.requests = registry.NewCounterVec("servus_http_requests_total",
+		"Requests served.", "method", "route", "status_class")
+	m.duration = registry.NewHistogramVec("servus_http_request_duration_seconds",
+		"Time spent handling requests.", DefaultDurationBuckets, "method", "route")
+	m.requestSize = registry.NewHistogramVec("servus_http_request_size_bytes",
+		"Size of request bodies.", DefaultSizeBuckets, "method", "route")
+	m.responseSize = registry.NewHistogramVec("servus_http_response_size_bytes",
+		"Size of response bodies.", DefaultSizeBuckets, "method", "route")
+	m.inFlight = registry.NewGauge("servus_http_requests_in_flight",
+		"Requests being handled.")
+	m.connsActive = registry.NewGauge("servus_connections_active",
+		"Open client connections.")
+	m.connsTotal = registry.NewCounter("servus_connections_total",
+		"Client connections accepted.")
+	m.connReuses = registry.NewCounter("servus_connection_reuses_total",
+		"Requests served on a connection that already served one.")
+	m.connsRejected = registry.NewCounter("servus_connections_rejected_total",
+		"Connections refused for being over the connection limits.")
+	m.parseErrors = registry.NewCounterVec("servus_parse_errors_total",
+		"Requests that could not be read, by kind.", "kind")
+	m.queueWait = registry.NewHistogram("servus_worker_queue_wait_seconds",
+		"Time requests waited for a worker.", DefaultDurationBuckets)
+	m.queueRejected = registry.NewCounter("servus_worker_queue_rejected_total",
+		"Requests refused for finding the worker queue full.")
+	m.panicsRecovered = registry.NewCounter("servus_panics_recovered_total",
+		"Handler panics recovered by the server.")
+
+	return m
+}
+
+func (m *HTTPMetrics) Middleware(next response.Handler) response.Handler {
+	/*
+	* @brief: records the request metrics and serves the registry
+	* on the metrics path
+	*/
+	return func(w *response.Writer, req *request.Request) {
+		if req.Path() == m.path {
+			m.registry.Handler(w, req)
+			return
+		}
+
+		method := req.RequestLine.Method
+		route := m.routeOf(req)
+		start := time.Now()
+		m.inFlight.Inc()
+
+		defer func() {
+			m.inFlight.Dec()
+
+			status := int(w.StatusCode())
+			panicking := recover()
+			if panicking != nil && status == 0 {
+				// the server answers a panic with a 500
+				status = 500
+			}
+
+			m.requests.With(method, route, statusClass(status)).Inc()
+			m.duration.With(method, route).Observe(time.Since(start).Seconds())
+			m.requestSize.With(method, route).Observe(float64(len(req.Body)))
+			m.responseSize.With(method, route).Observe(float64(w.BytesWritten()))
+
+			if panicking != nil {
+				panic(panicking)
+			}
+		}()
+
+		next(w, req)
+	}
+}
+
+func (m *HTTPMetrics) ConnOpened() {
+	m.connsActive.Inc()
+	m.connsTotal.Inc()
+}
+
+func (m *HTTPMetrics) ConnClosed() {
+	m.connsActive.Dec()
+}
+
+func (m *HTTPMetrics) ConnReused() {
+	m.connReuses.Inc()
+}
+
+func (m *HTTPMetrics) ConnRejected() {
+	m.connsRejected.Inc()
+}
+
+func (m *HTTPMetrics) ParseError(kind string) {
+	m.parseErrors.With(kind).Inc()
+}
+
+func (m *HTTPMetrics) QueueWait(d time.Duration) {
+	m.queueWait.Observe(d.Seconds())
+}
+
+func (m *HTTPMetrics) QueueRejected() {
+	m.queueRejected.Inc()
+}
+
+func (m *HTTPMetrics) PanicRecovered() {
+	m.panicsRecovered.Inc()
+}
+
+func (m *HTTPMetrics) routeOf(req *request.Request) string {
+	route := m.route(req)
+	if _, ok := m.routes.Load(route); ok {
+		return route
+	}
+	if m.declared {
+		return otherRoute
+	}
+
+	m.routesMu.Lock()
+	defer m.routesMu.Unlock()
+
+	if _, ok := m.routes.Load(route); ok {
+		return route
+	}
+
+	if m.routeCount >= m.maxRoutes {
+		return otherRoute
+	}
+	m.routes.Store(route, struct{}{})
+	m.routeCount++
+
+	return route
+}
+
+func firstSegment(req *request.Request) string {
+	segment := strings.TrimPrefix(req.Path(), "/")
+	segment, _, _ = strings.Cut(segment, "/")
+
+	return "/" + segment
+}
+
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+
+	return strconv.Itoa(status/100) + "xx"
+}
@@ -0,0 +1,400 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	// Prometheus' default buckets, in seconds
+	DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// 100 bytes to 100 MB
+	DefaultSizeBuckets = []float64{100, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8}
+)
+
+// Registry holds the metrics exposed together. Metrics are updated
+// with atomic operations, locks are only taken to create a labeled
+// series or to scrape
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]collector
+}
+
+type collector interface {
+	write(w *bufio.Writer, name string)
+	kind() string
+	help() string
+}
+
+type Counter struct {
+	value atomic.Uint64
+	about string
+}
+
+type Gauge struct {
+	value atomic.Int64
+	about string
+}
+
+type Histogram struct {
+	buckets []float64
+	// per bucket, not cumulative, the last one is +Inf
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	// float64 bits
+	sum   atomic.Uint64
+	about string
+}
+
+// vec holds the series of a metric by label values
+type vec[T any] struct {
+	labels []string
+	series sync.Map
+	create func() T
+	about  string
+}
+
+type CounterVec struct {
+	vec[*Counter]
+}
+
+type HistogramVec struct {
+	vec[*Histogram]
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]collector{}}
+}
+
+func (r *Registry) register(name string, c collector) {
+	/*
+	* names are fixed by the code, a clash is a programming error
+	*/
+	if !validName(name) {
+		panic(fmt.Sprintf("metrics: invalid name %q", name))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.metrics[name]; ok {
+		panic(fmt.Sprintf("metrics: %q registered twice", name))
+	}
+	r.metrics[name] = c
+}
+
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{about: help}
+	r.register(name, c)
+
+	return c
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{about: help}
+	r.register(name, g)
+
+	return g
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := newHistogram(help, buckets)
+	r.register(name, h)
+
+	return h
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{vec[*Counter]{
+		labels: labels,
+		create: func() *Counter { return &Counter{} },
+		about:  help,
+	}}
+	r.register(name, v)
+
+	return v
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	v := &HistogramVec{vec[*Histogram]{
+		labels: labels,
+		create: func() *Histogram { return newHistogram("", buckets) },
+		about:  help,
+	}}
+	r.register(name, v)
+
+	return v
+}
+
+func newHistogram(help string, buckets []float64) *Histogram {
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]atomic.Uint64, len(buckets)+1),
+		about:   help,
+	}
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+func (g *Gauge) Inc() {
+	g.value.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.value.Add(-1)
+}
+
+func (g *Gauge) Set(n int64) {
+	g.value.Store(n)
+}
+
+func (g *Gauge) Value() int64 {
+	return g.value.Load()
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.counts[i].Add(1)
+	h.count.Add(1)
+
+	for {
+		old := h.sum.Load()
+		sum := math.Float64bits(math.Float64frombits(old) + v)
+		if h.sum.CompareAndSwap(old, sum) {
+			return
+		}
+	}
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.count.Load()
+}
+
+func (v *vec[T]) With(values ...string) T {
+	/*
+	* @brief: returns the series for the label values, given in
+	* the order the labels were declared
+	*/
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %d label values for %d labels", len(values), len(v.labels)))
+	}
+
+	key := strings.Join(values, "\xff")
+	if s, ok := v.series.Load(key); ok {
+		return s.(T)
+	}
+
+	s, _ := v.series.LoadOrStore(key, v.create())
+
+	return s.(T)
+}
+
+func (r *Registry) WriteTo(out io.Writer) (int64, error) {
+	/*
+	* @brief: writes every metric in the Prometheus text
+	* exposition format, sorted by name
+	*/
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	collectors := make(map[string]collector, len(r.metrics))
+	for name, c := range r.metrics {
+		names = append(names, name)
+		collectors[name] = c
+	}
+	r.mu.Unlock()
+
+	sort.Strings(names)
+
+	cw := &countingWriter{w: out}
+	w := bufio.NewWriter(cw)
+	for _, name := range names {
+		c := collectors[name]
+		if c.help() != "" {
+			fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(c.help()))
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, c.kind())
+		c.write(w, name)
+	}
+	err := w.Flush()
+
+	return cw.n, err
+}
+
+func (r *Registry) Handler(w *response.Writer, req *request.Request) {
+	/*
+	* @brief: serves the metrics, it is a response.Handler
+	*/
+	var b strings.Builder
+	r.WriteTo(&b)
+
+	h := headers.GetDefaultHeaders(b.Len())
+	h.AddOverride("Content-Type", contentType)
+	w.Response = &response.Response{
+		Code:    response.CodeOK,
+		Message: []byte(b.String()),
+		Headers: h,
+	}
+	w.WriteResponse()
+}
+
+func (c *Counter) kind() string { return "counter" }
+func (c *Counter) help() string { return c.about }
+func (c *Counter) write(w *bufio.Writer, name string) {
+	writeSample(w, name, "", float64(c.Value()))
+}
+
+func (g *Gauge) kind() string { return "gauge" }
+func (g *Gauge) help() string { return g.about }
+func (g *Gauge) write(w *bufio.Writer, name string) {
+	writeSample(w, name, "", float64(g.Value()))
+}
+
+func (h *Histogram) kind() string { return "histogram" }
+func (h *Histogram) help() string { return h.about }
+func (h *Histogram) write(w *bufio.Writer, name string) {
+	h.writeLabeled(w, name, "")
+}
+
+func (h *Histogram) writeLabeled(w *bufio.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+
+	// buckets are cumulative in the exposition format
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i].Load()
+		le := `le="` + strconv.FormatFloat(bound, 'g', -1, 64) + `"`
+		writeSample(w, name+"_bucket", labels+sep+le, float64(cumulative))
+	}
+	cumulative += h.counts[len(h.buckets)].Load()
+	writeSample(w, name+"_bucket", labels+sep+`le="+Inf"`, float64(cumulative))
+
+	writeSample(w, name+"_sum", labels, math.Float64frombits(h.sum.Load()))
+	writeSample(w, name+"_count", labels, float64(cumulative))
+}
+
+func (v *CounterVec) kind() string { return "counter" }
+func (v *CounterVec) help() string { return v.about }
+func (v *CounterVec) write(w *bufio.Writer, name string) {
+	v.each(func(labels string, c *Counter) {
+		writeSample(w, name, labels, float64(c.Value()))
+	})
+}
+
+func (v *HistogramVec) kind() string { return "histogram" }
+func (v *HistogramVec) help() string { return v.about }
+func (v *HistogramVec) write(w *bufio.Writer, name string) {
+	v.each(func(labels string, h *Histogram) {
+		h.writeLabeled(w, name, labels)
+	})
+}
+
+func (v *vec[T]) each(fn func(labels string, series T)) {
+	/*
+	* calls fn for every series sorted by label values, with
+	* the labels formatted as 'name="value",...'
+	*/
+	var keys []string
+	v.series.Range(func(key, _ any) bool {
+		keys = append(keys, key.(string))
+		return true
+	})
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s, _ := v.series.Load(key)
+
+		values := strings.Split(key, "\xff")
+		pairs := make([]string, len(v.labels))
+		for i, label := range v.labels {
+			pairs[i] = label + `="` + escapeLabel(values[i]) + `"`
+		}
+
+		fn(strings.Join(pairs, ","), s.(T))
+	}
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatValue(value) + "\n")
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, ch := range name {
+		if !((ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_' || ch == ':' ||
+			(i > 0 && ch >= '0' && ch <= '9')) {
+
+			return false
+		}
+	}
+
+	return true
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
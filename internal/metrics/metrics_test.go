@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Servus/internal/request"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	var b strings.Builder
+	_, err := r.WriteTo(&b)
+	require.NoError(t, err)
+
+	return b.String()
+}
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("jobs_total", "Jobs run.")
+	g := r.NewGauge("queue_depth", "Jobs waiting.\nPer queue.")
+	h := r.NewHistogram("job_seconds", "", []float64{1, 0.1})
+	v := r.NewCounterVec("errors_total", "Errors.", "kind", "path")
+
+	c.Add(3)
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(7)
+	v.With("io", "/a").Inc()
+	v.With("io", "/a").Inc()
+	v.With("parse", "say \"hi\"\\\n").Inc()
+
+	// test: sorted metrics with help, type and samples
+	expected := `# HELP errors_total Errors.
+# TYPE errors_total counter
+errors_total{kind="io",path="/a"} 2
+errors_total{kind="parse",path="say \"hi\"\\\n"} 1
+# TYPE job_seconds histogram
+job_seconds_bucket{le="0.1"} 2
+job_seconds_bucket{le="1"} 3
+job_seconds_bucket{le="+Inf"} 4
+job_seconds_sum 7.65
+job_seconds_count 4
+# HELP jobs_total Jobs run.
+# TYPE jobs_total counter
+jobs_total 3
+# HELP queue_depth Jobs waiting.\nPer queue.
+# TYPE queue_depth gauge
+queue_depth 1
+`
+	assert.Equal(t, expected, scrape(t, r))
+
+	// test: programming errors
+	assert.Panics(t, func() { r.NewCounter("jobs_total", "") })
+	assert.Panics(t, func() { r.NewCounter("0jobs", "") })
+	assert.Panics(t, func() { v.With("io") })
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	r := NewRegistry()
+	v := r.NewHistogramVec("latency_seconds", "", DefaultDurationBuckets, "route")
+
+	// test: updates from many goroutines are not lost
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				v.With(fmt.Sprint("/", j%4)).Observe(0.25)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				scrape(t, r)
+			}
+		}
+	}()
+	wg.Wait()
+	close(done)
+
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, uint64(2000), v.With(fmt.Sprint("/", i)).Count())
+	}
+	assert.Contains(t, scrape(t, r), `latency_seconds_sum{route="/0"} 500`)
+}
+
+func TestRoutes(t *testing.T) {
+	req := func(target string) *request.Request {
+		return &request.Request{RequestLine: request.RequestLine{RequestTarget: target}}
+	}
+
+	// test: by default only declared first segments are counted
+	m := NewHTTPMetrics(NewRegistry(), HTTPOptions{Routes: []string{"/users"}})
+	assert.Equal(t, "/users", m.routeOf(req("/users/1")))
+	assert.Equal(t, otherRoute, m.routeOf(req("/junk")))
+
+	// test: nothing declared, nothing client-chosen in the labels
+	m = NewHTTPMetrics(NewRegistry(), HTTPOptions{})
+	assert.Equal(t, otherRoute, m.routeOf(req("/users/1")))
+
+	// test: the labels of Route are bounded by MaxRoutes
+	m = NewHTTPMetrics(NewRegistry(), HTTPOptions{
+		Route:     func(r *request.Request) string { return r.Path() },
+		MaxRoutes: 2,
+	})
+	assert.Equal(t, "/a", m.routeOf(req("/a")))
+	assert.Equal(t, "/b", m.routeOf(req("/b")))
+	assert.Equal(t, otherRoute, m.routeOf(req("/c")))
+	assert.Equal(t, "/a", m.routeOf(req("/a")))
+}
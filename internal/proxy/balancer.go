@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type Strategy int
+
+const (
+	RoundRobin Strategy = iota
+	LeastConnections
+	// smooth weighted round-robin, upstreams are picked in
+	// proportion to their weight without bursts
+	WeightedRoundRobin
+	// requests with the same key stick to the same upstream, the key
+	// is Options.HashHeader or the client IP when it is not set
+	ConsistentHash
+)
+
+// virtual nodes per unit of weight on the hash ring
+const ringReplicas = 100
+
+func (s Strategy) String() string {
+	switch s {
+	case RoundRobin:
+		return "round-robin"
+	case LeastConnections:
+		return "least-connections"
+	case WeightedRoundRobin:
+		return "weighted"
+	case ConsistentHash:
+		return "consistent-hash"
+	}
+
+	return fmt.Sprintf("strategy(%d)", int(s))
+}
+
+type ringNode struct {
+	hash     uint64
+	upstream *upstream
+}
+
+type balancer struct {
+	strategy  Strategy
+	upstreams []*upstream
+	ring      []ringNode
+
+	mu sync.Mutex
+	// round-robin position
+	next int
+	// per upstream running weight for WeightedRoundRobin
+	current map[*upstream]int
+}
+
+func newBalancer(strategy Strategy, upstreams []*upstream) (*balancer, error) {
+	if strategy < RoundRobin || strategy > ConsistentHash {
+		return nil, fmt.Errorf("unknown balancing strategy %d", int(strategy))
+	}
+
+	b := &balancer{
+		strategy:  strategy,
+		upstreams: upstreams,
+		current:   map[*upstream]int{},
+	}
+
+	if strategy == ConsistentHash {
+		for _, u := range upstreams {
+			for i := 0; i < ringReplicas*u.weight; i++ {
+				b.ring = append(b.ring, ringNode{hashKey(u.key() + "#" + strconv.Itoa(i)), u})
+			}
+		}
+		sort.Slice(b.ring, func(i, j int) bool { return b.ring[i].hash < b.ring[j].hash })
+	}
+
+	return b, nil
+}
+
+func (b *balancer) pick(key string, exclude []*upstream) *upstream {
+	/*
+	* @brief: returns the upstream for the next request, nil if
+	* none of them is available
+	*
+	* upstreams that are unhealthy or in exclude are skipped
+	*/
+	candidates := make([]*upstream, 0, len(b.upstreams))
+	for _, u := range b.upstreams {
+		if u.available() && !slices.Contains(exclude, u) {
+			candidates = append(candidates, u)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	switch b.strategy {
+	case LeastConnections:
+		return b.leastConnections(candidates)
+	case WeightedRoundRobin:
+		return b.weighted(candidates)
+	case ConsistentHash:
+		return b.hashed(key, candidates)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.next++
+
+	return candidates[b.next%len(candidates)]
+}
+
+func (b *balancer) leastConnections(candidates []*upstream) *upstream {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// ties go round-robin so idle upstreams share the load
+	b.next++
+	start := b.next % len(candidates)
+
+	var best *upstream
+	for i := range candidates {
+		u := candidates[(start+i)%len(candidates)]
+		if best == nil || u.inFlight.Load()*int64(best.weight) < best.inFlight.Load()*int64(u.weight) {
+			best = u
+		}
+	}
+
+	return best
+}
+
+func (b *balancer) weighted(candidates []*upstream) *upstream {
+	/*
+	* nginx's smooth weighted round-robin, each upstream gains its
+	* weight every round and the chosen one pays back the total
+	*/
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	total := 0
+	var best *upstream
+	for _, u := range candidates {
+		b.current[u] += u.weight
+		total += u.weight
+		if best == nil || b.current[u] > b.current[best] {
+			best = u
+		}
+	}
+	b.current[best] -= total
+
+	return best
+}
+
+func (b *balancer) hashed(key string, candidates []*upstream) *upstream {
+	/*
+	* walks the ring clockwise from the key, so only the keys of
+	* an unavailable upstream move elsewhere
+	*/
+	h := hashKey(key)
+	start := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= h })
+
+	for i := 0; i < len(b.ring); i++ {
+		node := b.ring[(start+i)%len(b.ring)]
+		if slices.Contains(candidates, node.upstream) {
+			return node.upstream
+		}
+	}
+
+	return candidates[0]
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	// fnv spreads short similar keys poorly, mix the bits
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+
+	return x
+}
@@ -0,0 +1,384 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"Servus/internal/client"
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+const DefaultRealm = "proxy"
+
+var errDestinationDenied = errors.New("proxy: destination not allowed")
+
+type ForwardOptions struct {
+	// destination hosts as 'example.com', '*.example.com' for
+	// its subdomains, an IP or a CIDR range. IP rules are also
+	// checked against the addresses names resolve to.
+	// every host is allowed when AllowHosts is empty and
+	// DenyHosts wins over AllowHosts
+	AllowHosts []string
+	DenyHosts  []string
+	// destination ports, every port is allowed when AllowPorts
+	// is empty
+	AllowPorts []int
+	DenyPorts  []int
+
+	// checks 'Proxy-Authorization' basic credentials, requests
+	// are not authenticated when nil
+	Authenticate func(user, password string) bool
+	Realm        string
+
+	DialTimeout           time.Duration
+	ResponseHeaderTimeout time.Duration
+	ReadTimeout           time.Duration
+	IdleConnTimeout       time.Duration
+	// idle connections kept per destination
+	MaxIdleConns int
+}
+
+// ForwardProxy is an egress proxy, it forwards absolute-form
+// http requests and tunnels CONNECT requests
+type ForwardProxy struct {
+	allow        hostRules
+	deny         hostRules
+	allowPorts   []int
+	denyPorts    []int
+	authenticate func(user, password string) bool
+	realm        string
+	dialTimeout  time.Duration
+	client       *client.Client
+}
+
+type hostRules struct {
+	names []string
+	nets  []*net.IPNet
+}
+
+func NewForward(opts ForwardOptions) (*ForwardProxy, error) {
+	f := &ForwardProxy{
+		allowPorts:   opts.AllowPorts,
+		denyPorts:    opts.DenyPorts,
+		authenticate: opts.Authenticate,
+		realm:        opts.Realm,
+		dialTimeout:  orDefault(opts.DialTimeout, DefaultDialTimeout),
+	}
+
+	if f.realm == "" {
+		f.realm = DefaultRealm
+	}
+
+	var err error
+	f.allow, err = parseHostRules(opts.AllowHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	f.deny, err = parseHostRules(opts.DenyHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, port := range append(slices.Clone(opts.AllowPorts), opts.DenyPorts...) {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port rule %d", port)
+		}
+	}
+
+	f.client = client.New(client.Options{
+		DialTimeout:           f.dialTimeout,
+		ResponseHeaderTimeout: orDefault(opts.ResponseHeaderTimeout, DefaultResponseHeaderTimeout),
+		ReadTimeout:           orDefault(opts.ReadTimeout, DefaultReadTimeout),
+		IdleConnTimeout:       orDefault(opts.IdleConnTimeout, DefaultIdleConnTimeout),
+		MaxIdleConns:          maxIdleConns(opts.MaxIdleConns),
+		MaxRedirects:          -1,
+		Dial:                  f.dial,
+	})
+
+	return f, nil
+}
+
+func (f *ForwardProxy) Close() {
+	/*
+	* @brief: closes the idle destination connections
+	*/
+	f.client.Close()
+}
+
+func (f *ForwardProxy) Handle(w *response.Writer, req *request.Request) {
+	/*
+	* @brief: forwards an absolute-form http request or tunnels
+	* a CONNECT request, it is a response.Handler
+	*
+	* destinations outside the rules get 403, unauthenticated
+	* clients 407 when authentication is on
+	*/
+	if !f.authorized(req) {
+		body := "407 Proxy Authentication Required"
+		h := headers.GetDefaultHeaders(len(body))
+		h.AddOverride("Proxy-Authenticate", "Basic realm="+strconv.Quote(f.realm)+`, charset="UTF-8"`)
+		w.Response = &response.Response{
+			Code:    response.CodeProxyAuthRequired,
+			Message: []byte(body),
+			Headers: h,
+		}
+		w.WriteResponse()
+		return
+	}
+
+	if req.RequestLine.Method == "CONNECT" {
+		f.connect(w, req)
+		return
+	}
+
+	target, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil || target.Host == "" {
+		writeStatus(w, response.CodeBadRequest, "an absolute-form target is required")
+		return
+	}
+
+	if target.Scheme != "http" {
+		writeStatus(w, response.CodeBadRequest, "only http targets are forwarded, use CONNECT")
+		return
+	}
+
+	port := target.Port()
+	if port == "" {
+		port = "80"
+	}
+
+	err = f.checkDestination(target.Hostname(), port)
+	if err != nil {
+		f.writeError(w, target.Host, err)
+		return
+	}
+
+	u := &upstream{addr: net.JoinHostPort(target.Hostname(), port), weight: 1, healthy: true}
+
+	// the target authority replaces whatever 'Host' the client sent
+	h := forwardHeaders(req)
+	h["host"] = target.Host
+
+	resp, err := roundTrip(f.client, u, req.RequestLine.Method, target, h, req.Body)
+	if err != nil {
+		f.writeError(w, u.addr, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 101 {
+		tunnel(w, resp)
+		return
+	}
+
+	relay(w, resp, u.addr)
+}
+
+func (f *ForwardProxy) connect(w *response.Writer, req *request.Request) {
+	/*
+	* opens a tunnel to the 'host:port' target, once the 200 is
+	* sent bytes are copied both ways untouched
+	*/
+	addr := req.RequestLine.RequestTarget
+	host, port, _ := net.SplitHostPort(addr)
+
+	err := f.checkDestination(host, port)
+	if err != nil {
+		f.writeError(w, addr, err)
+		return
+	}
+
+	if w.Hijacker == nil {
+		writeStatus(w, response.CodeInternalServerError, "connection cannot be tunneled")
+		return
+	}
+
+	conn, err := f.dial(addr)
+	if err != nil {
+		f.writeError(w, addr, err)
+		return
+	}
+	defer conn.Close()
+
+	err = w.WriteStatusLine(response.CodeOK)
+	if err == nil {
+		err = w.WriteHeaders(headers.Headers{})
+	}
+	if err != nil {
+		return
+	}
+
+	splice(w, conn)
+}
+
+func (f *ForwardProxy) authorized(req *request.Request) bool {
+	if f.authenticate == nil {
+		return true
+	}
+
+	value, ok := req.Headers.Get("Proxy-Authorization")
+	scheme, credentials, _ := strings.Cut(strings.TrimSpace(value), " ")
+	if !ok || !strings.EqualFold(scheme, "basic") {
+		return false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return false
+	}
+
+	user, password, found := strings.Cut(string(decoded), ":")
+
+	return found && f.authenticate(user, password)
+}
+
+func (f *ForwardProxy) checkDestination(host, port string) error {
+	/*
+	* applies the rules that do not need a connection, the
+	* addresses a name resolves to are checked by dial
+	*/
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return errDestinationDenied
+	}
+
+	if slices.Contains(f.denyPorts, n) || (len(f.allowPorts) > 0 && !slices.Contains(f.allowPorts, n)) {
+		return errDestinationDenied
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if f.deny.matchName(host) {
+		return errDestinationDenied
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if f.deny.matchIP(ip) {
+			return errDestinationDenied
+		}
+		if !f.allow.empty() && !f.allow.matchIP(ip) {
+			return errDestinationDenied
+		}
+		return nil
+	}
+
+	if !f.allow.empty() && !f.allow.matchName(host) && len(f.allow.nets) == 0 {
+		return errDestinationDenied
+	}
+
+	return nil
+}
+
+func (f *ForwardProxy) dial(addr string) (net.Conn, error) {
+	/*
+	* connects to addr, refusing resolved addresses the IP
+	* rules exclude so names cannot smuggle denied addresses in
+	*/
+	host, _, _ := net.SplitHostPort(addr)
+	named := f.allow.matchName(strings.TrimSuffix(strings.ToLower(host), "."))
+
+	dialer := net.Dialer{
+		Timeout: f.dialTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ipString, _, err := net.SplitHostPort(address)
+			ip := net.ParseIP(ipString)
+			if err != nil || ip == nil {
+				return errDestinationDenied
+			}
+
+			if f.deny.matchIP(ip) || (!f.allow.empty() && !named && !f.allow.matchIP(ip)) {
+				return errDestinationDenied
+			}
+
+			return nil
+		},
+	}
+
+	return dialer.Dial("tcp", addr)
+}
+
+func (f *ForwardProxy) writeError(w *response.Writer, dest string, err error) {
+	if errors.Is(err, errDestinationDenied) {
+		log.Printf("proxy: denied destination %s", dest)
+		writeStatus(w, response.CodeForbidden, "destination not allowed")
+		return
+	}
+
+	log.Printf("proxy: destination %s: %v", dest, err)
+	writeError(w, err)
+}
+
+func parseHostRules(patterns []string) (hostRules, error) {
+	var rules hostRules
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(pattern)), ".")
+
+		switch {
+		case pattern == "" || pattern == "*.":
+			return rules, fmt.Errorf("invalid host rule %q", pattern)
+
+		case strings.Contains(pattern, "/"):
+			_, ipNet, err := net.ParseCIDR(pattern)
+			if err != nil {
+				return rules, fmt.Errorf("invalid host rule %q: %v", pattern, err)
+			}
+			rules.nets = append(rules.nets, ipNet)
+
+		case net.ParseIP(pattern) != nil:
+			ip := net.ParseIP(pattern)
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			rules.nets = append(rules.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+
+		default:
+			rules.names = append(rules.names, pattern)
+		}
+	}
+
+	return rules, nil
+}
+
+func (r hostRules) empty() bool {
+	return len(r.names) == 0 && len(r.nets) == 0
+}
+
+func (r hostRules) matchName(host string) bool {
+	for _, name := range r.names {
+		if suffix, ok := strings.CutPrefix(name, "*"); ok {
+			// '*.example.com' covers subdomains, not example.com itself
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+			continue
+		}
+
+		if host == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r hostRules) matchIP(ip net.IP) bool {
+	for _, ipNet := range r.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"Servus/internal/content"
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+const (
+	DefaultHealthInterval     = 10 * time.Second
+	DefaultHealthTimeout      = 2 * time.Second
+	DefaultHealthyThreshold   = 2
+	DefaultUnhealthyThreshold = 3
+	DefaultMaxFails           = 3
+	DefaultFailTimeout        = 30 * time.Second
+)
+
+type HealthCheck struct {
+	// path probed with a GET, active checks are off when empty,
+	// any 2xx or 3xx answer counts as healthy
+	Path     string
+	Interval time.Duration
+	Timeout  time.Duration
+	// consecutive probes needed to change the state of an upstream
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+type UpstreamStatus struct {
+	Address             string     `json:"address"`
+	Weight              int        `json:"weight"`
+	Available           bool       `json:"available"`
+	Healthy             bool       `json:"healthy"`
+	EjectedUntil        *time.Time `json:"ejected_until,omitempty"`
+	InFlight            int64      `json:"in_flight"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastCheck           *time.Time `json:"last_check,omitempty"`
+}
+
+type PoolStatus struct {
+	Strategy  string           `json:"strategy"`
+	Upstreams []UpstreamStatus `json:"upstreams"`
+}
+
+func (u *upstream) available() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.healthy && !time.Now().Before(u.ejectedUntil)
+}
+
+func (u *upstream) reportSuccess() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.failures = 0
+}
+
+func (u *upstream) reportFailure(err error, maxFails int, failTimeout time.Duration) {
+	/*
+	* passive check, enough consecutive failures eject the
+	* upstream for failTimeout
+	*/
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.failures++
+	u.lastError = err.Error()
+
+	if maxFails > 0 && u.failures >= maxFails {
+		log.Printf("proxy: ejecting upstream %s for %v after %d failures", u.key(), failTimeout, u.failures)
+		u.ejectedUntil = time.Now().Add(failTimeout)
+		u.failures = 0
+	}
+}
+
+func (u *upstream) reportProbe(err error, check HealthCheck) {
+	/*
+	* active check, the state flips once enough consecutive
+	* probes disagree with it
+	*/
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.lastCheck = time.Now()
+	ok := err == nil
+	if !ok {
+		u.lastError = err.Error()
+	}
+
+	if ok == u.healthy {
+		u.probeStreak = 0
+		return
+	}
+
+	u.probeStreak++
+	threshold := check.UnhealthyThreshold
+	if ok {
+		threshold = check.HealthyThreshold
+	}
+
+	if u.probeStreak >= threshold {
+		u.healthy = ok
+		u.probeStreak = 0
+		if ok {
+			// a recovered upstream gets a clean slate
+			u.ejectedUntil = time.Time{}
+			u.failures = 0
+		}
+		log.Printf("proxy: upstream %s is now healthy=%v", u.key(), ok)
+	}
+}
+
+func (u *upstream) status() UpstreamStatus {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	s := UpstreamStatus{
+		Address:             u.key(),
+		Weight:              u.weight,
+		Available:           u.healthy && !time.Now().Before(u.ejectedUntil),
+		Healthy:             u.healthy,
+		InFlight:            u.inFlight.Load(),
+		ConsecutiveFailures: u.failures,
+		LastError:           u.lastError,
+	}
+
+	if time.Now().Before(u.ejectedUntil) {
+		ejectedUntil := u.ejectedUntil
+		s.EjectedUntil = &ejectedUntil
+	}
+
+	if !u.lastCheck.IsZero() {
+		lastCheck := u.lastCheck
+		s.LastCheck = &lastCheck
+	}
+
+	return s
+}
+
+func (p *ReverseProxy) runHealthChecks() {
+	ticker := time.NewTicker(p.healthCheck.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.probeAll()
+
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *ReverseProxy) probeAll() {
+	var wg sync.WaitGroup
+	for _, u := range p.upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u.reportProbe(p.probe(u), p.healthCheck)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *ReverseProxy) probe(u *upstream) error {
+	target, err := url.Parse(p.healthCheck.Path)
+	if err != nil {
+		return err
+	}
+
+	h := headers.Headers{"user-agent": "servus-health-check", "connection": "close"}
+	resp, err := roundTrip(p.probeClient, u, "GET", target, h, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return &probeError{resp.StatusCode}
+	}
+
+	return nil
+}
+
+type probeError struct {
+	code int
+}
+
+func (e *probeError) Error() string {
+	return fmt.Sprintf("health check answered %d", e.code)
+}
+
+// statusError is the passive failure of an upstream answering
+// a request with a gateway error
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("upstream answered %d", e.code)
+}
+
+func isGatewayError(code int) bool {
+	/*
+	* 502, 503 and 504 tell that the upstream cannot serve,
+	* other 5xx may be about the request alone
+	*/
+	return code == 502 || code == 503 || code == 504
+}
+
+func (p *ReverseProxy) Status() PoolStatus {
+	/*
+	* @brief: returns the balancing strategy and the health
+	* state of every upstream
+	*/
+	status := PoolStatus{Strategy: p.balancer.strategy.String()}
+	for _, u := range p.upstreams {
+		status.Upstreams = append(status.Upstreams, u.status())
+	}
+
+	return status
+}
+
+func (p *ReverseProxy) AdminHandler(w *response.Writer, req *request.Request) {
+	/*
+	* @brief: reports Status as JSON, with a 503 when no
+	* upstream can take requests
+	*/
+	status := p.Status()
+
+	code := response.CodeServiceUnavailable
+	for _, u := range status.Upstreams {
+		if u.Available {
+			code = response.CodeOK
+			break
+		}
+	}
+
+	err := content.JSON(w, code, status)
+	if err != nil {
+		content.WriteError(w, err)
+	}
+	w.WriteResponse()
+}
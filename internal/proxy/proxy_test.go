@@ -0,0 +1,649 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Servus/internal/request"
+	"Servus/internal/server"
+)
+
+// fakeUpstream serves keep-alive connections with a function
+// returning the raw response to each request
+type fakeUpstream struct {
+	listener net.Listener
+	accepted atomic.Int32
+}
+
+func newFakeUpstream(t *testing.T, respond func(req *request.Request) string) *fakeUpstream {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	u := &fakeUpstream{listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			u.accepted.Add(1)
+
+			go func() {
+				defer conn.Close()
+				for {
+					req, err := request.RequestFromReader(conn)
+					if err != nil {
+						return
+					}
+
+					raw := respond(req)
+					if raw == "" {
+						return
+					}
+					conn.Write([]byte(raw))
+				}
+			}()
+		}
+	}()
+
+	return u
+}
+
+func (u *fakeUpstream) addr() string {
+	return u.listener.Addr().String()
+}
+
+func serveProxy(t *testing.T, opts Options) string {
+	p, err := New(opts)
+	require.NoError(t, err)
+	t.Cleanup(p.Close)
+
+	s, err := server.Serve(0, p.Handle)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	return fmt.Sprintf("127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+}
+
+func send(t *testing.T, addr, raw string) string {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	return string(resp)
+}
+
+func TestForward(t *testing.T) {
+	upstream := newFakeUpstream(t, func(req *request.Request) string {
+		lines := []string{req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + string(req.Body)}
+		for key, value := range req.Headers {
+			lines = append(lines, key+"="+value)
+		}
+		sort.Strings(lines[1:])
+		body := strings.Join(lines, "\n") + "\n"
+
+		return "HTTP/1.1 201 Created\r\nContent-Length: " + fmt.Sprint(len(body)) + "\r\n" +
+			"Keep-Alive: timeout=5\r\nX-Upstream: yes\r\n" +
+			"Set-Cookie: a=1; Path=/\r\nSet-Cookie: b=2; HttpOnly\r\n" +
+			"Set-Cookie: c=3; SameSite=None; Partitioned; Priority=High\r\nSet-Cookie: not a cookie\r\n\r\n" + body
+	})
+	addr := serveProxy(t, Options{Upstreams: []string{upstream.addr()}})
+
+	resp := send(t, addr, "POST /orders?id=7 HTTP/1.1\r\nHost: edge.example\r\n"+
+		"Connection: X-Secret\r\nX-Secret: 1\r\nTE: trailers\r\n"+
+		"X-Forwarded-For: 10.0.0.1\r\nContent-Length: 5\r\n\r\nhello")
+
+	// test: status, headers and cookies are relayed
+	assert.Contains(t, resp, "HTTP/1.1 201 Created\r\n")
+	assert.Contains(t, resp, "x-upstream: yes\r\n")
+	assert.Contains(t, resp, "set-cookie: a=1; Path=/\r\n")
+	assert.Contains(t, resp, "set-cookie: b=2; HttpOnly\r\n")
+	assert.NotContains(t, resp, "keep-alive")
+
+	// test: cookies are relayed unchanged, even those Servus
+	// would not set itself
+	assert.Contains(t, resp, "set-cookie: c=3; SameSite=None; Partitioned; Priority=High\r\n")
+	assert.Contains(t, resp, "set-cookie: not a cookie\r\n")
+
+	// test: the upstream sees the request with forwarding headers
+	_, body, _ := strings.Cut(resp, "\r\n\r\n")
+	assert.Contains(t, body, "POST /orders?id=7 hello\n")
+	assert.Contains(t, body, "host=edge.example\n")
+	assert.Contains(t, body, "x-forwarded-for=10.0.0.1, 127.0.0.1\n")
+	assert.Contains(t, body, "x-forwarded-proto=http\n")
+	assert.Contains(t, body, "x-forwarded-host=edge.example\n")
+	assert.Contains(t, body, "forwarded=for=127.0.0.1;proto=http;host=edge.example\n")
+	assert.NotContains(t, body, "x-secret")
+	assert.NotContains(t, body, "te=")
+	assert.NotContains(t, body, "connection=")
+	assert.NotContains(t, body, "user-agent=")
+
+	// test: bodies over MaxBodySize are not forwarded
+	addr = serveProxy(t, Options{Upstreams: []string{upstream.addr()}, MaxBodySize: 4})
+	resp = send(t, addr, "POST /orders HTTP/1.1\r\nHost: edge.example\r\nContent-Length: 5\r\n\r\nhello")
+	assert.Contains(t, resp, "HTTP/1.1 413 Content Too Large\r\n")
+	assert.Equal(t, int32(1), upstream.accepted.Load())
+}
+
+func TestStreamAndReuse(t *testing.T) {
+	upstream := newFakeUpstream(t, func(req *request.Request) string {
+		if req.RequestLine.RequestTarget == "/chunked" {
+			return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"5\r\nhello\r\n7;ext=1\r\n, world\r\n0\r\nX-Trailer: 1\r\n\r\n"
+		}
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	})
+	addr := serveProxy(t, Options{Upstreams: []string{upstream.addr()}})
+
+	// test: bodies of unknown length are relayed chunked
+	resp := send(t, addr, "GET /chunked HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n5\r\nhello\r\n7\r\n, world\r\n0\r\n\r\n"), resp)
+
+	// test: upstream connections are pooled
+	for i := 0; i < 3; i++ {
+		resp = send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\nok"), resp)
+	}
+	assert.Equal(t, int32(1), upstream.accepted.Load())
+}
+
+func TestStaleConnection(t *testing.T) {
+	// the upstream closes every connection after one response
+	// without announcing it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+
+			_, err = request.RequestFromReader(conn)
+			if err == nil {
+				conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+			}
+			conn.Close()
+		}
+	}()
+
+	addr := serveProxy(t, Options{Upstreams: []string{l.Addr().String()}})
+
+	// test: the pooled connection closed by the upstream is retried
+	for i := 0; i < 2; i++ {
+		resp := send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\nok"), resp)
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, int32(2), accepted.Load())
+
+	// test: a POST is not retried, the upstream may have acted
+	// on it before closing
+	resp := send(t, addr, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1\r\n\r\nx")
+	assert.Contains(t, resp, "HTTP/1.1 502 Bad Gateway\r\n")
+	assert.Equal(t, int32(2), accepted.Load())
+}
+
+func TestUpstreamErrors(t *testing.T) {
+	// test: unreachable upstream
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dead.Close()
+
+	addr := serveProxy(t, Options{Upstreams: []string{dead.Addr().String()}})
+	resp := send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 502 Bad Gateway\r\n")
+
+	// test: upstream too slow to answer
+	slow := newFakeUpstream(t, func(req *request.Request) string {
+		time.Sleep(200 * time.Millisecond)
+		return "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"
+	})
+	addr = serveProxy(t, Options{Upstreams: []string{slow.addr()}, ResponseHeaderTimeout: 50 * time.Millisecond})
+	resp = send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 504 Gateway Timeout\r\n")
+
+	// test: malformed response
+	garbage := newFakeUpstream(t, func(req *request.Request) string { return "SSH-2.0-OpenSSH\r\n\r\n" })
+	addr = serveProxy(t, Options{Upstreams: []string{garbage.addr()}})
+	resp = send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 502 Bad Gateway\r\n")
+
+	// test: a header line that never ends
+	endless := newFakeUpstream(t, func(req *request.Request) string {
+		return "HTTP/1.1 200 OK\r\nX-Long: " + strings.Repeat("a", 100<<10)
+	})
+	addr = serveProxy(t, Options{Upstreams: []string{endless.addr()}})
+	resp = send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 502 Bad Gateway\r\n")
+
+	// test: invalid configuration
+	_, err = New(Options{Upstreams: []string{"ftp://example.com"}})
+	require.Error(t, err)
+}
+
+func TestUpgrade(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req, err := request.RequestFromReader(conn)
+		if err != nil {
+			return
+		}
+		upgrade, _ := req.Headers.Get("Upgrade")
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: " + upgrade + "\r\n\r\n"))
+		conn.Write(req.Buffered())
+		io.Copy(conn, conn)
+	}()
+
+	addr := serveProxy(t, Options{Upstreams: []string{l.Addr().String()}})
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// test: the protocol switch is relayed and bytes flow both ways
+	_, err = conn.Write([]byte("GET /echo HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nping"))
+	require.NoError(t, err)
+
+	head := "HTTP/1.1 101 Switching Protocols\r\n"
+	buf := make([]byte, 512)
+	received := ""
+	for !strings.HasSuffix(received, "ping") {
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		received += string(buf[:n])
+	}
+	assert.True(t, strings.HasPrefix(received, head), received)
+	assert.Contains(t, received, "upgrade: echo\r\n")
+
+	_, err = conn.Write([]byte("pong"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, buf[:4])
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(buf[:4]))
+}
+
+func TestBalancing(t *testing.T) {
+	names := []string{"a", "b", "c"}
+	var addrs []string
+	for _, name := range names {
+		u := newFakeUpstream(t, func(req *request.Request) string {
+			return "HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\n" + name
+		})
+		addrs = append(addrs, u.addr())
+	}
+
+	count := func(addr string, n int, raw string) map[string]int {
+		seen := map[string]int{}
+		for i := 0; i < n; i++ {
+			resp := send(t, addr, raw)
+			seen[resp[len(resp)-1:]]++
+		}
+		return seen
+	}
+	get := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	// test: round-robin spreads requests evenly
+	addr := serveProxy(t, Options{Upstreams: addrs})
+	assert.Equal(t, map[string]int{"a": 3, "b": 3, "c": 3}, count(addr, 9, get))
+
+	// test: weighted round-robin follows the weights
+	addr = serveProxy(t, Options{
+		Upstreams: addrs,
+		Strategy:  WeightedRoundRobin,
+		Weights:   map[string]int{addrs[0]: 3, addrs[2]: 2},
+	})
+	assert.Equal(t, map[string]int{"a": 6, "b": 2, "c": 4}, count(addr, 12, get))
+
+	// test: least connections balances sequential requests too
+	addr = serveProxy(t, Options{Upstreams: addrs, Strategy: LeastConnections})
+	assert.Equal(t, map[string]int{"a": 2, "b": 2, "c": 2}, count(addr, 6, get))
+
+	// test: consistent hashing sticks a key to one upstream
+	addr = serveProxy(t, Options{Upstreams: addrs, Strategy: ConsistentHash, HashHeader: "X-User"})
+	spread := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		seen := count(addr, 3, fmt.Sprintf("GET / HTTP/1.1\r\nHost: localhost\r\nX-User: user-%d\r\n\r\n", i))
+		assert.Len(t, seen, 1)
+		for name := range seen {
+			spread[name] = true
+		}
+	}
+	assert.Len(t, spread, 3)
+
+	// test: invalid configuration
+	_, err := New(Options{Upstreams: addrs, Weights: map[string]int{addrs[0]: 0}})
+	require.Error(t, err)
+	_, err = New(Options{Upstreams: addrs, Strategy: Strategy(9)})
+	require.Error(t, err)
+}
+
+func TestConsistentHashRemap(t *testing.T) {
+	var upstreams []*upstream
+	for i := 0; i < 4; i++ {
+		u, err := parseUpstream(fmt.Sprintf("10.0.0.%d:80", i))
+		require.NoError(t, err)
+		upstreams = append(upstreams, u)
+	}
+	b, err := newBalancer(ConsistentHash, upstreams)
+	require.NoError(t, err)
+
+	before := map[string]*upstream{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint("key-", i)
+		before[key] = b.pick(key, nil)
+	}
+
+	// test: only the keys of a missing upstream move
+	for key, u := range before {
+		after := b.pick(key, []*upstream{upstreams[1]})
+		if u != upstreams[1] {
+			assert.Same(t, u, after, key)
+		} else {
+			assert.NotSame(t, u, after, key)
+		}
+	}
+}
+
+func TestPassiveHealth(t *testing.T) {
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dead.Close()
+
+	live := newFakeUpstream(t, func(req *request.Request) string {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	})
+
+	p, err := New(Options{
+		Upstreams:   []string{dead.Addr().String(), live.addr()},
+		MaxFails:    2,
+		FailTimeout: time.Minute,
+	})
+	require.NoError(t, err)
+	defer p.Close()
+
+	s, err := server.Serve(0, p.Handle)
+	require.NoError(t, err)
+	defer s.Close()
+	addr := fmt.Sprintf("127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+
+	// test: requests to an unreachable upstream move to the next one
+	for i := 0; i < 4; i++ {
+		resp := send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\nok"), resp)
+	}
+
+	// test: enough failures eject the upstream
+	status := p.Status()
+	assert.Equal(t, "round-robin", status.Strategy)
+	assert.False(t, status.Upstreams[0].Available)
+	assert.True(t, status.Upstreams[0].Healthy)
+	assert.NotNil(t, status.Upstreams[0].EjectedUntil)
+	assert.NotEmpty(t, status.Upstreams[0].LastError)
+	assert.True(t, status.Upstreams[1].Available)
+
+	// test: gateway errors count as failures, they are relayed
+	unavailable := newFakeUpstream(t, func(req *request.Request) string {
+		return "HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\n\r\n"
+	})
+	p3, err := New(Options{Upstreams: []string{unavailable.addr()}, MaxFails: 2})
+	require.NoError(t, err)
+	defer p3.Close()
+	s3, err := server.Serve(0, p3.Handle)
+	require.NoError(t, err)
+	defer s3.Close()
+	for i := 0; i < 2; i++ {
+		resp := send(t, s3.Addr().String(), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		assert.Contains(t, resp, "HTTP/1.1 503 Service Unavailable\r\n")
+	}
+	status = p3.Status()
+	assert.False(t, status.Upstreams[0].Available)
+	assert.Equal(t, "upstream answered 503", status.Upstreams[0].LastError)
+
+	// test: no upstream left
+	p2 := serveProxy(t, Options{Upstreams: []string{dead.Addr().String()}, MaxFails: 1})
+	resp := send(t, p2, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 502 Bad Gateway\r\n")
+	resp = send(t, p2, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 503 Service Unavailable\r\n")
+}
+
+func TestActiveHealth(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	upstream := newFakeUpstream(t, func(req *request.Request) string {
+		if req.RequestLine.RequestTarget == "/healthz" && !healthy.Load() {
+			return "HTTP/1.1 500 Internal Server Error\r\nContent-Length: 0\r\n\r\n"
+		}
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	})
+
+	p, err := New(Options{
+		Upstreams: []string{upstream.addr()},
+		HealthCheck: HealthCheck{
+			Path:               "/healthz",
+			Interval:           10 * time.Millisecond,
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 2,
+		},
+	})
+	require.NoError(t, err)
+	defer p.Close()
+
+	s, err := server.Serve(0, p.AdminHandler)
+	require.NoError(t, err)
+	defer s.Close()
+	admin := fmt.Sprintf("127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+
+	// test: failing probes mark the upstream unhealthy
+	healthy.Store(false)
+	require.Eventually(t, func() bool { return !p.Status().Upstreams[0].Healthy }, 2*time.Second, 10*time.Millisecond)
+
+	resp := send(t, admin, "GET /upstreams HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 503 Service Unavailable\r\n")
+	assert.Contains(t, resp, `"healthy":false`)
+	assert.Contains(t, resp, `"last_error":"health check answered 500"`)
+
+	// test: passing probes bring it back
+	healthy.Store(true)
+	require.Eventually(t, func() bool { return p.Status().Upstreams[0].Healthy }, 2*time.Second, 10*time.Millisecond)
+
+	resp = send(t, admin, "GET /upstreams HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, resp, `"strategy":"round-robin"`)
+	assert.Contains(t, resp, `"address":"http://`+upstream.addr()+`"`)
+}
+
+func serveForward(t *testing.T, opts ForwardOptions) string {
+	f, err := NewForward(opts)
+	require.NoError(t, err)
+	t.Cleanup(f.Close)
+
+	s, err := server.Serve(0, f.Handle)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	return fmt.Sprintf("127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+}
+
+func newEchoListener(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return l
+}
+
+func TestForwardProxy(t *testing.T) {
+	upstream := newFakeUpstream(t, func(req *request.Request) string {
+		body := req.RequestLine.RequestTarget + " host=" + req.Headers["host"]
+		if _, ok := req.Headers["proxy-connection"]; ok {
+			body += " proxy-connection"
+		}
+		return "HTTP/1.1 200 OK\r\nContent-Length: " + fmt.Sprint(len(body)) + "\r\n\r\n" + body
+	})
+	addr := serveForward(t, ForwardOptions{})
+
+	// test: absolute-form requests are sent in origin-form
+	target := "http://" + upstream.addr()
+	resp := send(t, addr, "GET "+target+"/path?q=1 HTTP/1.1\r\nHost: other\r\nProxy-Connection: keep-alive\r\n\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n/path?q=1 host="+upstream.addr()), resp)
+
+	resp = send(t, addr, "GET "+target+" HTTP/1.1\r\nHost: other\r\n\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n/ host="+upstream.addr()), resp)
+
+	// test: targets that cannot be forwarded
+	resp = send(t, addr, "GET /path HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 400 Bad Request\r\n")
+	resp = send(t, addr, "GET https://"+upstream.addr()+"/ HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 400 Bad Request\r\n")
+
+	// test: CONNECT opens a tunnel
+	echo := newEchoListener(t)
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte("CONNECT " + echo.Addr().String() + " HTTP/1.1\r\nHost: " + echo.Addr().String() + "\r\n\r\nping"))
+	require.NoError(t, err)
+
+	expected := "HTTP/1.1 200 OK\r\n\r\nping"
+	buf := make([]byte, len(expected))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(buf))
+
+	_, err = conn.Write([]byte("pong"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, buf[:4])
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(buf[:4]))
+}
+
+// sendHead returns the response head only, tunnels stay open
+func sendHead(t *testing.T, addr, raw string) string {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+
+	received := ""
+	buf := make([]byte, 512)
+	for !strings.Contains(received, "\r\n\r\n") {
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		received += string(buf[:n])
+	}
+
+	return received
+}
+
+func TestForwardRules(t *testing.T) {
+	echo := newEchoListener(t)
+	_, port, _ := net.SplitHostPort(echo.Addr().String())
+	connect := func(addr, target string) string {
+		return sendHead(t, addr, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+	}
+
+	// test: denied ports
+	n, _ := strconv.Atoi(port)
+	addr := serveForward(t, ForwardOptions{DenyPorts: []int{n}})
+	assert.Contains(t, connect(addr, echo.Addr().String()), "HTTP/1.1 403 Forbidden\r\n")
+
+	addr = serveForward(t, ForwardOptions{AllowPorts: []int{443}})
+	assert.Contains(t, connect(addr, echo.Addr().String()), "HTTP/1.1 403 Forbidden\r\n")
+
+	// test: denied hosts
+	addr = serveForward(t, ForwardOptions{DenyHosts: []string{"127.0.0.0/8"}})
+	assert.Contains(t, connect(addr, echo.Addr().String()), "HTTP/1.1 403 Forbidden\r\n")
+	resp := send(t, addr, "GET http://"+echo.Addr().String()+"/ HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 403 Forbidden\r\n")
+
+	addr = serveForward(t, ForwardOptions{AllowHosts: []string{"*.example.com"}})
+	assert.Contains(t, connect(addr, echo.Addr().String()), "HTTP/1.1 403 Forbidden\r\n")
+	assert.Contains(t, connect(addr, "localhost:"+port), "HTTP/1.1 403 Forbidden\r\n")
+
+	// test: resolved addresses are checked against IP rules
+	addr = serveForward(t, ForwardOptions{AllowHosts: []string{"10.0.0.0/8"}})
+	assert.Contains(t, connect(addr, "localhost:"+port), "HTTP/1.1 403 Forbidden\r\n")
+
+	// test: allowed destinations
+	addr = serveForward(t, ForwardOptions{AllowHosts: []string{"localhost", "127.0.0.1"}, AllowPorts: []int{n}})
+	assert.Contains(t, connect(addr, "localhost:"+port), "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, connect(addr, echo.Addr().String()), "HTTP/1.1 200 OK\r\n")
+
+	// test: invalid rules
+	_, err := NewForward(ForwardOptions{DenyHosts: []string{"10.0.0.0/33"}})
+	require.Error(t, err)
+	_, err = NewForward(ForwardOptions{AllowPorts: []int{0}})
+	require.Error(t, err)
+}
+
+func TestForwardAuth(t *testing.T) {
+	echo := newEchoListener(t)
+	addr := serveForward(t, ForwardOptions{
+		Authenticate: func(user, password string) bool { return user == "alice" && password == "s3cret" },
+		Realm:        "egress",
+	})
+	connect := "CONNECT " + echo.Addr().String() + " HTTP/1.1\r\nHost: " + echo.Addr().String() + "\r\n"
+
+	// test: missing or wrong credentials
+	resp := sendHead(t, addr, connect+"\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 407 Proxy Authentication Required\r\n")
+	assert.Contains(t, resp, "proxy-authenticate: Basic realm=\"egress\", charset=\"UTF-8\"\r\n")
+
+	resp = sendHead(t, addr, connect+"Proxy-Authorization: Basic YWxpY2U6d3Jvbmc=\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 407 Proxy Authentication Required\r\n")
+
+	// test: valid credentials
+	resp = sendHead(t, addr, connect+"Proxy-Authorization: Basic YWxpY2U6czNjcmV0\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 200 OK\r\n")
+}
@@ -0,0 +1,517 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Servus/internal/client"
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+const (
+	DefaultDialTimeout           = 5 * time.Second
+	DefaultResponseHeaderTimeout = 30 * time.Second
+	DefaultReadTimeout           = 30 * time.Second
+	DefaultIdleConnTimeout       = 90 * time.Second
+	DefaultMaxIdleConns          = 16
+	DefaultMaxBodySize           = 10 << 20
+
+	copyBufferSize = 32 << 10
+)
+
+// headers meaningful for a single connection only, RFC 9110 section 7.6.1
+var hopByHopHeaders = []string{
+	"connection",
+	"keep-alive",
+	"proxy-connection",
+	"proxy-authenticate",
+	"proxy-authorization",
+	"te",
+	"trailer",
+	"transfer-encoding",
+	"upgrade",
+}
+
+type Options struct {
+	// upstream addresses as 'host:port' or 'http(s)://host:port'
+	Upstreams []string
+	// relative weights keyed like Upstreams, 1 when missing
+	Weights  map[string]int
+	Strategy Strategy
+	// header whose value is hashed by ConsistentHash
+	HashHeader string
+	// sends the upstream address as 'Host' instead of the one
+	// the client asked for
+	RewriteHost bool
+
+	DialTimeout time.Duration
+	// time allowed for the upstream to answer with the response head
+	ResponseHeaderTimeout time.Duration
+	// time allowed between reads of the upstream response body
+	ReadTimeout     time.Duration
+	IdleConnTimeout time.Duration
+	// idle connections kept per upstream
+	MaxIdleConns int
+	// used for 'https://' upstreams
+	TLSConfig *tls.Config
+
+	HealthCheck HealthCheck
+	// consecutive failed requests that eject an upstream, a 502,
+	// 503 or 504 answer is a failure too. DefaultMaxFails if
+	// zero and never if negative
+	MaxFails int
+	// how long an ejected upstream receives no requests
+	FailTimeout time.Duration
+	// the server reads request bodies whole, so they are sent
+	// upstream only once received. larger ones are answered
+	// with a 413, DefaultMaxBodySize when zero and no limit
+	// when negative
+	MaxBodySize int64
+}
+
+type ReverseProxy struct {
+	upstreams   []*upstream
+	balancer    *balancer
+	hashHeader  string
+	rewriteHost bool
+	maxBodySize int64
+	client      *client.Client
+
+	maxFails    int
+	failTimeout time.Duration
+	healthCheck HealthCheck
+	probeClient *client.Client
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+func New(opts Options) (*ReverseProxy, error) {
+	if len(opts.Upstreams) == 0 {
+		return nil, fmt.Errorf("no upstream configured")
+	}
+
+	p := &ReverseProxy{
+		hashHeader:  opts.HashHeader,
+		rewriteHost: opts.RewriteHost,
+		maxBodySize: opts.MaxBodySize,
+		maxFails:    opts.MaxFails,
+		failTimeout: orDefault(opts.FailTimeout, DefaultFailTimeout),
+		stop:        make(chan struct{}),
+		client: client.New(client.Options{
+			DialTimeout:           orDefault(opts.DialTimeout, DefaultDialTimeout),
+			ResponseHeaderTimeout: orDefault(opts.ResponseHeaderTimeout, DefaultResponseHeaderTimeout),
+			ReadTimeout:           orDefault(opts.ReadTimeout, DefaultReadTimeout),
+			IdleConnTimeout:       orDefault(opts.IdleConnTimeout, DefaultIdleConnTimeout),
+			MaxIdleConns:          maxIdleConns(opts.MaxIdleConns),
+			MaxRedirects:          -1,
+			TLSConfig:             opts.TLSConfig,
+		}),
+	}
+
+	if p.maxFails == 0 {
+		p.maxFails = DefaultMaxFails
+	}
+	if p.maxBodySize == 0 {
+		p.maxBodySize = DefaultMaxBodySize
+	}
+
+	for _, raw := range opts.Upstreams {
+		u, err := parseUpstream(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		if weight, ok := opts.Weights[raw]; ok {
+			if weight <= 0 {
+				return nil, fmt.Errorf("invalid weight %d for upstream %q", weight, raw)
+			}
+			u.weight = weight
+		}
+
+		p.upstreams = append(p.upstreams, u)
+	}
+
+	var err error
+	p.balancer, err = newBalancer(opts.Strategy, p.upstreams)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.HealthCheck.Path != "" {
+		check := opts.HealthCheck
+		check.Interval = orDefault(check.Interval, DefaultHealthInterval)
+		check.Timeout = orDefault(check.Timeout, DefaultHealthTimeout)
+		if check.HealthyThreshold <= 0 {
+			check.HealthyThreshold = DefaultHealthyThreshold
+		}
+		if check.UnhealthyThreshold <= 0 {
+			check.UnhealthyThreshold = DefaultUnhealthyThreshold
+		}
+
+		p.healthCheck = check
+		p.probeClient = client.New(client.Options{
+			DialTimeout:           check.Timeout,
+			ResponseHeaderTimeout: check.Timeout,
+			ReadTimeout:           check.Timeout,
+			MaxIdleConns:          -1,
+			MaxRedirects:          -1,
+			TLSConfig:             opts.TLSConfig,
+		})
+		go p.runHealthChecks()
+	}
+
+	return p, nil
+}
+
+func (p *ReverseProxy) Close() {
+	/*
+	* @brief: stops the health checks and closes the idle
+	* upstream connections
+	*/
+	p.stopOnce.Do(func() { close(p.stop) })
+	p.client.Close()
+}
+
+func (p *ReverseProxy) Handle(w *response.Writer, req *request.Request) {
+	/*
+	* @brief: forwards req to an upstream picked by the balancer
+	* and relays the response, it is a response.Handler
+	*
+	* upstreams that cannot be connected to are skipped, other
+	* failures become 502 and upstreams too slow to answer 504.
+	* without any available upstream the answer is 503. the body
+	* is forwarded as read by the server, bodies over MaxBodySize
+	* are refused with a 413
+	*/
+	target, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil {
+		writeStatus(w, response.CodeBadRequest, "invalid request target")
+		return
+	}
+
+	if p.maxBodySize > 0 && int64(len(req.Body)) > p.maxBodySize {
+		writeStatus(w, response.CodeRequestEntityTooLarge, "request body too large")
+		return
+	}
+
+	key := clientIP(w.Connection.RemoteAddr())
+	if p.hashHeader != "" {
+		key, _ = req.Headers.Get(p.hashHeader)
+	}
+
+	var (
+		u     *upstream
+		resp  *client.Response
+		tried []*upstream
+	)
+	for {
+		u = p.balancer.pick(key, tried)
+		if u == nil {
+			if err == nil {
+				writeStatus(w, response.CodeServiceUnavailable, "no healthy upstream")
+			} else {
+				writeError(w, err)
+			}
+			return
+		}
+
+		u.inFlight.Add(1)
+		resp, err = roundTrip(p.client, u, req.RequestLine.Method, target, p.outgoingHeaders(w, req, u), req.Body)
+		if err == nil {
+			if isGatewayError(resp.StatusCode) {
+				// relayed all the same, the upstream may have
+				// acted on the request
+				u.reportFailure(&statusError{resp.StatusCode}, p.maxFails, p.failTimeout)
+			} else {
+				u.reportSuccess()
+			}
+			break
+		}
+
+		u.inFlight.Add(-1)
+		u.reportFailure(err, p.maxFails, p.failTimeout)
+		log.Printf("proxy: upstream %s: %v", u.addr, err)
+
+		var de *client.DialError
+		if !errors.As(err, &de) {
+			writeError(w, err)
+			return
+		}
+		tried = append(tried, u)
+	}
+	defer u.inFlight.Add(-1)
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 101 {
+		tunnel(w, resp)
+		return
+	}
+
+	relay(w, resp, u.addr)
+}
+
+func relay(w *response.Writer, resp *client.Response, addr string) {
+	/*
+	* writes the upstream response to the client, bodies of
+	* unknown length are sent chunked
+	*/
+	h := headers.Headers{}
+	for key, value := range resp.Headers {
+		h[key] = value
+	}
+	removeHopByHop(h)
+	h.AddOverride("Connection", "close")
+
+	// relayed as received, parsing them would drop the
+	// attributes and cookies Servus does not know
+	for _, value := range resp.SetCookies {
+		w.AddSetCookie(value)
+	}
+
+	if resp.ContentLength < 0 {
+		h.AddOverride("Transfer-Encoding", "chunked")
+	}
+
+	err := w.WriteStatusLine(response.StatusCode(resp.StatusCode))
+	if err != nil {
+		return
+	}
+
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return
+	}
+
+	if resp.ContentLength >= 0 {
+		w.WriteBodyFrom(resp.Body)
+		return
+	}
+
+	// the length is unknown, chunks are relayed as they come
+	buf := make([]byte, copyBufferSize)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			_, werr := w.WriteChunkedBody(buf[:n])
+			if werr != nil {
+				return
+			}
+		}
+
+		if err == io.EOF {
+			w.WriteChunkedBodyDone()
+			return
+		}
+
+		if err != nil {
+			// leaving the body unterminated tells the client it is truncated
+			log.Printf("proxy: upstream %s: %v", addr, err)
+			return
+		}
+	}
+}
+
+func (p *ReverseProxy) outgoingHeaders(w *response.Writer, req *request.Request, u *upstream) headers.Headers {
+	/*
+	* the request headers sent upstream
+	*/
+	h := forwardHeaders(req)
+
+	host := h["host"]
+	if p.rewriteHost || host == "" {
+		h["host"] = u.addr
+	}
+
+	setForwarded(h, w.Connection.RemoteAddr(), host, req.TLS != nil)
+
+	return h
+}
+
+func forwardHeaders(req *request.Request) headers.Headers {
+	/*
+	* copies the request headers without the hop-by-hop ones,
+	* an upgrade request keeps asking for the upgrade
+	*/
+	h := headers.Headers{}
+	for key, value := range req.Headers {
+		h[key] = value
+	}
+
+	upgrade, isUpgrade := h["upgrade"]
+	isUpgrade = isUpgrade && headerHasToken(h["connection"], "upgrade")
+	removeHopByHop(h)
+
+	if isUpgrade {
+		h["connection"] = "upgrade"
+		h["upgrade"] = upgrade
+	}
+
+	return h
+}
+
+func tunnel(w *response.Writer, resp *client.Response) {
+	/*
+	* relays a protocol switch, once the 101 is sent the client
+	* and the upstream talk through the proxy untouched. the
+	* body of a 101 is the upgraded connection
+	*/
+	upstream, ok := resp.Body.(io.ReadWriter)
+	if !ok {
+		writeStatus(w, response.CodeBadGateway, "")
+		return
+	}
+
+	h := headers.Headers{}
+	for key, value := range resp.Headers {
+		h[key] = value
+	}
+
+	err := w.WriteStatusLine(response.CodeSwitchingProtocols)
+	if err == nil {
+		err = w.WriteHeaders(h)
+	}
+	if err != nil {
+		return
+	}
+
+	splice(w, upstream)
+}
+
+func splice(w *response.Writer, upstream io.ReadWriter) {
+	/*
+	* takes the client connection over and copies bytes both
+	* ways until either side closes
+	*/
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		upstream.Write(buffered)
+		io.Copy(upstream, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+
+	// either side closing ends the tunnel
+	<-done
+}
+
+func setForwarded(h headers.Headers, remote net.Addr, host string, isTLS bool) {
+	/*
+	* appends the client to 'X-Forwarded-For' and 'Forwarded',
+	* keeping what earlier proxies recorded
+	*/
+	ip := clientIP(remote)
+
+	proto := "http"
+	if isTLS {
+		proto = "https"
+	}
+
+	h.Add("X-Forwarded-For", ip)
+	h.AddOverride("X-Forwarded-Proto", proto)
+	if host != "" {
+		h.AddOverride("X-Forwarded-Host", host)
+	}
+
+	node := ip
+	if strings.Contains(ip, ":") {
+		// IPv6 addresses are quoted and bracketed, RFC 7239 section 6
+		node = `"[` + ip + `]"`
+	}
+
+	forwarded := "for=" + node + ";proto=" + proto
+	if host != "" {
+		forwarded += ";host=" + quoteForwarded(host)
+	}
+	h.Add("Forwarded", forwarded)
+}
+
+func clientIP(remote net.Addr) string {
+	if tcpAddr, ok := remote.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+
+	return remote.String()
+}
+
+func quoteForwarded(value string) string {
+	for _, ch := range value {
+		if !((ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') ||
+			(ch >= '0' && ch <= '9') || strings.ContainsRune("-!#$%&'*+.^_`|~", ch)) {
+
+			return strconv.Quote(value)
+		}
+	}
+
+	return value
+}
+
+func removeHopByHop(h headers.Headers) {
+	// headers named by 'Connection' are hop-by-hop as well
+	for _, name := range strings.Split(h["connection"], ",") {
+		h.Delete(strings.TrimSpace(name))
+	}
+
+	for _, name := range hopByHopHeaders {
+		h.Delete(name)
+	}
+}
+
+func writeError(w *response.Writer, err error) {
+	code := response.CodeBadGateway
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		code = response.CodeGatewayTimeout
+	}
+
+	writeStatus(w, code, "")
+}
+
+func writeStatus(w *response.Writer, code response.StatusCode, detail string) {
+	body := fmt.Sprintf("%d %s", code, response.StatusText(code))
+	if detail != "" {
+		body += ": " + detail
+	}
+
+	w.Response = &response.Response{
+		Code:    code,
+		Message: []byte(body),
+		Headers: headers.GetDefaultHeaders(len(body)),
+	}
+	w.WriteResponse()
+}
+
+func maxIdleConns(n int) int {
+	if n <= 0 {
+		return DefaultMaxIdleConns
+	}
+
+	return n
+}
+
+func orDefault(d, fallback time.Duration) time.Duration {
+	if d == 0 {
+		return fallback
+	}
+
+	return d
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"Servus/internal/client"
+	"Servus/internal/headers"
+)
+
+type upstream struct {
+	// host:port
+	addr     string
+	tls      bool
+	weight   int
+	inFlight atomic.Int64
+
+	// health state, see health.go
+	mu sync.Mutex
+	// verdict of the active checks, upstreams start healthy
+	healthy bool
+	// consecutive probes contradicting healthy
+	probeStreak int
+	// consecutive failed requests, for passive checks
+	failures     int
+	ejectedUntil time.Time
+	lastError    string
+	lastCheck    time.Time
+}
+
+func parseUpstream(raw string) (*upstream, error) {
+	/*
+	* accepts 'host:port' or an 'http://' or 'https://' url,
+	* the port defaults to the one of the scheme
+	*/
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %v", raw, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid upstream %q: unsupported scheme", raw)
+	}
+
+	if u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return nil, fmt.Errorf("invalid upstream %q", raw)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	return &upstream{addr: addr, tls: u.Scheme == "https", weight: 1, healthy: true}, nil
+}
+
+func (u *upstream) key() string {
+	if u.tls {
+		return "https://" + u.addr
+	}
+
+	return "http://" + u.addr
+}
+
+func roundTrip(c *client.Client, u *upstream, method string, target *url.URL, h headers.Headers, body []byte) (*client.Response, error) {
+	/*
+	* @brief: sends a request for target to u, the response body
+	* is left to the caller
+	*
+	* c is built with a negative MaxRedirects so redirects are
+	* relayed, not followed
+	*/
+	dest := *target
+	dest.Scheme = "http"
+	if u.tls {
+		dest.Scheme = "https"
+	}
+	dest.Host = u.addr
+
+	// the client's own 'User-Agent' is not added to theirs
+	if _, ok := h.Get("User-Agent"); !ok {
+		h.AddOverride("User-Agent", "")
+	}
+
+	return c.Do(&client.Request{Method: method, URL: &dest, Headers: h, Body: body})
+}
+
+func headerHasToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+
+	return false
+}
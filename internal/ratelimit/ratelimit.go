@@ -0,0 +1,189 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+// KeyFunc tells which clients share a limit, requests with an
+// empty key are not limited
+type KeyFunc func(w *response.Writer, req *request.Request) string
+
+type Options struct {
+	// TokenBucket when zero
+	Algorithm Algorithm
+	// requests allowed per Window, both are required
+	Limit  int
+	Window time.Duration
+	// token bucket only, requests allowed at once after an idle
+	// period, Limit when zero
+	Burst int
+	// KeyByIP when nil
+	Key KeyFunc
+	// a MemoryStore of DefaultMaxKeys when nil
+	Store Store
+}
+
+func Middleware(opts Options) response.Middleware {
+	/*
+	* @brief: limits the rate of requests per key, rejected ones
+	* are answered with a 429 and 'Retry-After'
+	*
+	* every response carries 'RateLimit-Limit', 'RateLimit-Remaining'
+	* and 'RateLimit-Reset', in seconds, and 'RateLimit-Policy'. if
+	* the store fails the request is let through
+	*
+	* panics when Limit or Window is not positive
+	*/
+	if opts.Limit <= 0 || opts.Window <= 0 {
+		panic(fmt.Sprintf("ratelimit: invalid limit %d per %v", opts.Limit, opts.Window))
+	}
+
+	policy := Policy{
+		Algorithm: opts.Algorithm,
+		Limit:     opts.Limit,
+		Window:    opts.Window,
+		Burst:     opts.Burst,
+	}
+
+	key := opts.Key
+	if key == nil {
+		key = KeyByIP
+	}
+
+	store := opts.Store
+	if store == nil {
+		store = NewMemoryStore(0)
+	}
+
+	policyHeader := fmt.Sprintf("%d;w=%d", policy.Limit, int(math.Ceil(policy.Window.Seconds())))
+	if policy.Algorithm == TokenBucket && policy.Burst > 0 {
+		policyHeader += fmt.Sprintf(";burst=%d", policy.Burst)
+	}
+
+	return func(next response.Handler) response.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			k := key(w, req)
+			if k == "" {
+				next(w, req)
+				return
+			}
+
+			result, err := store.Take(k, policy, time.Now())
+			if err != nil {
+				log.Printf("rate limit store failed: %v", err)
+				next(w, req)
+				return
+			}
+
+			setHeaders := func(h headers.Headers) {
+				h.AddOverride("RateLimit-Limit", strconv.Itoa(policy.Limit))
+				h.AddOverride("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+				h.AddOverride("RateLimit-Reset", ceilSeconds(result.Reset))
+				h.AddOverride("RateLimit-Policy", policyHeader)
+			}
+
+			if result.Allowed {
+				w.OnWriteHeaders(setHeaders)
+				next(w, req)
+				return
+			}
+
+			body := fmt.Sprintf("%d %s", response.CodeTooManyRequests, response.StatusText(response.CodeTooManyRequests))
+			h := headers.GetDefaultHeaders(len(body))
+			setHeaders(h)
+			h.AddOverride("Retry-After", ceilSeconds(result.RetryAfter))
+
+			w.Response = &response.Response{
+				Code:    response.CodeTooManyRequests,
+				Message: []byte(body),
+				Headers: h,
+			}
+			w.WriteResponse()
+		}
+	}
+}
+
+func KeyByIP(w *response.Writer, req *request.Request) string {
+	/*
+	* @brief: keys by the address of the peer, behind a proxy
+	* that is the proxy's
+	*/
+	addr := w.Connection.RemoteAddr()
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return "ip:" + tcpAddr.IP.String()
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "ip:" + addr.String()
+	}
+
+	return "ip:" + host
+}
+
+func KeyByHeader(name string) KeyFunc {
+	/*
+	* @brief: keys by a header value such as an API key, clients
+	* without it are keyed by IP. values are hashed so that
+	* secrets are not kept around
+	*/
+	return func(w *response.Writer, req *request.Request) string {
+		value, ok := req.Headers.Get(name)
+		value = strings.TrimSpace(value)
+		if !ok || value == "" {
+			return KeyByIP(w, req)
+		}
+
+		sum := sha256.Sum256([]byte(value))
+
+		return "header:" + hex.EncodeToString(sum[:16])
+	}
+}
+
+func KeyByRoute(route func(req *request.Request) string) KeyFunc {
+	/*
+	* @brief: one limit per route shared by every client, the
+	* path when route is nil. route must not return values
+	* chosen by clients or the keys grow without bound
+	*/
+	return func(w *response.Writer, req *request.Request) string {
+		if route == nil {
+			return "route:" + req.Path()
+		}
+
+		return "route:" + route(req)
+	}
+}
+
+func KeyBy(keys ...KeyFunc) KeyFunc {
+	/*
+	* @brief: combines keys, e.g. a limit per client and route
+	*/
+	return func(w *response.Writer, req *request.Request) string {
+		parts := make([]string, len(keys))
+		for i, key := range keys {
+			parts[i] = key(w, req)
+			if parts[i] == "" {
+				return ""
+			}
+		}
+
+		return strings.Join(parts, "|")
+	}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
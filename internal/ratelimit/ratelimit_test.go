@@ -0,0 +1,174 @@
+package ratelimit
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+	"Servus/internal/server"
+)
+
+func TestTokenBucket(t *testing.T) {
+	s := NewMemoryStore(0)
+	policy := Policy{Algorithm: TokenBucket, Limit: 2, Window: time.Second, Burst: 3}
+	now := time.Unix(1000, 0)
+
+	// test: a full bucket allows a burst
+	for i := 0; i < 3; i++ {
+		r, err := s.Take("a", policy, now)
+		require.NoError(t, err)
+		assert.True(t, r.Allowed)
+		assert.Equal(t, 2-i, r.Remaining)
+	}
+
+	r, _ := s.Take("a", policy, now)
+	assert.False(t, r.Allowed)
+	assert.Equal(t, 500*time.Millisecond, r.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, r.Reset)
+
+	// test: tokens come back at the steady rate
+	r, _ = s.Take("a", policy, now.Add(500*time.Millisecond))
+	assert.True(t, r.Allowed)
+	r, _ = s.Take("a", policy, now.Add(500*time.Millisecond))
+	assert.False(t, r.Allowed)
+
+	// test: keys are independent
+	r, _ = s.Take("b", policy, now)
+	assert.True(t, r.Allowed)
+
+	_, err := s.Take("a", Policy{Limit: 0, Window: time.Second}, now)
+	assert.Error(t, err)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	s := NewMemoryStore(0)
+	policy := Policy{Algorithm: SlidingWindow, Limit: 4, Window: 10 * time.Second}
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 4; i++ {
+		r, _ := s.Take("a", policy, now)
+		assert.True(t, r.Allowed)
+	}
+
+	// test: the full window has to slide past enough requests
+	r, _ := s.Take("a", policy, now)
+	assert.False(t, r.Allowed)
+	assert.Equal(t, 0, r.Remaining)
+	assert.Equal(t, 12500*time.Millisecond, r.RetryAfter)
+
+	r, _ = s.Take("a", policy, now.Add(12*time.Second))
+	assert.False(t, r.Allowed)
+	assert.Equal(t, 500*time.Millisecond, r.RetryAfter)
+
+	// test: the previous window weighs 3 of 4 requests, 1 is left
+	r, _ = s.Take("a", policy, now.Add(12500*time.Millisecond))
+	assert.True(t, r.Allowed)
+	assert.Equal(t, 0, r.Remaining)
+
+	// test: the count is gone after two windows
+	r, _ = s.Take("a", policy, now.Add(40*time.Second))
+	assert.True(t, r.Allowed)
+	assert.Equal(t, 3, r.Remaining)
+}
+
+func TestEviction(t *testing.T) {
+	policy := Policy{Limit: 1, Window: time.Second}
+	now := time.Unix(1000, 0)
+
+	// test: the least recently used keys go past the limit
+	s := NewMemoryStore(2)
+	s.Take("a", policy, now)
+	s.Take("b", policy, now)
+	s.Take("a", policy, now)
+	s.Take("c", policy, now)
+	assert.Equal(t, 2, s.Len())
+	r, _ := s.Take("a", policy, now)
+	assert.False(t, r.Allowed, "a was recently used and kept")
+
+	// test: idle keys are dropped
+	s = NewMemoryStore(0)
+	for i := 0; i < 100; i++ {
+		s.Take(fmt.Sprint(i), policy, now)
+	}
+	s.Take("late", policy, now.Add(2*time.Second))
+	assert.Equal(t, 1, s.Len())
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		body := "ok"
+		w.Response = &response.Response{
+			Code:    response.CodeOK,
+			Message: []byte(body),
+			Headers: headers.GetDefaultHeaders(len(body)),
+		}
+		w.WriteResponse()
+	}
+
+	serve := func(opts Options) string {
+		s, err := server.Serve(0, response.Chain(handler, Middleware(opts)))
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+
+		return s.Addr().String()
+	}
+	send := func(addr, extra string) string {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n" + extra + "\r\n"))
+		require.NoError(t, err)
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+
+		return string(resp)
+	}
+
+	addr := serve(Options{Limit: 2, Window: time.Minute})
+
+	// test: allowed responses carry the limit headers
+	resp := send(addr, "")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"), resp)
+	assert.Contains(t, resp, "ratelimit-limit: 2\r\n")
+	assert.Contains(t, resp, "ratelimit-remaining: 1\r\n")
+	assert.Contains(t, resp, "ratelimit-reset: 30\r\n")
+	assert.Contains(t, resp, "ratelimit-policy: 2;w=60\r\n")
+	send(addr, "")
+
+	// test: rejected with a 429 and Retry-After
+	resp = send(addr, "")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 429 Too Many Requests\r\n"), resp)
+	assert.Contains(t, resp, "ratelimit-remaining: 0\r\n")
+	assert.Contains(t, resp, "retry-after: 30\r\n")
+
+	// test: API keys get their own limit, clients without one
+	// share the IP's
+	addr = serve(Options{Algorithm: SlidingWindow, Limit: 1, Window: time.Minute, Key: KeyByHeader("X-API-Key")})
+	assert.Contains(t, send(addr, "X-API-Key: alpha\r\n"), "200 OK")
+	assert.Contains(t, send(addr, "X-API-Key: alpha\r\n"), "429 Too Many Requests")
+	assert.Contains(t, send(addr, "X-API-Key: beta\r\n"), "200 OK")
+	assert.Contains(t, send(addr, ""), "200 OK")
+	assert.Contains(t, send(addr, ""), "429 Too Many Requests")
+
+	// test: empty keys are not limited
+	addr = serve(Options{Limit: 1, Window: time.Minute, Key: func(w *response.Writer, req *request.Request) string {
+		return ""
+	}})
+	send(addr, "")
+	assert.Contains(t, send(addr, ""), "200 OK")
+
+	// test: a limit or window that is not positive is refused
+	assert.Panics(t, func() { Middleware(Options{Window: time.Minute}) })
+	assert.Panics(t, func() { Middleware(Options{Limit: 10, Window: -time.Second}) })
+}
@@ -0,0 +1,254 @@
+package ratelimit
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const DefaultMaxKeys = 10000
+
+type Algorithm int
+
+const (
+	// requests spend tokens refilled at a steady rate, bursts
+	// up to the bucket size are allowed
+	TokenBucket Algorithm = iota
+	// requests are counted in fixed windows, the previous one
+	// weighted by how much of it still overlaps the sliding one
+	SlidingWindow
+)
+
+// Policy is what a store enforces for a key
+type Policy struct {
+	Algorithm Algorithm
+	// requests allowed per Window
+	Limit  int
+	Window time.Duration
+	// size of the token bucket, Limit when zero
+	Burst int
+}
+
+// Result is the decision for one request
+type Result struct {
+	Allowed bool
+	// requests left right after this one
+	Remaining int
+	// time until the full limit is available again
+	Reset time.Duration
+	// time until a request would be allowed, for rejected ones
+	RetryAfter time.Duration
+}
+
+// Store keeps the state of every key. Take must decide and record
+// atomically, a shared backend would do both in a single round trip
+type Store interface {
+	Take(key string, policy Policy, now time.Time) (Result, error)
+}
+
+// MemoryStore keeps the keys of a single process. It holds at most
+// maxKeys keys, the least recently used ones are evicted first and
+// keys that went idle are dropped as time passes
+type MemoryStore struct {
+	maxKeys int
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	lru       *list.List
+	lastSweep time.Time
+}
+
+type entry struct {
+	key    string
+	policy Policy
+	// time after which the state equals a fresh one
+	idleAt time.Time
+
+	// token bucket
+	tokens float64
+	last   time.Time
+
+	// sliding window
+	windowStart time.Time
+	previous    int
+	current     int
+}
+
+func NewMemoryStore(maxKeys int) *MemoryStore {
+	/*
+	* @brief: returns a store holding up to maxKeys keys,
+	* DefaultMaxKeys when zero
+	*/
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+
+	return &MemoryStore{
+		maxKeys: maxKeys,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+func (s *MemoryStore) Take(key string, policy Policy, now time.Time) (Result, error) {
+	if policy.Limit <= 0 || policy.Window <= 0 {
+		return Result{}, fmt.Errorf("invalid policy, limit %d per %v", policy.Limit, policy.Window)
+	}
+	if policy.Burst <= 0 {
+		policy.Burst = policy.Limit
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now, policy.Window)
+
+	// limiters sharing the store keep apart
+	id := fmt.Sprintf("%d/%d/%d/%d/%s", policy.Algorithm, policy.Limit, policy.Window, policy.Burst, key)
+
+	var e *entry
+	if el, ok := s.entries[id]; ok {
+		s.lru.MoveToFront(el)
+		e = el.Value.(*entry)
+	} else {
+		e = &entry{key: id, policy: policy}
+		s.entries[id] = s.lru.PushFront(e)
+		e.reset(now)
+
+		for s.lru.Len() > s.maxKeys {
+			s.remove(s.lru.Back())
+		}
+	}
+
+	if policy.Algorithm == SlidingWindow {
+		return e.takeWindow(now), nil
+	}
+
+	return e.takeToken(now), nil
+}
+
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lru.Len()
+}
+
+func (s *MemoryStore) sweep(now time.Time, every time.Duration) {
+	/*
+	* drops the idle keys, at most once per window so the
+	* cost stays proportional to the traffic
+	*/
+	if now.Sub(s.lastSweep) < every {
+		return
+	}
+	s.lastSweep = now
+
+	for el := s.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if !now.Before(el.Value.(*entry).idleAt) {
+			s.remove(el)
+		}
+		el = prev
+	}
+}
+
+func (s *MemoryStore) remove(el *list.Element) {
+	s.lru.Remove(el)
+	delete(s.entries, el.Value.(*entry).key)
+}
+
+func (e *entry) reset(now time.Time) {
+	e.tokens = float64(e.policy.Burst)
+	e.last = now
+	e.windowStart = now.Truncate(e.policy.Window)
+	e.previous = 0
+	e.current = 0
+}
+
+func (e *entry) takeToken(now time.Time) Result {
+	p := e.policy
+	rate := float64(p.Limit) / p.Window.Seconds()
+
+	elapsed := now.Sub(e.last).Seconds()
+	if elapsed > 0 {
+		e.tokens = math.Min(float64(p.Burst), e.tokens+elapsed*rate)
+		e.last = now
+	}
+
+	var result Result
+	if e.tokens >= 1 {
+		e.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - e.tokens) / rate)
+	}
+
+	result.Remaining = int(e.tokens)
+	result.Reset = seconds((float64(p.Burst) - e.tokens) / rate)
+	e.idleAt = now.Add(result.Reset)
+
+	return result
+}
+
+func (e *entry) takeWindow(now time.Time) Result {
+	p := e.policy
+
+	start := now.Truncate(p.Window)
+	switch {
+	case start.Sub(e.windowStart) >= 2*p.Window:
+		e.previous, e.current = 0, 0
+	case start.After(e.windowStart):
+		e.previous, e.current = e.current, 0
+	}
+	e.windowStart = start
+
+	elapsed := now.Sub(start)
+	overlap := 1 - float64(elapsed)/float64(p.Window)
+	estimate := float64(e.previous)*overlap + float64(e.current)
+
+	var result Result
+	if estimate+1 <= float64(p.Limit) {
+		e.current++
+		estimate++
+		result.Allowed = true
+	} else {
+		result.RetryAfter = e.retryAfter(elapsed)
+	}
+
+	result.Remaining = max(0, int(float64(p.Limit)-math.Ceil(estimate)))
+	// the current count weighs on the next window too
+	result.Reset = 2*p.Window - elapsed
+	if e.current == 0 {
+		result.Reset = p.Window - elapsed
+	}
+	e.idleAt = now.Add(result.Reset)
+
+	return result
+}
+
+func (e *entry) retryAfter(elapsed time.Duration) time.Duration {
+	/*
+	* time until the weighted count leaves room for one request,
+	* within this window if the previous one still weighs enough
+	* and in the next one otherwise
+	*/
+	p := e.policy
+	window := float64(p.Window)
+	room := float64(p.Limit - 1)
+
+	if e.current <= p.Limit-1 && e.previous > 0 {
+		at := window * (1 - (room-float64(e.current))/float64(e.previous))
+		return time.Duration(at) - elapsed
+	}
+
+	at := window * (1 - room/float64(e.current))
+
+	return p.Window - elapsed + time.Duration(at)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
@@ -0,0 +1,406 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"strings"
+
+	"Servus/internal/headers"
+)
+
+var (
+	ErrNotForm         = errors.New("request body is not a form")
+	ErrFormTooLarge    = errors.New("form body too large")
+	ErrTooManyParts    = errors.New("multipart form has too many parts")
+	ErrPartTooLarge    = errors.New("multipart part too large")
+	ErrMissingBoundary = errors.New("multipart form has no boundary")
+)
+
+// FormLimits bound what parsing a form keeps, the body itself is
+// already in memory, within the server's Config.MaxBodySize. zero
+// fields take the value of DefaultFormLimits
+type FormLimits struct {
+	// bytes of file content kept in memory, files past it spill
+	// to disk. every file does when negative
+	MaxMemory int64
+	MaxParts  int
+	// size of a single non-file value
+	MaxValueSize int64
+	// size of a single file
+	MaxFileSize int64
+	// size of a url-encoded body
+	MaxFormSize int64
+}
+
+var DefaultFormLimits = FormLimits{
+	MaxMemory:    32 << 20,
+	MaxParts:     1000,
+	MaxValueSize: 1 << 20,
+	MaxFileSize:  256 << 20,
+	MaxFormSize:  10 << 20,
+}
+
+type FileHeader struct {
+	Filename string
+	Headers  headers.Headers
+	Size     int64
+	content  []byte
+	tmpFile  string
+}
+
+type MultipartForm struct {
+	Value map[string][]string
+	File  map[string][]*FileHeader
+}
+
+func (fh *FileHeader) Open() (io.ReadCloser, error) {
+	if fh.tmpFile != "" {
+		return os.Open(fh.tmpFile)
+	}
+
+	return io.NopCloser(bytes.NewReader(fh.content)), nil
+}
+
+func (f *MultipartForm) RemoveAll() error {
+	/*
+	* @brief: deletes the temporary files backing the form
+	*/
+	var errs []error
+	for _, files := range f.File {
+		for _, fh := range files {
+			if fh.tmpFile == "" {
+				continue
+			}
+
+			err := os.Remove(fh.tmpFile)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r *Request) Path() string {
+	/*
+	* returns the path of the request target, the scheme and
+	* authority of absolute-form targets are left out
+	*/
+	path, _, _ := strings.Cut(r.RequestLine.RequestTarget, "?")
+	if strings.HasPrefix(path, "/") {
+		return path
+	}
+
+	_, rest, found := strings.Cut(path, "://")
+	if !found {
+		return path
+	}
+
+	i := strings.Index(rest, "/")
+	if i < 0 {
+		return "/"
+	}
+
+	return rest[i:]
+}
+
+func (r *Request) Query() url.Values {
+	/*
+	* returns the parsed query string of the request target,
+	* malformed pairs are skipped
+	*/
+	_, rawQuery, _ := strings.Cut(r.RequestLine.RequestTarget, "?")
+	query, _ := url.ParseQuery(rawQuery)
+
+	return query
+}
+
+func (r *Request) ParseForm() error {
+	/*
+	* @brief: fills r.Form with the query parameters and, for
+	* url-encoded bodies, r.PostForm with the body values
+	*
+	* body values come before query values in r.Form
+	*/
+	return r.parseForm(DefaultFormLimits)
+}
+
+func (r *Request) ParseMultipartForm(limits FormLimits) error {
+	/*
+	* @brief: parses a 'multipart/form-data' body into r.MultipartForm
+	*
+	* values are merged into r.Form and r.PostForm as well. file
+	* contents go to temp files once limits.MaxMemory is exceeded,
+	* r.Body stays in memory regardless: spilling only keeps the
+	* form from holding a second copy of it
+	*/
+	if r.MultipartForm != nil {
+		return nil
+	}
+
+	limits = limits.withDefaults()
+
+	err := r.parseForm(limits)
+	if err != nil {
+		return err
+	}
+
+	boundary, err := r.multipartBoundary()
+	if err != nil {
+		return err
+	}
+
+	form, err := readMultipartForm(multipart.NewReader(bytes.NewReader(r.Body), boundary), limits)
+	if err != nil {
+		return err
+	}
+
+	for key, values := range form.Value {
+		r.Form[key] = append(append([]string{}, values...), r.Form[key]...)
+		r.PostForm[key] = append(r.PostForm[key], values...)
+	}
+
+	r.MultipartForm = form
+
+	return nil
+}
+
+func (r *Request) FormValue(key string) string {
+	/*
+	* @brief: returns the first value for key in r.Form, parsing
+	* the form with DefaultFormLimits if needed
+	*
+	* parse errors are not reported, values that could not be
+	* parsed are just missing. call ParseMultipartForm or
+	* ParseForm first to get them
+	*/
+	if r.Form == nil {
+		// the plain form is parsed first, so r.Form is set
+		// even when the body is not multipart
+		r.ParseMultipartForm(DefaultFormLimits)
+	}
+
+	return r.Form.Get(key)
+}
+
+func (r *Request) FormFile(key string) (*FileHeader, error) {
+	if r.MultipartForm == nil {
+		err := r.ParseMultipartForm(DefaultFormLimits)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	files := r.MultipartForm.File[key]
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file for form key %q", key)
+	}
+
+	return files[0], nil
+}
+
+func (l FormLimits) withDefaults() FormLimits {
+	if l.MaxMemory == 0 {
+		l.MaxMemory = DefaultFormLimits.MaxMemory
+	}
+	if l.MaxParts == 0 {
+		l.MaxParts = DefaultFormLimits.MaxParts
+	}
+	if l.MaxValueSize == 0 {
+		l.MaxValueSize = DefaultFormLimits.MaxValueSize
+	}
+	if l.MaxFileSize == 0 {
+		l.MaxFileSize = DefaultFormLimits.MaxFileSize
+	}
+	if l.MaxFormSize == 0 {
+		l.MaxFormSize = DefaultFormLimits.MaxFormSize
+	}
+
+	return l
+}
+
+func (r *Request) parseForm(limits FormLimits) error {
+	if r.Form != nil {
+		return nil
+	}
+
+	r.PostForm = url.Values{}
+	r.Form = r.Query()
+
+	contentType, _ := r.Headers.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "application/x-www-form-urlencoded" {
+		return nil
+	}
+
+	if int64(len(r.Body)) > limits.MaxFormSize {
+		return ErrFormTooLarge
+	}
+
+	values, err := url.ParseQuery(string(r.Body))
+	if err != nil {
+		return fmt.Errorf("failed to parse form body: %v", err)
+	}
+
+	for key, vals := range values {
+		r.PostForm[key] = vals
+		r.Form[key] = append(append([]string{}, vals...), r.Form[key]...)
+	}
+
+	return nil
+}
+
+func (r *Request) multipartBoundary() (string, error) {
+	contentType, _ := r.Headers.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		return "", ErrNotForm
+	}
+
+	boundary, ok := params["boundary"]
+	if !ok || boundary == "" {
+		return "", ErrMissingBoundary
+	}
+
+	return boundary, nil
+}
+
+func readMultipartForm(mr *multipart.Reader, limits FormLimits) (*MultipartForm, error) {
+	form := &MultipartForm{
+		Value: map[string][]string{},
+		File:  map[string][]*FileHeader{},
+	}
+
+	err := readParts(mr, form, limits)
+	if err != nil {
+		// drop the files written before the failure
+		form.RemoveAll()
+		return nil, err
+	}
+
+	return form, nil
+}
+
+func readParts(mr *multipart.Reader, form *MultipartForm, limits FormLimits) error {
+	memoryLeft := limits.MaxMemory
+	for parts := 0; ; parts++ {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read multipart part: %v", err)
+		}
+
+		if parts >= limits.MaxParts {
+			part.Close()
+			return ErrTooManyParts
+		}
+
+		name := part.FormName()
+		if name == "" {
+			part.Close()
+			continue
+		}
+
+		if part.FileName() == "" {
+			value, err := readLimited(part, limits.MaxValueSize)
+			part.Close()
+			if err != nil {
+				return err
+			}
+
+			form.Value[name] = append(form.Value[name], string(value))
+			continue
+		}
+
+		fh, err := readFilePart(part, limits.MaxFileSize, memoryLeft)
+		part.Close()
+		if err != nil {
+			return err
+		}
+
+		if fh.tmpFile == "" {
+			memoryLeft -= fh.Size
+		}
+
+		form.File[name] = append(form.File[name], fh)
+	}
+}
+
+func readFilePart(part *multipart.Part, maxSize, memoryLeft int64) (*FileHeader, error) {
+	/*
+	* keeps the file in memory while it fits memoryLeft, otherwise
+	* the buffered prefix and the rest of the part go to a temp file
+	*/
+	fh := &FileHeader{
+		Filename: part.FileName(),
+		Headers:  headers.Headers{},
+	}
+
+	for key, values := range part.Header {
+		for _, val := range values {
+			fh.Headers.Add(key, val)
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, part, memoryLeft+1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read multipart file: %v", err)
+	}
+
+	if n > maxSize {
+		return nil, ErrPartTooLarge
+	}
+
+	if n <= memoryLeft {
+		fh.content = buf.Bytes()
+		fh.Size = n
+		return fh, nil
+	}
+
+	file, err := os.CreateTemp("", "servus-multipart-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer file.Close()
+
+	fh.tmpFile = file.Name()
+
+	// +1 to detect files over the limit
+	size, err := io.Copy(file, io.LimitReader(io.MultiReader(&buf, part), maxSize+1))
+	if err != nil {
+		os.Remove(fh.tmpFile)
+		return nil, fmt.Errorf("failed to write multipart file: %v", err)
+	}
+
+	if size > maxSize {
+		os.Remove(fh.tmpFile)
+		return nil, ErrPartTooLarge
+	}
+
+	fh.Size = size
+
+	return fh, nil
+}
+
+func readLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read multipart value: %v", err)
+	}
+
+	if int64(len(data)) > maxSize {
+		return nil, ErrPartTooLarge
+	}
+
+	return data, nil
+}
@@ -0,0 +1,134 @@
+package request
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func multipartBody(fileContent string) string {
+	return "--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n" +
+		"\r\n" +
+		"report\r\n" +
+		"--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"attachment\"; filename=\"notes.txt\"\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		fileContent + "\r\n" +
+		"--xyz--\r\n"
+}
+
+func formRequest(t *testing.T, target, contentType, body string) *Request {
+	reader := &chunkReader{
+		data: "POST " + target + " HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Type: " + contentType + "\r\n" +
+			fmt.Sprintf("Content-Length: %d\r\n", len(body)) +
+			"\r\n" +
+			body,
+		numBytesPerRead: 7,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+
+	return r
+}
+
+func TestURLEncodedForm(t *testing.T) {
+	// test: body values come before query values
+	r := formRequest(t, "/submit?name=query&page=2", "application/x-www-form-urlencoded", "name=body&flavour=dark+roast")
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, []string{"body", "query"}, r.Form["name"])
+	assert.Equal(t, "2", r.FormValue("page"))
+	assert.Equal(t, "dark roast", r.FormValue("flavour"))
+	assert.Equal(t, "dark roast", r.PostForm.Get("flavour"))
+	assert.Empty(t, r.PostForm.Get("page"))
+	assert.Equal(t, "/submit", r.Path())
+
+	// test: form over the size limit
+	r = formRequest(t, "/submit", "application/x-www-form-urlencoded", "name=body")
+	limits := DefaultFormLimits
+	limits.MaxFormSize = 4
+	require.ErrorIs(t, r.ParseMultipartForm(limits), ErrFormTooLarge)
+
+	// test: zero limits take the defaults
+	r = formRequest(t, "/submit", "application/x-www-form-urlencoded", "name=body")
+	require.ErrorIs(t, r.ParseMultipartForm(FormLimits{}), ErrNotForm)
+	assert.Equal(t, "body", r.PostForm.Get("name"))
+
+	// test: FormValue leaves out what could not be parsed
+	r = formRequest(t, "/submit?page=2", "application/x-www-form-urlencoded", "name=%zz")
+	assert.Equal(t, "", r.FormValue("name"))
+	assert.Equal(t, "2", r.FormValue("page"))
+}
+
+func TestMultipartForm(t *testing.T) {
+	// test: file kept in memory
+	r := formRequest(t, "/upload?id=7", "multipart/form-data; boundary=xyz", multipartBody("hello world"))
+	require.NoError(t, r.ParseMultipartForm(DefaultFormLimits))
+	assert.Equal(t, "report", r.FormValue("title"))
+	assert.Equal(t, "7", r.FormValue("id"))
+
+	fh, err := r.FormFile("attachment")
+	require.NoError(t, err)
+	assert.Equal(t, "notes.txt", fh.Filename)
+	assert.Equal(t, int64(11), fh.Size)
+	contentType, _ := fh.Headers.Get("Content-Type")
+	assert.Equal(t, "text/plain", contentType)
+	assert.Empty(t, fh.tmpFile)
+
+	// test: file spilled to disk
+	r = formRequest(t, "/upload", "multipart/form-data; boundary=xyz", multipartBody("hello world"))
+	limits := DefaultFormLimits
+	limits.MaxMemory = 4
+	require.NoError(t, r.ParseMultipartForm(limits))
+
+	fh, err = r.FormFile("attachment")
+	require.NoError(t, err)
+	require.NotEmpty(t, fh.tmpFile)
+	f, err := fh.Open()
+	require.NoError(t, err)
+	content, err := io.ReadAll(f)
+	f.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(content))
+
+	require.NoError(t, r.MultipartForm.RemoveAll())
+	_, err = fh.Open()
+	require.Error(t, err)
+
+	// test: file over the size limit
+	r = formRequest(t, "/upload", "multipart/form-data; boundary=xyz", multipartBody("hello world"))
+	limits = DefaultFormLimits
+	limits.MaxFileSize = 5
+	require.ErrorIs(t, r.ParseMultipartForm(limits), ErrPartTooLarge)
+
+	// test: too many parts
+	r = formRequest(t, "/upload", "multipart/form-data; boundary=xyz", multipartBody("hello world"))
+	limits = DefaultFormLimits
+	limits.MaxParts = 1
+	require.ErrorIs(t, r.ParseMultipartForm(limits), ErrTooManyParts)
+
+	// test: zero limits take the defaults
+	r = formRequest(t, "/upload", "multipart/form-data; boundary=xyz", multipartBody("hello world"))
+	require.NoError(t, r.ParseMultipartForm(FormLimits{MaxParts: 5}))
+	fh, err = r.FormFile("attachment")
+	require.NoError(t, err)
+	assert.Empty(t, fh.tmpFile)
+
+	// test: a negative memory limit spills every file
+	r = formRequest(t, "/upload", "multipart/form-data; boundary=xyz", multipartBody("hello world"))
+	require.NoError(t, r.ParseMultipartForm(FormLimits{MaxMemory: -1}))
+	fh, err = r.FormFile("attachment")
+	require.NoError(t, err)
+	assert.NotEmpty(t, fh.tmpFile)
+	require.NoError(t, r.MultipartForm.RemoveAll())
+
+	// test: not a multipart body
+	r = formRequest(t, "/upload", "application/json", "{}")
+	require.ErrorIs(t, r.ParseMultipartForm(DefaultFormLimits), ErrNotForm)
+}
@@ -1,9 +1,13 @@
 package request
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -19,18 +23,100 @@ const (
 	stateDone
 )
 
+type pathValuesKey struct{}
+
 type RequestLine struct {
 	HttpVersion   string
 	RequestTarget string
 	Method        string
 }
 
+// ErrBodyTooLarge is wrapped by the ParseError of a request whose
+// 'Content-Length' is over the limit given to RequestFromReaderLimit
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// ParseError tells which part of a request could not be parsed
+type ParseError struct {
+	// "request_line", "header", "body" or "incomplete"
+	Kind string
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
 type Request struct {
 	RequestLine RequestLine
 	Headers headers.Headers
 	parserState parserStateType
 	Body []byte
 	contentLength int
+	// longest 'Content-Length' accepted, zero means no limit
+	maxBodySize int
+	// query and body form values, filled by ParseForm
+	Form url.Values
+	PostForm url.Values
+	MultipartForm *MultipartForm
+	// set on HTTPS connections, verified client
+	// certificates are in TLS.PeerCertificates
+	TLS *tls.ConnectionState
+	ctx context.Context
+	// bytes read from the connection past the end of the request
+	buffered []byte
+}
+
+func (r *Request) Context() context.Context {
+	/*
+	* @brief: returns the request context, the server cancels it
+	* when the client goes away, the write timeout fires or the
+	* server is closed
+	*/
+	if r.ctx == nil {
+		return context.Background()
+	}
+
+	return r.ctx
+}
+
+func (r *Request) SetContext(ctx context.Context) {
+	/*
+	* @brief: replaces the request context, used by middlewares
+	* to attach request scoped values
+	*/
+	r.ctx = ctx
+}
+
+func (r *Request) SetPathValue(name, value string) {
+	/*
+	* @brief: records a route parameter matched by a router,
+	* it is carried by the request context
+	*/
+	params := map[string]string{name: value}
+	if old, ok := r.Context().Value(pathValuesKey{}).(map[string]string); ok {
+		// copied so contexts derived earlier keep their values
+		for k, v := range old {
+			if k != name {
+				params[k] = v
+			}
+		}
+	}
+
+	r.ctx = context.WithValue(r.Context(), pathValuesKey{}, params)
+}
+
+func (r *Request) PathValue(name string) string {
+	/*
+	* @brief: returns the route parameter name, empty if the
+	* route did not match one
+	*/
+	params, _ := r.Context().Value(pathValuesKey{}).(map[string]string)
+
+	return params[name]
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -41,7 +127,7 @@ func (r *Request) parse(data []byte) (int, error) {
 	for r.parserState != stateDone {
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
-			return 0, err
+			return 0, &ParseError{Kind: r.parserState.kind(), Err: err}
 		}
 		
 		totalBytesParsed += n
@@ -53,6 +139,17 @@ func (r *Request) parse(data []byte) (int, error) {
 	return totalBytesParsed, nil
 }
 
+func (s parserStateType) kind() string {
+	switch s {
+	case stateInitialized:
+		return "request_line"
+	case stateParsingHeaders:
+		return "header"
+	}
+
+	return "body"
+}
+
 func (r *Request) parseSingle(data []byte) (int, error) {
 	switch r.parserState {
 	case stateInitialized:
@@ -81,6 +178,14 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 				if err != nil {
 					return 0, fmt.Errorf("failed to parse 'Content-length' header value: %v", err)
 				}
+				if cLength < 0 {
+					return 0, fmt.Errorf("negative 'Content-Length' header value")
+				}
+				if r.maxBodySize > 0 && cLength > r.maxBodySize {
+					// refused before reading any of it
+					r.parserState = stateParsingBody
+					return 0, ErrBodyTooLarge
+				}
 				r.Body = make([]byte, 0, cLength)
 				r.contentLength = cLength
 			}
@@ -120,6 +225,14 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	}
 }
 
+func (r *Request) Buffered() []byte {
+	/*
+	* returns the bytes RequestFromReader read past the end of
+	* the request, they belong to whatever follows on the connection
+	*/
+	return r.buffered
+}
+
 func (r *Request) PrintRequest() {
 	fmt.Println("Request line:")
 	fmt.Printf("- Method: %s\n", r.RequestLine.Method)
@@ -134,6 +247,15 @@ func (r *Request) PrintRequest() {
 }
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	return RequestFromReaderLimit(reader, 0)
+}
+
+func RequestFromReaderLimit(reader io.Reader, maxBodySize int) (*Request, error) {
+	/*
+	* @brief: like RequestFromReader, a 'Content-Length' over
+	* maxBodySize fails with ErrBodyTooLarge before the body is
+	* read. zero means no limit
+	*/
 	const buffSize = 8
 	buffer := make([]byte, buffSize)
 	readToIndex := 0
@@ -141,6 +263,7 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		parserState: stateInitialized,
 		Headers: headers.Headers{},
 		Body: make([]byte, 0),
+		maxBodySize: maxBodySize,
 	}
 	
 	for reqStruct.parserState != stateDone {
@@ -152,7 +275,7 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				if reqStruct.parserState != stateDone {
-					return nil, fmt.Errorf("incomplete request")
+					return nil, &ParseError{Kind: "incomplete", Err: fmt.Errorf("incomplete request")}
 				}
 
 				break
@@ -173,9 +296,38 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		readToIndex -= parsedBytes
 	}
 
+	reqStruct.buffered = append([]byte{}, buffer[:readToIndex]...)
+
 	return reqStruct, nil
 }
 
+func ValidateRequestLine(method, target string) error {
+	/*
+	* @brief: checks the method and the request target, HTTP/2
+	* requests carry them as pseudo headers
+	*/
+	if !isMethodValid(method) {
+		return fmt.Errorf("invalid http method")
+	}
+
+	// controls and spaces could split the line once written again
+	invalid := func(ch rune) bool { return ch <= ' ' || ch == 0x7f }
+	if target == "" || strings.ContainsFunc(target, invalid) {
+		return fmt.Errorf("invalid target")
+	}
+
+	// CONNECT names the host to tunnel to in authority-form,
+	// 'host:port' and nothing else
+	if method == "CONNECT" {
+		host, port, err := net.SplitHostPort(target)
+		if err != nil || host == "" || port == "" || strings.Contains(target, "/") {
+			return fmt.Errorf("invalid target")
+		}
+	}
+
+	return nil
+}
+
 func isMethodValid(method string) bool {
 	httpMethods := map[string]bool {
 		"GET": true,
@@ -183,7 +335,9 @@ func isMethodValid(method string) bool {
 		"POST": true,
 		"PUT": true,
 		"DELETE": true,
+		"OPTIONS": true,
 		"TRACE": true,
+		"CONNECT": true,
 	}
 
 	_, ok := httpMethods[method]
@@ -218,17 +372,13 @@ func parseRequestLine(reqLineByteSlice []byte) (*RequestLine, int, error) {
 
 	reqLineStruct := RequestLine{}
 	method := reqLineParts[0]
-	if !isMethodValid(method) {
-		return nil, 0, fmt.Errorf("invalid http method")
-	}
-
-	reqLineStruct.Method = method
-
 	target := reqLineParts[1]
-	if strings.Contains(target, " ") {
-		return nil, 0, fmt.Errorf("invalid target")
+	err := ValidateRequestLine(method, target)
+	if err != nil {
+		return nil, 0, err
 	}
 
+	reqLineStruct.Method = method
 	reqLineStruct.RequestTarget = target
 
 	httpVersion := reqLineParts[2]
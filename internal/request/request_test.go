@@ -44,6 +44,45 @@ func TestRequestLineParser(t *testing.T) {
 	require.Equal(t, "/coffee", r.RequestLine.RequestTarget)
 	require.Equal(t, "1.1", r.RequestLine.HttpVersion)
 
+	// test: absolute-form target
+	reader = &chunkReader{
+		data: "GET http://example.com/coffee?size=l HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.Equal(t, "http://example.com/coffee?size=l", r.RequestLine.RequestTarget)
+	require.Equal(t, "/coffee", r.Path())
+	require.Equal(t, "l", r.Query().Get("size"))
+
+	// test: CONNECT with authority-form target
+	reader = &chunkReader{
+		data: "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.Equal(t, "CONNECT", r.RequestLine.Method)
+	require.Equal(t, "example.com:443", r.RequestLine.RequestTarget)
+
+	// test: CONNECT without a port
+	reader = &chunkReader{
+		data: "CONNECT example.com HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// test: OPTIONS, also in asterisk-form
+	reader = &chunkReader{
+		data: "OPTIONS * HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.Equal(t, "OPTIONS", r.RequestLine.Method)
+	require.Equal(t, "*", r.RequestLine.RequestTarget)
+
 	// test: invalid number of parts in request line
 	reader = &chunkReader{
 		data: "/coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
@@ -178,4 +217,48 @@ func TestBodyParser(t *testing.T) {
 	}
 	_, err = RequestFromReader(reader)
 	require.Error(t, err)
+
+	// test: 'Content-Length' over the limit, refused before the body
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost: 42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReaderLimit(reader, 12)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	require.Equal(t, "body", parseErr.Kind)
+
+	// test: negative 'Content-Length'
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost: 42069\r\n" +
+			"Content-Length: -1\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestPathValues(t *testing.T) {
+	req := &Request{}
+	require.Equal(t, "", req.PathValue("id"))
+
+	// test: values are carried by the context
+	req.SetPathValue("id", "7")
+	before := req.Context()
+	req.SetPathValue("name", "ada")
+	req.SetPathValue("id", "8")
+	require.Equal(t, "8", req.PathValue("id"))
+	require.Equal(t, "ada", req.PathValue("name"))
+
+	// test: contexts taken earlier are not changed
+	earlier := &Request{}
+	earlier.SetContext(before)
+	require.Equal(t, "7", earlier.PathValue("id"))
+	require.Equal(t, "", earlier.PathValue("name"))
 }
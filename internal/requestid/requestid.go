@@ -0,0 +1,201 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+const (
+	DefaultHeader = "X-Request-ID"
+
+	// longer incoming IDs are replaced
+	maxLength = 128
+	// Crockford's base32, it leaves out I, L, O and U
+	encoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+type contextKey struct{}
+
+type Options struct {
+	// header read from requests and echoed in responses,
+	// DefaultHeader when empty
+	Header string
+	// always generates a fresh ID, for servers that should not
+	// trust what clients send
+	IgnoreIncoming bool
+	// NewID when nil
+	Generate func() string
+}
+
+var generator struct {
+	mu   sync.Mutex
+	ms   uint64
+	rand [10]byte
+}
+
+func Middleware(opts Options) response.Middleware {
+	/*
+	* @brief: gives every request an ID, taken from the request
+	* header or the 'traceparent' trace ID when present
+	*
+	* the ID is put on the request context, on the request headers
+	* so proxied requests carry it along, on the writer for error
+	* responses and in the response headers
+	*/
+	header := opts.Header
+	if header == "" {
+		header = DefaultHeader
+	}
+
+	generate := opts.Generate
+	if generate == nil {
+		generate = NewID
+	}
+
+	return func(next response.Handler) response.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			id := ""
+			if !opts.IgnoreIncoming {
+				id = incoming(req, header)
+			}
+			if id == "" {
+				id = generate()
+			}
+
+			req.SetContext(context.WithValue(req.Context(), contextKey{}, id))
+			req.Headers.AddOverride(header, id)
+			w.RequestID = id
+			w.OnWriteHeaders(func(h headers.Headers) {
+				h.AddOverride(header, id)
+			})
+
+			next(w, req)
+		}
+	}
+}
+
+func FromContext(ctx context.Context) string {
+	/*
+	* returns the ID attached by Middleware, empty if the
+	* middleware is not in the handler chain
+	*/
+	id, _ := ctx.Value(contextKey{}).(string)
+
+	return id
+}
+
+func FromRequest(req *request.Request) string {
+	return FromContext(req.Context())
+}
+
+func NewID() string {
+	/*
+	* @brief: returns a ULID, 48 bits of milliseconds followed by
+	* 80 random bits in 26 base32 characters
+	*
+	* IDs sort by creation time, within a millisecond the random
+	* part is incremented so they keep their order
+	*/
+	generator.mu.Lock()
+	defer generator.mu.Unlock()
+
+	ms := uint64(time.Now().UnixMilli())
+	if ms > generator.ms {
+		generator.ms = ms
+		rand.Read(generator.rand[:])
+	} else if !increment(generator.rand[:]) {
+		// the random part overflowed, borrow the next millisecond
+		generator.ms++
+		rand.Read(generator.rand[:])
+	}
+
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], generator.ms<<16)
+	copy(id[6:], generator.rand[:])
+
+	return encode(id)
+}
+
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func encode(id [16]byte) string {
+	/*
+	* 128 bits in 26 characters of 5 bits, the first character
+	* only holds the top 3 bits
+	*/
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = encoding[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+
+	return string(out)
+}
+
+func incoming(req *request.Request, header string) string {
+	id, _ := req.Headers.Get(header)
+	id = strings.TrimSpace(id)
+	if valid(id) {
+		return id
+	}
+
+	// traceparent: version-traceid-parentid-flags
+	traceparent, _ := req.Headers.Get("traceparent")
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) == 4 && len(parts[1]) == 32 && isLowerHex(parts[1]) && strings.Trim(parts[1], "0") != "" {
+		return parts[1]
+	}
+
+	return ""
+}
+
+func valid(id string) bool {
+	/*
+	* IDs end up in logs and headers, only a conservative
+	* set of characters is accepted
+	*/
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+
+	for _, ch := range id {
+		if !((ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') ||
+			(ch >= '0' && ch <= '9') || strings.ContainsRune("-_.:+=/", ch)) {
+
+			return false
+		}
+	}
+
+	return true
+}
+
+func isLowerHex(s string) bool {
+	for _, ch := range s {
+		if !((ch >= '0' && ch <= '9') || (ch >= 'a' && ch <= 'f')) {
+			return false
+		}
+	}
+
+	return true
+}
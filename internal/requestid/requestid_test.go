@@ -0,0 +1,123 @@
+package requestid
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Servus/internal/content"
+	"Servus/internal/request"
+	"Servus/internal/response"
+	"Servus/internal/server"
+)
+
+func serve(t *testing.T, opts Options) string {
+	handler := func(w *response.Writer, req *request.Request) {
+		if req.Path() == "/fail" {
+			content.WriteProblem(w, content.NewProblem(response.CodeBadRequest, "broken"))
+		} else {
+			content.JSON(w, response.CodeOK, map[string]string{
+				"context": FromRequest(req),
+				"header":  req.Headers["x-request-id"],
+			})
+		}
+		w.WriteResponse()
+	}
+
+	s, err := server.Serve(0, response.Chain(handler, Middleware(opts)))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	return fmt.Sprintf("127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+}
+
+func send(t *testing.T, addr, raw string) string {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	return string(resp)
+}
+
+func TestNewID(t *testing.T) {
+	ids := make([]string, 1000)
+	for i := range ids {
+		ids[i] = NewID()
+	}
+
+	// test: IDs are unique, sortable and ULID shaped
+	assert.True(t, sort.StringsAreSorted(ids))
+	seen := map[string]bool{}
+	for _, id := range ids {
+		assert.Len(t, id, 26)
+		assert.Equal(t, "", strings.Trim(id, encoding))
+		assert.False(t, seen[id])
+		seen[id] = true
+	}
+
+	// test: the encoding of known values
+	assert.Equal(t, "00000000000000000000000000", encode([16]byte{}))
+	var max [16]byte
+	for i := range max {
+		max[i] = 0xff
+	}
+	assert.Equal(t, "7ZZZZZZZZZZZZZZZZZZZZZZZZZ", encode(max))
+
+	// test: the random part carries over
+	b := []byte{0x00, 0xff, 0xff}
+	assert.True(t, increment(b))
+	assert.Equal(t, []byte{0x01, 0x00, 0x00}, b)
+	b = []byte{0xff, 0xff}
+	assert.False(t, increment(b))
+}
+
+func TestMiddleware(t *testing.T) {
+	addr := serve(t, Options{})
+
+	// test: an ID is generated when missing
+	resp := send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	_, after, found := strings.Cut(resp, "x-request-id: ")
+	require.True(t, found, resp)
+	id := after[:26]
+	assert.Contains(t, resp, `"context":"`+id+`"`)
+	assert.Contains(t, resp, `"header":"`+id+`"`)
+
+	// test: incoming IDs are kept
+	resp = send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-ID: abc-123\r\n\r\n")
+	assert.Contains(t, resp, "x-request-id: abc-123\r\n")
+	assert.Contains(t, resp, `"context":"abc-123"`)
+
+	// test: the traceparent trace ID is used
+	resp = send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n"+
+		"traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n\r\n")
+	assert.Contains(t, resp, "x-request-id: 4bf92f3577b34da6a3ce929d0e0e4736\r\n")
+
+	// test: invalid IDs are replaced
+	resp = send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-ID: <script>\r\n"+
+		"traceparent: 00-00000000000000000000000000000000-00f067aa0ba902b7-01\r\n\r\n")
+	assert.NotContains(t, resp, "<script>")
+	assert.Regexp(t, `x-request-id: [0-9A-Z]{26}\r\n`, resp)
+
+	// test: error responses carry the ID
+	resp = send(t, addr, "GET /fail HTTP/1.1\r\nHost: localhost\r\nX-Request-ID: abc-123\r\n\r\n")
+	assert.Contains(t, resp, `"request_id":"abc-123"`)
+
+	// test: custom header, incoming IDs ignored
+	addr = serve(t, Options{Header: "X-Correlation-ID", IgnoreIncoming: true, Generate: func() string { return "fixed" }})
+	resp = send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Correlation-ID: abc-123\r\n\r\n")
+	assert.Contains(t, resp, "x-correlation-id: fixed\r\n")
+	assert.Contains(t, resp, `"context":"fixed"`)
+}
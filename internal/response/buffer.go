@@ -0,0 +1,172 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+
+	"Servus/internal/headers"
+)
+
+var ErrBufferFlushed = errors.New("response buffer already flushed")
+
+// Buffer holds a response in memory until it is flushed to the
+// writer it was created for or discarded. Handlers write to the
+// writer returned by Writer as usual, possibly from another
+// goroutine than the one flushing or discarding
+type Buffer struct {
+	parent *Writer
+	writer Writer
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+	// writes go straight to the connection once flushed
+	flushed bool
+	// returned by writes once discarded
+	discarded error
+}
+
+// bufferConn is the connection of the buffered writer
+type bufferConn struct {
+	net.Conn
+	b *Buffer
+}
+
+func NewBuffer(parent *Writer) *Buffer {
+	/*
+	* @brief: returns a buffer for parent, nothing must be written
+	* to parent until the buffer is flushed or discarded
+	*
+	* the buffered writer runs the header hooks and sends the
+	* cookies registered on parent so far, parent keeps them in
+	* case the buffer is discarded and another response is written
+	*/
+	b := &Buffer{parent: parent}
+	b.writer = Writer{
+		Status:     StatusWriteResponseLine,
+		Connection: &bufferConn{Conn: parent.Connection, b: b},
+		cookies:    append([]string{}, parent.cookies...),
+		RequestID:  parent.RequestID,
+	}
+	for _, hook := range parent.headerHooks {
+		b.writer.headerHooks = append(b.writer.headerHooks, b.wrapHook(hook))
+	}
+
+	if parent.Hijacker != nil {
+		b.writer.Hijacker = b.hijack
+	}
+
+	return b
+}
+
+func (b *Buffer) Writer() *Writer {
+	return &b.writer
+}
+
+func (b *Buffer) Flush() error {
+	/*
+	* @brief: sends what was buffered through parent, later writes
+	* go straight to the connection. it must not be called while
+	* the handler may still write
+	*/
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.discarded != nil {
+		return b.discarded
+	}
+	if b.flushed {
+		return ErrBufferFlushed
+	}
+
+	return b.flushLocked()
+}
+
+func (b *Buffer) Discard(err error) bool {
+	/*
+	* @brief: drops what was buffered, the writes that follow fail
+	* with err. returns false if the buffer was flushed already,
+	* by Flush or because the handler hijacked the connection
+	*/
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.flushed {
+		return false
+	}
+
+	b.discarded = err
+	b.buf.Reset()
+
+	return true
+}
+
+func (b *Buffer) flushLocked() error {
+	b.flushed = true
+
+	_, err := b.parent.Connection.Write(b.buf.Bytes())
+	b.buf.Reset()
+
+	// the parent went through the same states
+	b.parent.Status = b.writer.Status
+	b.parent.code = b.writer.code
+	b.parent.written = b.writer.written
+	b.parent.cookies = nil
+	b.parent.headerHooks = nil
+
+	return err
+}
+
+func (b *Buffer) wrapHook(hook func(h headers.Headers)) func(h headers.Headers) {
+	/*
+	* hooks of the parent must not run once the buffer is
+	* discarded, the parent writes its own response then. the
+	* cookies they queue on the parent belong to the buffered one
+	*/
+	return func(h headers.Headers) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if b.discarded != nil {
+			return
+		}
+
+		n := len(b.parent.cookies)
+		hook(h)
+		b.writer.cookies = append(b.writer.cookies, b.parent.cookies[n:]...)
+		b.parent.cookies = b.parent.cookies[:n]
+	}
+}
+
+func (b *Buffer) hijack() []byte {
+	/*
+	* the response written so far, a 101 typically, goes out
+	* before the handler takes over the connection
+	*/
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.discarded != nil || b.flushed {
+		return nil
+	}
+
+	b.flushLocked()
+	_, buffered, _ := b.parent.Hijack()
+
+	return buffered
+}
+
+func (c *bufferConn) Write(p []byte) (int, error) {
+	c.b.mu.Lock()
+	defer c.b.mu.Unlock()
+
+	if c.b.discarded != nil {
+		return 0, c.b.discarded
+	}
+	if c.b.flushed {
+		return c.Conn.Write(p)
+	}
+
+	return c.b.buf.Write(p)
+}
@@ -0,0 +1,15 @@
+package response
+
+type Middleware func(next Handler) Handler
+
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	/*
+	* @brief: wraps handler with the middlewares, the first
+	* middleware is the outermost one
+	*/
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
@@ -1,10 +1,13 @@
 package response
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
 
+	"Servus/internal/cookie"
 	"Servus/internal/headers"
 	"Servus/internal/request"
 )
@@ -12,11 +15,59 @@ import (
 type StatusCode int
 
 const (
+	CodeSwitchingProtocols StatusCode = 101
 	CodeOK StatusCode = 200
+	CodeCreated StatusCode = 201
+	CodeNoContent StatusCode = 204
 	CodeBadRequest StatusCode = 400
+	CodeUnauthorized StatusCode = 401
+	CodeForbidden StatusCode = 403
+	CodeNotFound StatusCode = 404
+	CodeMethodNotAllowed StatusCode = 405
+	CodeNotAcceptable StatusCode = 406
+	CodeProxyAuthRequired StatusCode = 407
+	CodeRequestEntityTooLarge StatusCode = 413
+	CodeUnsupportedMediaType StatusCode = 415
+	CodeUnprocessableEntity StatusCode = 422
+	CodeUpgradeRequired StatusCode = 426
+	CodeTooManyRequests StatusCode = 429
 	CodeInternalServerError StatusCode = 500
+	CodeBadGateway StatusCode = 502
+	CodeServiceUnavailable StatusCode = 503
+	CodeGatewayTimeout StatusCode = 504
 )
 
+var statusText = map[StatusCode]string{
+	CodeSwitchingProtocols: "Switching Protocols",
+	CodeOK: "OK",
+	CodeCreated: "Created",
+	CodeNoContent: "No Content",
+	CodeBadRequest: "Bad Request",
+	CodeUnauthorized: "Unauthorized",
+	CodeForbidden: "Forbidden",
+	CodeNotFound: "Not Found",
+	CodeMethodNotAllowed: "Method Not Allowed",
+	CodeNotAcceptable: "Not Acceptable",
+	CodeProxyAuthRequired: "Proxy Authentication Required",
+	CodeRequestEntityTooLarge: "Content Too Large",
+	CodeUnsupportedMediaType: "Unsupported Media Type",
+	CodeUnprocessableEntity: "Unprocessable Content",
+	CodeUpgradeRequired: "Upgrade Required",
+	CodeTooManyRequests: "Too Many Requests",
+	CodeInternalServerError: "Internal Server Error",
+	CodeBadGateway: "Bad Gateway",
+	CodeServiceUnavailable: "Service Unavailable",
+	CodeGatewayTimeout: "Gateway Timeout",
+}
+
+func StatusText(code StatusCode) string {
+	/*
+	* returns the reason phrase of a status code, empty
+	* if the code is unknown
+	*/
+	return statusText[code]
+}
+
 type Response struct {
 	Code StatusCode
 	Message []byte
@@ -32,12 +83,32 @@ const (
 	StatusWriteHeaders
 	StatusWriteBody
 	StatusDone
+	StatusHijacked
+)
+
+var (
+	ErrHijacked = errors.New("response writer was hijacked")
+	ErrNotHijackable = errors.New("connection cannot be hijacked")
 )
 
 type Writer struct {
 	Status WriterStatus 
 	Response *Response
 	Connection net.Conn
+	// 'Set-Cookie' headers cannot be folded into a single
+	// line, so they are kept apart from the headers map
+	cookies []string
+	headerHooks []func(h headers.Headers)
+	// set by the server when the connection can be taken over,
+	// it stops managing the connection and returns the bytes
+	// read past the request
+	Hijacker func() []byte
+	// set by the request ID middleware, error responses
+	// report it to the client
+	RequestID string
+	// what went out so far, reported to loggers and metrics
+	code StatusCode
+	written int64
 }
 
 func NewResponseWriter(conn net.Conn) Writer {
@@ -48,24 +119,18 @@ func NewResponseWriter(conn net.Conn) Writer {
 }
 
 func (w *Writer) WriteStatusLine(code StatusCode) error {
+	if w.Status == StatusHijacked {
+		return ErrHijacked
+	}
+
 	var err error
 	if w.Status != StatusWriteResponseLine {
 		return fmt.Errorf("invalid response writer status")
 	}
 
-	switch w.Response.Code {
-	case CodeOK:
-		statusLine := "HTTP/1.1 " + strconv.Itoa(int(w.Response.Code)) + " OK\r\n"
-		_, err = w.Connection.Write([]byte(statusLine))
-	
-	case CodeBadRequest:
-		statusLine := "HTTP/1.1 " + strconv.Itoa(int(w.Response.Code)) + " Bad Request\r\n"
-		_, err = w.Connection.Write([]byte(statusLine))
-
-	case CodeInternalServerError:
-		statusLine := "HTTP/1.1 " + strconv.Itoa(int(w.Response.Code)) + " Internal Server Error\r\n"
-		_, err = w.Connection.Write([]byte(statusLine))
-	}
+	statusLine := "HTTP/1.1 " + strconv.Itoa(int(code)) + " " + StatusText(code) + "\r\n"
+	_, err = w.Connection.Write([]byte(statusLine))
+	w.code = code
 
 	w.Status = StatusWriteHeaders
 
@@ -73,10 +138,24 @@ func (w *Writer) WriteStatusLine(code StatusCode) error {
 }
 
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
+	if w.Status == StatusHijacked {
+		return ErrHijacked
+	}
+
 	if w.Status != StatusWriteHeaders {
 		return fmt.Errorf("invalid response writer status")
 	}
 
+	if headers == nil {
+		headers = map[string]string{}
+	}
+
+	hooks := w.headerHooks
+	w.headerHooks = nil
+	for _, hook := range hooks {
+		hook(headers)
+	}
+
 	for key, val := range headers {
 		headerString := key + ": " + val + "\r\n"
 		_, err := w.Connection.Write([]byte(headerString))
@@ -85,6 +164,13 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 		}
 	}
 
+	for _, c := range w.cookies {
+		_, err := w.Connection.Write([]byte("set-cookie: " + c + "\r\n"))
+		if err != nil {
+			return err
+		}
+	}
+
 	_, err := w.Connection.Write([]byte("\r\n"))
 
 	w.Status = StatusWriteBody
@@ -92,17 +178,187 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	return err
 }
 
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	/*
+	* @brief: queues a 'Set-Cookie' header, must be called
+	* before the headers are written
+	*/
+	if w.Status == StatusHijacked {
+		return ErrHijacked
+	}
+
+	if w.Status != StatusWriteResponseLine && w.Status != StatusWriteHeaders {
+		return fmt.Errorf("headers already written")
+	}
+
+	err := c.Valid()
+	if err != nil {
+		return err
+	}
+
+	return w.AddSetCookie(c.String())
+}
+
+func (w *Writer) AddSetCookie(value string) error {
+	/*
+	* @brief: queues a 'Set-Cookie' header with value sent as is,
+	* for relaying cookies set by another server. only values
+	* that would break the response are refused
+	*/
+	if w.Status == StatusHijacked {
+		return ErrHijacked
+	}
+
+	if w.Status != StatusWriteResponseLine && w.Status != StatusWriteHeaders {
+		return fmt.Errorf("headers already written")
+	}
+
+	if !headers.ValidValue(value) {
+		return fmt.Errorf("invalid set-cookie value %q", value)
+	}
+
+	w.cookies = append(w.cookies, value)
+
+	return nil
+}
+
+func (w *Writer) OnWriteHeaders(hook func(h headers.Headers)) {
+	/*
+	* @brief: registers a function called right before the headers
+	* are written, it may still add headers and cookies
+	*/
+	w.headerHooks = append(w.headerHooks, hook)
+}
+
 func (w *Writer) WriteBody(p []byte) (int, error) {
+	if w.Status == StatusHijacked {
+		return 0, ErrHijacked
+	}
+
 	if w.Status != StatusWriteBody {
 		return 0, fmt.Errorf("invalid response writer status")
 	}
 
 	n, err := w.Connection.Write(p)
 	w.Status = StatusDone
+	w.written += int64(n)
 
 	return n, err
 }
 
+func (w *Writer) WriteBodyFrom(r io.Reader) (int64, error) {
+	/*
+	* @brief: streams the body from r, the headers must
+	* already announce its length
+	*/
+	if w.Status == StatusHijacked {
+		return 0, ErrHijacked
+	}
+
+	if w.Status != StatusWriteBody {
+		return 0, fmt.Errorf("invalid response writer status")
+	}
+
+	n, err := io.Copy(w.Connection, r)
+	w.Status = StatusDone
+	w.written += n
+
+	return n, err
+}
+
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	/*
+	* @brief: writes p as one chunk of a body sent with
+	* 'Transfer-Encoding: chunked', unlike WriteBody it can be
+	* called repeatedly and every chunk reaches the client at once
+	*/
+	if w.Status == StatusHijacked {
+		return 0, ErrHijacked
+	}
+
+	if w.Status != StatusWriteBody {
+		return 0, fmt.Errorf("invalid response writer status")
+	}
+
+	// an empty chunk would end the body
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	chunk := make([]byte, 0, len(p)+20)
+	chunk = strconv.AppendInt(chunk, int64(len(p)), 16)
+	chunk = append(chunk, "\r\n"...)
+	chunk = append(chunk, p...)
+	chunk = append(chunk, "\r\n"...)
+
+	_, err := w.Connection.Write(chunk)
+	if err != nil {
+		return 0, err
+	}
+	w.written += int64(len(p))
+
+	return len(p), nil
+}
+
+func (w *Writer) WriteChunkedBodyDone() error {
+	/*
+	* @brief: ends a chunked body with the last chunk
+	*/
+	if w.Status == StatusHijacked {
+		return ErrHijacked
+	}
+
+	if w.Status != StatusWriteBody {
+		return fmt.Errorf("invalid response writer status")
+	}
+
+	_, err := w.Connection.Write([]byte("0\r\n\r\n"))
+	w.Status = StatusDone
+
+	return err
+}
+
+func (w *Writer) StatusCode() StatusCode {
+	/*
+	* @brief: returns the status code sent, 0 if the status
+	* line was not written yet
+	*/
+	return w.code
+}
+
+func (w *Writer) BytesWritten() int64 {
+	/*
+	* @brief: returns the number of body bytes sent, chunk
+	* framing excluded
+	*/
+	return w.written
+}
+
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	/*
+	* @brief: takes over the connection, the server will neither
+	* write to it, time it out nor close it afterwards
+	*
+	* returns the bytes the server already read past the request,
+	* they come before anything read from the connection. the
+	* handler must hijack before returning and the writer
+	* refuses any write afterwards
+	*/
+	if w.Status == StatusHijacked {
+		return nil, nil, ErrHijacked
+	}
+
+	if w.Hijacker == nil {
+		return nil, nil, ErrNotHijackable
+	}
+
+	buffered := w.Hijacker()
+	w.Status = StatusHijacked
+	w.Hijacker = nil
+
+	return w.Connection, buffered, nil
+}
+
 func (w *Writer) WriteResponse() (int, error) {
 	err := w.WriteStatusLine(w.Response.Code)
 	if err != nil {
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// causes of the request context cancellation, read them with
+// context.Cause(req.Context())
+var (
+	ErrServerClosed       = errors.New("server closed")
+	ErrClientDisconnected = errors.New("client disconnected")
+	ErrWriteTimeout       = errors.New("write timeout")
+)
+
+// connWatcher reads from an HTTP/1 connection while its request is
+// handled, the client has nothing more to send so a failed read
+// means it went away
+type connWatcher struct {
+	conn     net.Conn
+	done     chan struct{}
+	stopOnce sync.Once
+	stopping atomic.Bool
+	// read past the request, handed over on hijack
+	extra []byte
+}
+
+func watchConn(conn net.Conn, cancel context.CancelCauseFunc) *connWatcher {
+	cw := &connWatcher{conn: conn, done: make(chan struct{})}
+
+	go func() {
+		defer close(cw.done)
+
+		buf := make([]byte, 1)
+		n, _ := conn.Read(buf)
+		if n > 0 {
+			// a pipelined request or protocol data after an
+			// upgrade, the connection can no longer be watched
+			cw.extra = buf[:n]
+			return
+		}
+
+		// the read is interrupted by stop once the request
+		// is over, that is not a disconnect
+		if !cw.stopping.Load() {
+			cancel(ErrClientDisconnected)
+		}
+	}()
+
+	return cw
+}
+
+func (cw *connWatcher) stop() []byte {
+	/*
+	* stops the watching read and returns the bytes it consumed,
+	* the connection can be read again afterwards
+	*/
+	cw.stopOnce.Do(func() {
+		cw.stopping.Store(true)
+		cw.conn.SetReadDeadline(time.Unix(1, 0))
+		<-cw.done
+		cw.conn.SetReadDeadline(time.Time{})
+	})
+
+	return cw.extra
+}
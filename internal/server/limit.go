@@ -0,0 +1,160 @@
+package server
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// connections being answered with a 503 at once, past
+	// that they are closed without a word
+	maxRejecting = 64
+	// time a rejected client gets to read the 503
+	rejectTimeout = time.Second
+
+	rejectResponse = "HTTP/1.1 503 Service Unavailable\r\n" +
+		"content-type: text/plain\r\n" +
+		"content-length: 23\r\n" +
+		"retry-after: 1\r\n" +
+		"connection: close\r\n" +
+		"\r\n" +
+		"503 Service Unavailable"
+)
+
+// limitListener caps the connections open at once, overall and
+// per client IP. It sits below TLS so that refused connections
+// cost no handshake
+type limitListener struct {
+	net.Listener
+	maxConns int
+	maxPerIP int
+	// 503 for cleartext connections, TLS ones are just closed
+	answer   bool
+	rejected func()
+
+	mu     sync.Mutex
+	active int
+	perIP  map[string]int
+
+	rejecting chan struct{}
+}
+
+// limitedConn gives its slot back when closed, hijacked connections
+// keep theirs until the handler closes them
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func newLimitListener(l net.Listener, maxConns, maxPerIP int, answer bool, rejected func()) *limitListener {
+	return &limitListener{
+		Listener:  l,
+		maxConns:  maxConns,
+		maxPerIP:  maxPerIP,
+		answer:    answer,
+		rejected:  rejected,
+		perIP:     map[string]int{},
+		rejecting: make(chan struct{}, maxRejecting),
+	}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := remoteIP(conn)
+		if l.acquire(ip) {
+			return &limitedConn{Conn: conn, release: func() { l.release(ip) }}, nil
+		}
+
+		if l.rejected != nil {
+			l.rejected()
+		}
+		l.reject(conn)
+	}
+}
+
+func (l *limitListener) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxConns > 0 && l.active >= l.maxConns {
+		return false
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		return false
+	}
+
+	l.active++
+	l.perIP[ip]++
+
+	return true
+}
+
+func (l *limitListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+	l.perIP[ip]--
+	if l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+func (l *limitListener) reject(conn net.Conn) {
+	/*
+	* answers with a 503 without reading the request, away from
+	* the accept loop. the request is drained after the response
+	* so closing does not reset the connection before the client
+	* read it
+	*/
+	if !l.answer {
+		conn.Close()
+		return
+	}
+
+	select {
+	case l.rejecting <- struct{}{}:
+	default:
+		conn.Close()
+		return
+	}
+
+	go func() {
+		defer func() { <-l.rejecting }()
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(rejectTimeout))
+		_, err := io.WriteString(conn, rejectResponse)
+		if err != nil {
+			return
+		}
+
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.CloseWrite()
+		}
+		io.CopyN(io.Discard, conn, 64<<10)
+	}()
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(c.release)
+
+	return c.Conn.Close()
+}
+
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+
+	return addr.String()
+}
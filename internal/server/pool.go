@@ -0,0 +1,118 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"Servus/internal/headers"
+	"Servus/internal/response"
+)
+
+type OverloadPolicy int
+
+const (
+	// requests finding the queue full are answered with a 503
+	OverloadReject OverloadPolicy = iota
+	// they are dropped, the connection closed or the HTTP/2
+	// stream reset without a response
+	OverloadDrop
+)
+
+// workerPool runs handlers on a fixed set of goroutines, taking them
+// from a bounded queue
+type workerPool struct {
+	queue chan func()
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newWorkerPool(workers, depth int) *workerPool {
+	p := &workerPool{queue: make(chan func(), depth)}
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range p.queue {
+				job()
+			}
+		}()
+	}
+
+	return p
+}
+
+func (p *workerPool) submit(job func()) bool {
+	/*
+	* queues job, false if the queue is full or the pool closed
+	*/
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return false
+	}
+
+	select {
+	case p.queue <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *workerPool) close() {
+	/*
+	* the workers exit once the jobs queued are done
+	*/
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+}
+
+func (s *Server) run(w *response.Writer, handle func()) {
+	/*
+	* runs handle on the worker pool when there is one and waits
+	* for it, otherwise right away on the calling goroutine
+	*/
+	if s.pool == nil {
+		handle()
+		return
+	}
+
+	queued := time.Now()
+	done := make(chan struct{})
+	ok := s.pool.submit(func() {
+		defer close(done)
+
+		if s.config.Metrics != nil {
+			s.config.Metrics.QueueWait(time.Since(queued))
+		}
+		handle()
+	})
+
+	if ok {
+		<-done
+		return
+	}
+
+	if s.config.Metrics != nil {
+		s.config.Metrics.QueueRejected()
+	}
+	if s.config.Overload == OverloadDrop {
+		return
+	}
+
+	body := "503 Service Unavailable"
+	h := headers.GetDefaultHeaders(len(body))
+	h.AddOverride("Retry-After", "1")
+	w.Response = &response.Response{
+		Code:    response.CodeServiceUnavailable,
+		Message: []byte(body),
+		Headers: h,
+	}
+	w.WriteResponse()
+}
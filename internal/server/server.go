@@ -1,25 +1,101 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
-
+	"runtime/debug"
+	"slices"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"Servus/internal/headers"
+	"Servus/internal/http2"
+	"Servus/internal/metrics"
 	"Servus/internal/request"
 	"Servus/internal/response"
+	"Servus/internal/tlsconfig"
+	"Servus/internal/tracing"
+)
+
+type Config struct {
+	// connections are served over TLS when set
+	TLSConfig *tls.Config
+	// enables HTTP/2, negotiated with ALPN over TLS and
+	// with prior knowledge or 'Upgrade: h2c' in cleartext
+	HTTP2 bool
+	// limits the time spent reading a request, TLS handshake
+	// included, zero means no limit
+	ReadTimeout time.Duration
+	// limits the time spent writing the response once the
+	// request was read, zero means no limit
+	WriteTimeout time.Duration
+	// connection, parse error and panic metrics are recorded
+	// when set, the request ones come from its Middleware
+	Metrics *metrics.HTTPMetrics
+	// every request gets a server span with parse, handler
+	// and write children when set
+	Tracer *tracing.Tracer
+	// connections open at once, zero means no limit. the ones
+	// past it are answered with a 503, closed if over TLS
+	MaxConns int
+	// connections open at once per client IP, zero means no limit
+	MaxConnsPerIP int
+	// handlers run on this many goroutines when set, instead of
+	// the one serving the connection or stream. a handler keeps
+	// its worker until it returns, hijacked connection or not
+	Workers int
+	// requests waiting for a worker, Workers when zero
+	QueueDepth int
+	// what becomes of the requests finding the queue full
+	Overload OverloadPolicy
+	// longest request body, bodies are read into memory before
+	// the handler runs. longer HTTP/1 ones are answered with a
+	// 413, the HTTP/2 flow control window is opened no further.
+	// http2.DefaultMaxBodySize when zero
+	MaxBodySize int64
+}
+
+type connState int
+
+const (
+	// waiting for the request
+	stateIdle connState = iota
+	stateActive
 )
 
+type trackedConn struct {
+	state connState
+	// set once the connection switched to HTTP/2
+	http2Conn *http2.ServerConn
+}
+
 type Server struct {
 	Port int
 	closed atomic.Bool
 	listener net.Listener
 	handlerFunc response.Handler
+	config Config
+
+	mu sync.Mutex
+	conns map[net.Conn]*trackedConn
+	shuttingDown bool
+
+	// parent of the request contexts, cancelled by Close
+	ctx context.Context
+	cancel context.CancelCauseFunc
+	// set when Config.Workers is
+	pool *workerPool
 }
 
 func (s *Server) listen() {
+	var delay time.Duration
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
@@ -27,29 +103,318 @@ func (s *Server) listen() {
 				return 
 			}
 
-			log.Printf("failed to accept connection: %v", err)
+			// out of file descriptors or the like, retrying at
+			// once would only spin
+			delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+			log.Printf("failed to accept connection: %v, retrying in %v", err, delay)
+			time.Sleep(delay)
 			continue
 		}
+		delay = 0
 
 		go s.handle(conn)
 	}
 }
 
 func (s *Server) Close() error {
-	err := s.listener.Close()
+	/*
+	* @brief: stops the server immediately, closing every
+	* connection it still manages and cancelling the request
+	* contexts
+	*/
 	s.closed.Store(true)
+	err := s.listener.Close()
+	s.cancel(ErrServerClosed)
+	if s.pool != nil {
+		s.pool.close()
+	}
+
+	s.mu.Lock()
+	s.shuttingDown = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
 
 	return err
 }
 
+func (s *Server) Shutdown(ctx context.Context) error {
+	/*
+	* @brief: stops accepting connections and waits for the ones
+	* being served to finish, connections still open when ctx
+	* is done are closed
+	*
+	* idle connections are closed right away and HTTP/2 ones are
+	* sent a GOAWAY, hijacked connections are left alone. request
+	* contexts are cancelled once ctx is done
+	*/
+	s.closed.Store(true)
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	s.shuttingDown = true
+	for conn, tc := range s.conns {
+		if tc.http2Conn != nil {
+			go tc.http2Conn.Shutdown()
+		} else if tc.state == stateIdle {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		s.mu.Lock()
+		remaining := len(s.conns)
+		s.mu.Unlock()
+
+		if remaining == 0 {
+			if s.pool != nil {
+				s.pool.close()
+			}
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shuttingDown {
+		return false
+	}
+
+	s.conns[conn] = &trackedConn{state: stateIdle}
+	if s.config.Metrics != nil {
+		s.config.Metrics.ConnOpened()
+	}
+
+	return true
+}
+
+func (s *Server) setState(conn net.Conn, state connState, http2Conn *http2.ServerConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tc, ok := s.conns[conn]
+	if !ok {
+		return
+	}
+
+	tc.state = state
+	tc.http2Conn = http2Conn
+}
+
+func (s *Server) release(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.conns[conn]; !ok {
+		return
+	}
+
+	delete(s.conns, conn)
+	if s.config.Metrics != nil {
+		s.config.Metrics.ConnClosed()
+	}
+}
+
+func (s *Server) parseError(kind string) {
+	if s.config.Metrics != nil {
+		s.config.Metrics.ParseError(kind)
+	}
+}
+
+func (s *Server) serveRequest(w *response.Writer, req *request.Request) {
+	/*
+	* runs the handler, a panic is logged and answered with a
+	* 500 if nothing was written yet instead of crashing
+	*/
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+
+		log.Printf("panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, v, debug.Stack())
+		if s.config.Metrics != nil {
+			s.config.Metrics.PanicRecovered()
+		}
+
+		if w.Status == response.StatusWriteResponseLine {
+			body := "500 Internal Server Error"
+			w.Response = &response.Response{
+				Code: response.CodeInternalServerError,
+				Message: []byte(body),
+				Headers: headers.GetDefaultHeaders(len(body)),
+			}
+			w.WriteResponse()
+		}
+	}()
+
+	s.handlerFunc(w, req)
+}
+
+func (s *Server) traceRequest(w *response.Writer, req *request.Request, readStart, readEnd time.Time) {
+	if s.config.Tracer == nil {
+		s.serveRequest(w, req)
+		return
+	}
+
+	s.config.Tracer.Serve(w, req, readStart, readEnd, s.serveRequest)
+}
+
+func (s *Server) serveHTTP2(conn net.Conn, tlsState *tls.ConnectionState, serve func(sc *http2.ServerConn) error) {
+	/*
+	* hands conn over to HTTP/2, the connection is long lived
+	* so the request timeouts no longer apply
+	*/
+	conn.SetDeadline(time.Time{})
+
+	// every stream after the first one reuses the connection
+	var served atomic.Int64
+	handler := func(w *response.Writer, req *request.Request) {
+		if served.Add(1) > 1 && s.config.Metrics != nil {
+			s.config.Metrics.ConnReused()
+		}
+
+		// the stream context ends with the stream, this one
+		// also ends with the server
+		ctx, cancel := context.WithCancelCause(req.Context())
+		defer cancel(context.Canceled)
+		stop := context.AfterFunc(s.ctx, func() {
+			cancel(context.Cause(s.ctx))
+		})
+		defer stop()
+		req.SetContext(ctx)
+
+		// HTTP/2 requests are decoded by the connection, there
+		// is no parse phase to time
+		s.run(w, func() {
+			s.traceRequest(w, req, time.Time{}, time.Time{})
+		})
+	}
+
+	sc := http2.NewServerConn(conn, handler, tlsState)
+	sc.MaxBodySize = s.config.MaxBodySize
+	s.setState(conn, stateActive, sc)
+
+	s.mu.Lock()
+	shuttingDown := s.shuttingDown
+	s.mu.Unlock()
+	if shuttingDown {
+		go sc.Shutdown()
+	}
+
+	serve(sc)
+}
+
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
+	if !s.track(conn) {
+		conn.Close()
+		return
+	}
+
+	hijacked := false
+	defer func() {
+		if hijacked {
+			return
+		}
+
+		s.release(conn)
+		conn.Close()
+	}()
+
+	if s.config.ReadTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.config.ReadTimeout))
+	}
+
+	var tlsState *tls.ConnectionState
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// handshake explicitly so that failures are not
+		// reported as malformed requests
+		err := tlsConn.Handshake()
+		if err != nil {
+			log.Printf("tls handshake failed: %v", err)
+			s.parseError("tls_handshake")
+			return
+		}
+
+		state := tlsConn.ConnectionState()
+		tlsState = &state
+
+		if state.NegotiatedProtocol == "h2" {
+			s.serveHTTP2(conn, tlsState, func(sc *http2.ServerConn) error {
+				return sc.Serve(nil)
+			})
+			return
+		}
+	}
+
+	var reader io.Reader = conn
+	if s.config.HTTP2 && tlsState == nil {
+		prefix, isHTTP2, err := http2.SniffPreface(conn)
+		if err != nil && len(prefix) == 0 {
+			return
+		}
+
+		if isHTTP2 {
+			s.serveHTTP2(conn, nil, func(sc *http2.ServerConn) error {
+				return sc.Serve(prefix)
+			})
+			return
+		}
+
+		reader = io.MultiReader(bytes.NewReader(prefix), conn)
+	}
+
+	readStart := time.Now()
+	maxBodySize := s.config.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = http2.DefaultMaxBodySize
+	}
+	req, err := request.RequestFromReaderLimit(reader, int(maxBodySize))
+	readEnd := time.Now()
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		// timed out or the connection broke, nobody to answer
+		if netErr.Timeout() {
+			s.parseError("timeout")
+		}
+		return
+	}
 
-	req, err := request.RequestFromReader(conn)
 	if err != nil {
+		kind := "other"
+		var parseErr *request.ParseError
+		if errors.As(err, &parseErr) {
+			kind = parseErr.Kind
+		}
+		s.parseError(kind)
+
+		code := response.CodeBadRequest
+		if errors.Is(err, request.ErrBodyTooLarge) {
+			code = response.CodeRequestEntityTooLarge
+		}
+
 		headers := headers.GetDefaultHeaders(len(err.Error()))
 		resp := response.Response{
-			Code: response.CodeBadRequest,
+			Code: code,
 			Message: []byte(err.Error()),
 			Headers: headers,
 		}
@@ -60,20 +425,117 @@ func (s *Server) handle(conn net.Conn) {
 		return
 	}
 
+	req.TLS = tlsState
+
+	if s.config.HTTP2 && tlsState == nil && http2.IsUpgradeRequest(req) {
+		s.serveHTTP2(conn, nil, func(sc *http2.ServerConn) error {
+			return sc.ServeUpgrade(req.Buffered(), req)
+		})
+		return
+	}
+
+	s.setState(conn, stateActive, nil)
+	conn.SetReadDeadline(time.Time{})
+
+	// cancelled when the client goes away, the write timeout
+	// fires, the server is closed or the request is over
+	ctx, cancel := context.WithCancelCause(s.ctx)
+	defer cancel(context.Canceled)
+	req.SetContext(ctx)
+
+	var writeTimer *time.Timer
+	if s.config.WriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+		writeTimer = time.AfterFunc(s.config.WriteTimeout, func() {
+			cancel(ErrWriteTimeout)
+		})
+		defer writeTimer.Stop()
+	}
+
+	watcher := watchConn(conn, cancel)
+	defer watcher.stop()
+
 	respWriter := response.NewResponseWriter(conn)
-	s.handlerFunc(&respWriter, req)
+	respWriter.Hijacker = func() []byte {
+		hijacked = true
+		s.release(conn)
+		// the connection belongs to the handler now, it is
+		// neither watched nor subject to the write timeout
+		extra := watcher.stop()
+		if writeTimer != nil {
+			writeTimer.Stop()
+		}
+		conn.SetDeadline(time.Time{})
+
+		return append(req.Buffered(), extra...)
+	}
+	s.run(&respWriter, func() {
+		s.traceRequest(&respWriter, req, readStart, readEnd)
+	})
+
+	// files spilled to disk while parsing forms
+	// do not outlive the request
+	if req.MultipartForm != nil {
+		req.MultipartForm.RemoveAll()
+	}
 }
 
 func Serve(port int, handler response.Handler) (*Server, error) {
+	return ServeConfig(port, handler, Config{})
+}
+
+func ServeTLS(port int, handler response.Handler, certFile, keyFile string) (*Server, error) {
+	/*
+	* @brief: serves HTTPS with the given certificate, which is
+	* reloaded whenever the files change on disk
+	*/
+	tlsConfig, err := tlsconfig.New(tlsconfig.Options{
+		CertFile: certFile,
+		KeyFile: keyFile,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ServeConfig(port, handler, Config{TLSConfig: tlsConfig})
+}
+
+func ServeConfig(port int, handler response.Handler, config Config) (*Server, error) {
 	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, err
 	}
 
+	if config.MaxConns > 0 || config.MaxConnsPerIP > 0 {
+		var rejected func()
+		if config.Metrics != nil {
+			rejected = config.Metrics.ConnRejected
+		}
+		l = newLimitListener(l, config.MaxConns, config.MaxConnsPerIP, config.TLSConfig == nil, rejected)
+	}
+
+	if config.TLSConfig != nil {
+		if config.HTTP2 {
+			config.TLSConfig = withALPN(config.TLSConfig)
+		}
+
+		l = tls.NewListener(l, config.TLSConfig)
+	}
+
 	server := Server{
 		Port: port,
 		listener: l,
 		handlerFunc: handler,
+		config: config,
+		conns: map[net.Conn]*trackedConn{},
+	}
+	server.ctx, server.cancel = context.WithCancelCause(context.Background())
+	if config.Workers > 0 {
+		depth := config.QueueDepth
+		if depth <= 0 {
+			depth = config.Workers
+		}
+		server.pool = newWorkerPool(config.Workers, depth)
 	}
 
 	server.closed.Store(false)
@@ -82,3 +544,17 @@ func Serve(port int, handler response.Handler) (*Server, error) {
 	return &server, err
 }
 
+func withALPN(config *tls.Config) *tls.Config {
+	/*
+	* returns a copy of config advertising h2 ahead of http/1.1
+	*/
+	config = config.Clone()
+	if !slices.Contains(config.NextProtos, "h2") {
+		config.NextProtos = append([]string{"h2"}, config.NextProtos...)
+	}
+	if !slices.Contains(config.NextProtos, "http/1.1") {
+		config.NextProtos = append(config.NextProtos, "http/1.1")
+	}
+
+	return config
+}
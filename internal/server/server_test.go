@@ -0,0 +1,799 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2/hpack"
+
+	"Servus/internal/headers"
+	"Servus/internal/http2"
+	"Servus/internal/metrics"
+	"Servus/internal/request"
+	"Servus/internal/response"
+	"Servus/internal/tracing"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func whoAmI(w *response.Writer, req *request.Request) {
+	body := "anonymous"
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		body = req.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	w.Response = &response.Response{
+		Code:    response.CodeOK,
+		Message: []byte(body),
+		Headers: headers.GetDefaultHeaders(len(body)),
+	}
+	w.WriteResponse()
+}
+
+func TestServeMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	clientCert := ca.issue(t, "client-42", x509.ExtKeyUsageClientAuth)
+
+	s, err := ServeConfig(0, whoAmI, Config{
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientCAs:    ca.pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		},
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// test: the handler sees the verified client certificate
+	conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{
+		RootCAs:      ca.pool,
+		ServerName:   "localhost",
+		Certificates: []tls.Certificate{clientCert},
+	})
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(conn)
+	conn.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, string(raw), "\r\n\r\nclient-42")
+
+	// test: connections without a client certificate are refused
+	conn, err = tls.Dial("tcp", s.Addr().String(), &tls.Config{
+		RootCAs:    ca.pool,
+		ServerName: "localhost",
+	})
+	if err == nil {
+		// TLS 1.3 reports the failed client auth on the first read
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		_, err = io.ReadAll(conn)
+		conn.Close()
+	}
+	require.Error(t, err)
+}
+
+func readHTTP2Body(t *testing.T, conn net.Conn, streamID uint32) string {
+	/*
+	* reads frames until streamID ends and returns its DATA
+	*/
+	body := ""
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		f, err := http2.ReadFrame(conn, 1<<24-1)
+		require.NoError(t, err)
+
+		if f.StreamID != streamID {
+			continue
+		}
+
+		require.NotEqual(t, http2.FrameRSTStream, f.Type)
+		if f.Type == http2.FrameData {
+			body += string(f.Payload)
+		}
+
+		if (f.Type == http2.FrameData || f.Type == http2.FrameHeaders) && f.Has(http2.FlagEndStream) {
+			return body
+		}
+	}
+}
+
+func TestServeHTTP2(t *testing.T) {
+	s, err := ServeConfig(0, whoAmI, Config{HTTP2: true})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// test: prior knowledge
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var block bytes.Buffer
+	enc := hpack.NewEncoder(&block)
+	enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})
+	enc.WriteField(hpack.HeaderField{Name: ":scheme", Value: "http"})
+	enc.WriteField(hpack.HeaderField{Name: ":path", Value: "/"})
+
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+	require.NoError(t, http2.WriteFrame(conn, &http2.Frame{Type: http2.FrameSettings}))
+	require.NoError(t, http2.WriteFrame(conn, &http2.Frame{
+		Type:     http2.FrameHeaders,
+		Flags:    http2.FlagEndHeaders | http2.FlagEndStream,
+		StreamID: 1,
+		Payload:  block.Bytes(),
+	}))
+	assert.Equal(t, "anonymous", readHTTP2Body(t, conn, 1))
+
+	// test: upgrade from HTTP/1.1
+	conn, err = net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: \r\n\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	statusLine, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", statusLine)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+	require.NoError(t, http2.WriteFrame(conn, &http2.Frame{Type: http2.FrameSettings}))
+	assert.Equal(t, "anonymous", readHTTP2Body(t, &bufferedConn{Conn: conn, reader: reader}, 1))
+
+	// test: HTTP/1.1 still works
+	conn, err = net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "HTTP/1.1 200 OK\r\n")
+}
+
+func TestServeHTTP2OverTLS(t *testing.T) {
+	ca := newTestCA(t)
+	s, err := ServeConfig(0, whoAmI, Config{
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{ca.issue(t, "server", x509.ExtKeyUsageServerAuth)}},
+		HTTP2:     true,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// test: ALPN picks h2
+	conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{
+		RootCAs:    ca.pool,
+		ServerName: "localhost",
+		NextProtos: []string{"h2", "http/1.1"},
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "h2", conn.ConnectionState().NegotiatedProtocol)
+
+	var block bytes.Buffer
+	enc := hpack.NewEncoder(&block)
+	enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})
+	enc.WriteField(hpack.HeaderField{Name: ":scheme", Value: "https"})
+	enc.WriteField(hpack.HeaderField{Name: ":path", Value: "/"})
+
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+	require.NoError(t, http2.WriteFrame(conn, &http2.Frame{Type: http2.FrameSettings}))
+	require.NoError(t, http2.WriteFrame(conn, &http2.Frame{
+		Type:     http2.FrameHeaders,
+		Flags:    http2.FlagEndHeaders | http2.FlagEndStream,
+		StreamID: 1,
+		Payload:  block.Bytes(),
+	}))
+	assert.Equal(t, "anonymous", readHTTP2Body(t, conn, 1))
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func TestHijack(t *testing.T) {
+	released := make(chan struct{})
+	handler := func(w *response.Writer, req *request.Request) {
+		conn, buffered, err := w.Hijack()
+		require.NoError(t, err)
+
+		err = w.WriteStatusLine(response.CodeOK)
+		assert.ErrorIs(t, err, response.ErrHijacked)
+		_, _, err = w.Hijack()
+		assert.ErrorIs(t, err, response.ErrHijacked)
+
+		// the connection outlives the handler
+		go func() {
+			<-released
+			conn.Write([]byte("raw:" + string(buffered)))
+			io.Copy(conn, conn)
+			conn.Close()
+		}()
+	}
+
+	s, err := ServeConfig(0, handler, Config{ReadTimeout: time.Second, WriteTimeout: time.Second})
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// test: bytes sent along with the request are handed over
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\nearly"))
+	require.NoError(t, err)
+
+	// test: shutdown does not wait for hijacked connections
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	close(released)
+
+	// test: nor does it close them, timeouts no longer apply either
+	time.Sleep(1100 * time.Millisecond)
+	_, err = conn.Write([]byte(" late"))
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, len("raw:early late"))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "raw:early late", string(buf))
+}
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	handler := func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-finish
+		whoAmI(w, req)
+	}
+
+	s, err := ServeConfig(0, handler, Config{ReadTimeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+
+	// test: connections that never send a request time out
+	idle, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer idle.Close()
+	idle.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = idle.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	// test: shutdown waits for the active request
+	done := make(chan error)
+	go func() { done <- s.Shutdown(context.Background()) }()
+
+	select {
+	case <-done:
+		t.Fatal("shutdown returned while a request was active")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_, err = net.Dial("tcp", s.Addr().String())
+	assert.Error(t, err)
+
+	close(finish)
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "HTTP/1.1 200 OK\r\n")
+	require.NoError(t, <-done)
+}
+
+func TestMetrics(t *testing.T) {
+	r := metrics.NewRegistry()
+	m := metrics.NewHTTPMetrics(r, metrics.HTTPOptions{
+		Path:   "/admin/metrics",
+		Routes: []string{"/users", "/missing", "/panic"},
+	})
+
+	handler := func(w *response.Writer, req *request.Request) {
+		if req.Path() == "/panic" {
+			panic("boom")
+		}
+
+		code := response.CodeOK
+		if strings.HasPrefix(req.Path(), "/missing") {
+			code = response.CodeNotFound
+		}
+
+		body := "hello"
+		w.Response = &response.Response{
+			Code:    code,
+			Message: []byte(body),
+			Headers: headers.GetDefaultHeaders(len(body)),
+		}
+		w.WriteResponse()
+	}
+
+	s, err := ServeConfig(0, response.Chain(handler, m.Middleware), Config{Metrics: m})
+	require.NoError(t, err)
+	defer s.Close()
+	send := func(raw string) string {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		_, err = conn.Write([]byte(raw))
+		require.NoError(t, err)
+		// rejected requests may be reset before they are read in full
+		resp, _ := io.ReadAll(conn)
+
+		return string(resp)
+	}
+
+	send("GET /users/1 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	send("GET /users/2 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	send("POST /users HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\n\r\ndata")
+	send("GET /missing HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	// test: a panic is answered with a 500
+	resp := send("GET /panic HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 500 Internal Server Error\r\n")
+
+	send("GET /other HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	send("GET / HTTP/1.1\r\nHost localhost\r\n\r\n")
+	send("BREW / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	resp = send("GET /admin/metrics HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "content-type: text/plain; version=0.0.4; charset=utf-8\r\n")
+
+	// test: requests by method, route and status class
+	assert.Contains(t, resp, `servus_http_requests_total{method="GET",route="/users",status_class="2xx"} 2`+"\n")
+	assert.Contains(t, resp, `servus_http_requests_total{method="POST",route="/users",status_class="2xx"} 1`+"\n")
+	assert.Contains(t, resp, `servus_http_requests_total{method="GET",route="/missing",status_class="4xx"} 1`+"\n")
+	assert.Contains(t, resp, `servus_http_requests_total{method="GET",route="/panic",status_class="5xx"} 1`+"\n")
+
+	// test: undeclared routes are folded
+	assert.Contains(t, resp, `servus_http_requests_total{method="GET",route="other",status_class="2xx"} 1`+"\n")
+
+	// test: histograms
+	assert.Contains(t, resp, `servus_http_request_duration_seconds_count{method="GET",route="/users"} 2`+"\n")
+	assert.Contains(t, resp, `servus_http_request_size_bytes_sum{method="POST",route="/users"} 4`+"\n")
+	assert.Contains(t, resp, `servus_http_response_size_bytes_sum{method="GET",route="/users"} 10`+"\n")
+
+	// test: connections, parse errors and panics
+	assert.Contains(t, resp, "servus_connections_total 9\n")
+	assert.Contains(t, resp, "servus_connections_active 1\n")
+	assert.Contains(t, resp, `servus_parse_errors_total{kind="header"} 1`+"\n")
+	assert.Contains(t, resp, `servus_parse_errors_total{kind="request_line"} 1`+"\n")
+	assert.Contains(t, resp, "servus_panics_recovered_total 1\n")
+	assert.Contains(t, resp, "servus_http_requests_in_flight 0\n")
+}
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) Export(spans []tracing.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	recorder := &spanRecorder{}
+	tracer := tracing.NewTracer(tracing.Options{
+		Exporter: recorder,
+		Route:    func(req *request.Request) string { return "/users/{id}" },
+	})
+
+	var forwarded string
+	handler := func(w *response.Writer, req *request.Request) {
+		forwarded, _ = req.Headers.Get("traceparent")
+		_, span := tracer.Start(req.Context(), "query")
+		time.Sleep(10 * time.Millisecond)
+		span.End()
+
+		body := "hello"
+		w.Response = &response.Response{
+			Code:    response.CodeOK,
+			Message: []byte(body),
+			Headers: headers.GetDefaultHeaders(len(body)),
+		}
+		w.WriteResponse()
+	}
+
+	s, err := ServeConfig(0, handler, Config{Tracer: tracer})
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte("POST /users/7 HTTP/1.1\r\nHost: localhost\r\n" +
+		"traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n" +
+		"Content-Length: 4\r\n\r\ndata"))
+	require.NoError(t, err)
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(resp), "HTTP/1.1 200 OK\r\n")
+
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	spans := map[string]tracing.SpanData{}
+	for _, span := range recorder.spans {
+		spans[span.Name] = span
+	}
+	require.Len(t, spans, 5)
+
+	// test: the server span continues the incoming trace
+	server := spans["POST /users/{id}"]
+	assert.Equal(t, tracing.SpanKindServer, server.Kind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", server.ParentSpanID.String())
+	assert.Equal(t, map[string]any{
+		"http.request.method":       "POST",
+		"http.route":                "/users/{id}",
+		"url.path":                  "/users/7",
+		"network.protocol.version":  "1.1",
+		"http.request.body.size":    4,
+		"http.response.status_code": 200,
+		"http.response.body.size":   int64(5),
+	}, server.Attributes)
+
+	// test: the handler sees the server span as parent
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+server.SpanID.String()+"-01", forwarded)
+
+	// test: parse, handler and write phases
+	for _, name := range []string{"parse", "handler", "write"} {
+		assert.Equal(t, server.SpanID, spans[name].ParentSpanID, name)
+		assert.False(t, spans[name].Start.Before(server.Start), name)
+		assert.False(t, spans[name].End.After(server.End), name)
+	}
+	assert.Equal(t, spans["handler"].SpanID, spans["query"].ParentSpanID)
+	assert.GreaterOrEqual(t, spans["handler"].End.Sub(spans["handler"].Start), 10*time.Millisecond)
+	assert.False(t, spans["write"].Start.Before(spans["handler"].End))
+}
+
+func TestRequestContext(t *testing.T) {
+	causes := make(chan error, 1)
+	handler := func(w *response.Writer, req *request.Request) {
+		if req.Path() == "/deadline" {
+			// test: handlers derive their own timeouts
+			ctx, cancel := context.WithTimeout(req.Context(), 20*time.Millisecond)
+			defer cancel()
+			<-ctx.Done()
+
+			body := ctx.Err().Error()
+			if req.Context().Err() != nil {
+				body = "request cancelled"
+			}
+			w.Response = &response.Response{
+				Code:    response.CodeOK,
+				Message: []byte(body),
+				Headers: headers.GetDefaultHeaders(len(body)),
+			}
+			w.WriteResponse()
+			return
+		}
+
+		<-req.Context().Done()
+		causes <- context.Cause(req.Context())
+	}
+
+	start := func(config Config) (*Server, net.Conn) {
+		s, err := ServeConfig(0, handler, config)
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		return s, conn
+	}
+	cause := func() error {
+		select {
+		case err := <-causes:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("request context not cancelled")
+			return nil
+		}
+	}
+
+	_, conn := start(Config{})
+	_, err := conn.Write([]byte("GET /deadline HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(resp), "\r\n\r\ncontext deadline exceeded"), string(resp))
+
+	// test: the client going away cancels the context
+	_, conn = start(Config{})
+	_, err = conn.Write([]byte("GET /wait HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	assert.ErrorIs(t, cause(), ErrClientDisconnected)
+
+	// test: so does the write timeout
+	_, conn = start(Config{WriteTimeout: 50 * time.Millisecond})
+	_, err = conn.Write([]byte("GET /wait HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, cause(), ErrWriteTimeout)
+
+	// test: and closing the server
+	s, conn := start(Config{})
+	_, err = conn.Write([]byte("GET /wait HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	s.Close()
+	assert.ErrorIs(t, cause(), ErrServerClosed)
+}
+
+func TestConnectionLimits(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		body := "ok"
+		w.Response = &response.Response{
+			Code:    response.CodeOK,
+			Message: []byte(body),
+			Headers: headers.GetDefaultHeaders(len(body)),
+		}
+		w.WriteResponse()
+	}
+
+	r := metrics.NewRegistry()
+	m := metrics.NewHTTPMetrics(r, metrics.HTTPOptions{})
+	s, err := ServeConfig(0, handler, Config{MaxConns: 3, MaxConnsPerIP: 2, Metrics: m})
+	require.NoError(t, err)
+	defer s.Close()
+
+	dial := func(ip string) net.Conn {
+		d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(ip)}}
+		conn, err := d.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		return conn
+	}
+	get := func(conn net.Conn) string {
+		defer conn.Close()
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		resp, _ := io.ReadAll(conn)
+
+		return string(resp)
+	}
+	idle := func(ip string) net.Conn {
+		// a request line so the server surely accepted it
+		conn := dial(ip)
+		conn.Write([]byte("GET / HTTP/1.1\r\n"))
+		t.Cleanup(func() { conn.Close() })
+
+		return conn
+	}
+
+	// test: a client is held to its share
+	first := idle("127.0.0.1")
+	idle("127.0.0.1")
+	resp := get(dial("127.0.0.1"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 503 Service Unavailable\r\n"), resp)
+	assert.Contains(t, resp, "retry-after: 1\r\n")
+
+	// test: others are served until the overall limit
+	assert.Contains(t, get(dial("127.0.0.2")), "200 OK")
+	idle("127.0.0.2")
+	assert.Contains(t, get(dial("127.0.0.3")), "503 Service Unavailable")
+
+	var scrape strings.Builder
+	r.WriteTo(&scrape)
+	assert.Contains(t, scrape.String(), "servus_connections_rejected_total 2\n")
+
+	// test: closed connections give their slot back
+	first.Close()
+	require.Eventually(t, func() bool {
+		return strings.Contains(get(dial("127.0.0.1")), "200 OK")
+	}, 5*time.Second, 10*time.Millisecond)
+
+}
+
+func TestMaxBodySize(t *testing.T) {
+	s, err := ServeConfig(0, whoAmI, Config{MaxBodySize: 8})
+	require.NoError(t, err)
+	defer s.Close()
+
+	send := func(raw string) string {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		conn.Write([]byte(raw))
+		resp, _ := io.ReadAll(conn)
+
+		return string(resp)
+	}
+
+	// test: HTTP/1 bodies within the limit reach the handler
+	resp := send("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 8\r\n\r\n12345678")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"), resp)
+
+	// test: longer ones are refused from their 'Content-Length'
+	resp = send("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 9\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 413 Content Too Large\r\n"), resp)
+}
+
+func TestWorkerPool(t *testing.T) {
+	release := make(chan struct{})
+	var running, peak atomic.Int32
+	handler := func(w *response.Writer, req *request.Request) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		if n > peak.Load() {
+			peak.Store(n)
+		}
+		<-release
+
+		body := req.Path()
+		w.Response = &response.Response{
+			Code:    response.CodeOK,
+			Message: []byte(body),
+			Headers: headers.GetDefaultHeaders(len(body)),
+		}
+		w.WriteResponse()
+	}
+
+	start := func(overload OverloadPolicy) (*Server, *metrics.Registry) {
+		r := metrics.NewRegistry()
+		s, err := ServeConfig(0, handler, Config{
+			Workers:    1,
+			QueueDepth: 1,
+			Overload:   overload,
+			Metrics:    metrics.NewHTTPMetrics(r, metrics.HTTPOptions{}),
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+
+		return s, r
+	}
+	send := func(s *Server, path string) chan string {
+		resp := make(chan string, 1)
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+
+		go func() {
+			defer conn.Close()
+			b, _ := io.ReadAll(conn)
+			resp <- string(b)
+		}()
+
+		return resp
+	}
+
+	s, r := start(OverloadReject)
+	first := send(s, "/first")
+	require.Eventually(t, func() bool { return running.Load() == 1 }, 5*time.Second, 5*time.Millisecond)
+	second := send(s, "/second")
+	time.Sleep(50 * time.Millisecond)
+
+	// test: past the queue depth requests get a 503 right away
+	resp := <-send(s, "/third")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 503 Service Unavailable\r\n"), resp)
+	assert.Contains(t, resp, "retry-after: 1\r\n")
+
+	// test: queued requests are served in turn, one at a time
+	close(release)
+	assert.True(t, strings.HasSuffix(<-first, "/first"))
+	assert.True(t, strings.HasSuffix(<-second, "/second"))
+	assert.Equal(t, int32(1), peak.Load())
+
+	var scrape strings.Builder
+	r.WriteTo(&scrape)
+	assert.Contains(t, scrape.String(), "servus_worker_queue_wait_seconds_count 2\n")
+	assert.Contains(t, scrape.String(), "servus_worker_queue_rejected_total 1\n")
+
+	// test: or are dropped without a response
+	release = make(chan struct{})
+	s, _ = start(OverloadDrop)
+	first = send(s, "/first")
+	require.Eventually(t, func() bool { return running.Load() == 1 }, 5*time.Second, 5*time.Millisecond)
+	second = send(s, "/second")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "", <-send(s, "/third"))
+	close(release)
+	<-first
+	<-second
+}
@@ -0,0 +1,134 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidValue = errors.New("invalid or tampered cookie value")
+
+type KeyPair struct {
+	// signs the cookie value with HMAC-SHA256, required
+	HashKey []byte
+	// encrypts the cookie value with AES-GCM when set,
+	// must be 16, 24 or 32 bytes long
+	BlockKey []byte
+}
+
+type Codec struct {
+	keys  []KeyPair
+	aeads []cipher.AEAD
+}
+
+func NewCodec(keys ...KeyPair) (*Codec, error) {
+	/*
+	* @brief: creates a codec signing and optionally encrypting values
+	*
+	* values are always encoded with the first key pair, the others
+	* are only used for decoding so that keys can be rotated
+	*/
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one key pair is required")
+	}
+
+	c := &Codec{keys: keys, aeads: make([]cipher.AEAD, len(keys))}
+	for i, key := range keys {
+		if len(key.HashKey) < 32 {
+			return nil, fmt.Errorf("hash key %d must be at least 32 bytes long", i)
+		}
+
+		if key.BlockKey == nil {
+			continue
+		}
+
+		block, err := aes.NewCipher(key.BlockKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid block key %d: %v", i, err)
+		}
+
+		c.aeads[i], err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (c *Codec) Encode(name string, value []byte) (string, error) {
+	/*
+	* the cookie name is bound to the value as additional data,
+	* so a value cannot be replayed under another cookie name
+	*/
+	payload := value
+	if aead := c.aeads[0]; aead != nil {
+		nonce := make([]byte, aead.NonceSize())
+		_, err := rand.Read(nonce)
+		if err != nil {
+			return "", err
+		}
+
+		payload = aead.Seal(nonce, nonce, value, []byte(name))
+	}
+
+	data := base64.RawURLEncoding.EncodeToString(payload)
+	mac := sign(c.keys[0].HashKey, name, data)
+
+	return data + "." + base64.RawURLEncoding.EncodeToString(mac), nil
+}
+
+func (c *Codec) Decode(name, encoded string) ([]byte, error) {
+	data, macString, found := strings.Cut(encoded, ".")
+	if !found {
+		return nil, ErrInvalidValue
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(macString)
+	if err != nil {
+		return nil, ErrInvalidValue
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return nil, ErrInvalidValue
+	}
+
+	for i, key := range c.keys {
+		if !hmac.Equal(mac, sign(key.HashKey, name, data)) {
+			continue
+		}
+
+		aead := c.aeads[i]
+		if aead == nil {
+			return payload, nil
+		}
+
+		if len(payload) < aead.NonceSize() {
+			return nil, ErrInvalidValue
+		}
+
+		nonce, ciphertext := payload[:aead.NonceSize()], payload[aead.NonceSize():]
+		value, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+		if err != nil {
+			return nil, ErrInvalidValue
+		}
+
+		return value, nil
+	}
+
+	return nil, ErrInvalidValue
+}
+
+func sign(key []byte, name, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(name + "|" + data))
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,286 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"time"
+
+	"Servus/internal/cookie"
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+const maxCookieSize = 4096
+
+type contextKey struct{}
+
+type Session struct {
+	ID        string            `json:"id"`
+	Values    map[string]string `json:"values"`
+	CreatedAt time.Time         `json:"created_at"`
+	LastSeen  time.Time         `json:"last_seen"`
+	ExpiresAt time.Time         `json:"expires_at"`
+
+	isNew      bool
+	modified   bool
+	destroyed  bool
+	previousID string
+}
+
+type Options struct {
+	CookieName string
+	// attributes of the session cookie, name, value and
+	// expiry are filled by the manager
+	Cookie cookie.Cookie
+	// a session unused for this long expires, 0 disables the check
+	IdleTimeout time.Duration
+	// a session older than this expires regardless of use,
+	// 0 disables the check
+	AbsoluteTimeout time.Duration
+}
+
+var DefaultOptions = Options{
+	CookieName: "servus_session",
+	Cookie: cookie.Cookie{
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: cookie.SameSiteLax,
+	},
+	IdleTimeout:     30 * time.Minute,
+	AbsoluteTimeout: 24 * time.Hour,
+}
+
+type Manager struct {
+	store   Store
+	codec   *Codec
+	options Options
+}
+
+func NewManager(store Store, codec *Codec, options Options) *Manager {
+	if options.CookieName == "" {
+		options.CookieName = DefaultOptions.CookieName
+	}
+
+	return &Manager{
+		store:   store,
+		codec:   codec,
+		options: options,
+	}
+}
+
+func FromRequest(req *request.Request) *Session {
+	/*
+	* returns the session attached by Manager.Middleware,
+	* nil if the middleware is not in the handler chain
+	*/
+	s, _ := req.Context().Value(contextKey{}).(*Session)
+
+	return s
+}
+
+func (m *Manager) Middleware(next response.Handler) response.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		s := m.load(req)
+		req.SetContext(context.WithValue(req.Context(), contextKey{}, s))
+
+		// the cookie has to be queued before the headers go out
+		w.OnWriteHeaders(func(h headers.Headers) {
+			err := m.save(w, s)
+			if err != nil {
+				log.Printf("failed to save session: %v", err)
+			}
+		})
+
+		next(w, req)
+	}
+}
+
+func (s *Session) Get(key string) string {
+	return s.Values[key]
+}
+
+func (s *Session) Set(key, value string) {
+	s.Values[key] = value
+	s.modified = true
+}
+
+func (s *Session) Delete(key string) {
+	delete(s.Values, key)
+	s.modified = true
+}
+
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+func (s *Session) Regenerate() {
+	/*
+	* @brief: gives the session a fresh id, to be called when the
+	* privilege level changes (e.g. on login) to prevent fixation
+	*/
+	if s.previousID == "" && !s.isNew {
+		s.previousID = s.ID
+	}
+
+	s.ID = newID()
+	s.modified = true
+}
+
+func (s *Session) Destroy() {
+	s.Values = map[string]string{}
+	s.destroyed = true
+}
+
+func (s *Session) expired(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && now.After(s.ExpiresAt)
+}
+
+func (s *Session) clone() *Session {
+	/*
+	* copies the persisted part of the session, the
+	* per-request flags are left out
+	*/
+	c := Session{
+		ID:        s.ID,
+		CreatedAt: s.CreatedAt,
+		LastSeen:  s.LastSeen,
+		ExpiresAt: s.ExpiresAt,
+	}
+	c.Values = make(map[string]string, len(s.Values))
+	for key, val := range s.Values {
+		c.Values[key] = val
+	}
+
+	return &c
+}
+
+func (m *Manager) load(req *request.Request) *Session {
+	c, err := cookie.Get(req, m.options.CookieName)
+	if err != nil {
+		return newSession()
+	}
+
+	token, err := m.codec.Decode(m.options.CookieName, c.Value)
+	if err != nil {
+		return newSession()
+	}
+
+	s, err := m.store.Load(string(token))
+	if err != nil {
+		log.Printf("failed to load session: %v", err)
+		return newSession()
+	}
+
+	if s == nil {
+		return newSession()
+	}
+
+	if s.expired(time.Now()) {
+		m.store.Delete(s.ID)
+		return newSession()
+	}
+
+	if s.Values == nil {
+		s.Values = map[string]string{}
+	}
+
+	return s
+}
+
+func (m *Manager) save(w *response.Writer, s *Session) error {
+	c := m.options.Cookie
+	c.Name = m.options.CookieName
+
+	if s.destroyed {
+		if !s.isNew {
+			m.store.Delete(s.ID)
+		}
+		if s.previousID != "" {
+			m.store.Delete(s.previousID)
+		}
+
+		c.MaxAge = -1
+		return w.SetCookie(&c)
+	}
+
+	// empty sessions never touched by the handler
+	// do not need a cookie
+	if s.isNew && !s.modified {
+		return nil
+	}
+
+	if s.previousID != "" {
+		err := m.store.Delete(s.previousID)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.LastSeen = time.Now()
+	s.ExpiresAt = m.expiry(s)
+
+	token, err := m.store.Save(s)
+	if err != nil {
+		return err
+	}
+
+	c.Value, err = m.codec.Encode(m.options.CookieName, []byte(token))
+	if err != nil {
+		return err
+	}
+
+	// browsers drop cookies over 4KB, a CookieStore session
+	// this large belongs to a server side store
+	if len(c.Value) > maxCookieSize {
+		return fmt.Errorf("session cookie too large: %d bytes", len(c.Value))
+	}
+
+	if !s.ExpiresAt.IsZero() {
+		c.MaxAge = int(time.Until(s.ExpiresAt).Seconds())
+		if c.MaxAge <= 0 {
+			c.MaxAge = -1
+		}
+	}
+
+	return w.SetCookie(&c)
+}
+
+func (m *Manager) expiry(s *Session) time.Time {
+	expiry := time.Time{}
+	if m.options.IdleTimeout > 0 {
+		expiry = s.LastSeen.Add(m.options.IdleTimeout)
+	}
+
+	if m.options.AbsoluteTimeout > 0 {
+		absolute := s.CreatedAt.Add(m.options.AbsoluteTimeout)
+		if expiry.IsZero() || absolute.Before(expiry) {
+			expiry = absolute
+		}
+	}
+
+	return expiry
+}
+
+func newSession() *Session {
+	now := time.Now()
+
+	return &Session{
+		ID:        newID(),
+		Values:    map[string]string{},
+		CreatedAt: now,
+		LastSeen:  now,
+		isNew:     true,
+	}
+}
+
+func newID() string {
+	b := make([]byte, 32)
+	// crypto/rand.Read never fails on supported platforms
+	rand.Read(b)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
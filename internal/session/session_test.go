@@ -0,0 +1,200 @@
+package session
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+// records what the response writer sends
+type bufferConn struct {
+	net.Conn
+	out bytes.Buffer
+}
+
+func (c *bufferConn) Write(p []byte) (int, error) {
+	return c.out.Write(p)
+}
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func serve(handler response.Handler, cookieHeader string) string {
+	/*
+	* runs handler once and returns the raw 'Set-Cookie'
+	* value it produced, if any
+	*/
+	h := headers.Headers{}
+	if cookieHeader != "" {
+		h.Add("Cookie", cookieHeader)
+	}
+
+	conn := &bufferConn{}
+	w := response.NewResponseWriter(conn)
+	handler(&w, &request.Request{Headers: h})
+
+	for _, line := range strings.Split(conn.out.String(), "\r\n") {
+		value, found := strings.CutPrefix(line, "set-cookie: ")
+		if found {
+			return value
+		}
+	}
+
+	return ""
+}
+
+func respond(w *response.Writer) {
+	w.Response = &response.Response{
+		Code:    response.CodeOK,
+		Headers: headers.GetDefaultHeaders(0),
+	}
+	w.WriteResponse()
+}
+
+func TestCodec(t *testing.T) {
+	// test: signed only
+	codec, err := NewCodec(KeyPair{HashKey: testKey(1)})
+	require.NoError(t, err)
+	encoded, err := codec.Encode("session", []byte("hello"))
+	require.NoError(t, err)
+	value, err := codec.Decode("session", encoded)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(value))
+
+	// test: tampered value
+	_, err = codec.Decode("session", "x"+encoded)
+	require.ErrorIs(t, err, ErrInvalidValue)
+
+	// test: value replayed under another name
+	_, err = codec.Decode("other", encoded)
+	require.ErrorIs(t, err, ErrInvalidValue)
+
+	// test: encrypted value does not leak the plaintext
+	oldCodec, err := NewCodec(KeyPair{HashKey: testKey(2), BlockKey: testKey(3)})
+	require.NoError(t, err)
+	encoded, err = oldCodec.Encode("session", []byte("hello"))
+	require.NoError(t, err)
+	assert.NotContains(t, encoded, "aGVsbG8")
+
+	// test: key rotation keeps old values readable
+	rotated, err := NewCodec(KeyPair{HashKey: testKey(4), BlockKey: testKey(5)}, KeyPair{HashKey: testKey(2), BlockKey: testKey(3)})
+	require.NoError(t, err)
+	value, err = rotated.Decode("session", encoded)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(value))
+
+	// test: short hash key
+	_, err = NewCodec(KeyPair{HashKey: []byte("short")})
+	require.Error(t, err)
+}
+
+func TestManager(t *testing.T) {
+	codec, err := NewCodec(KeyPair{HashKey: testKey(1), BlockKey: testKey(2)})
+	require.NoError(t, err)
+
+	fileStore, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	stores := map[string]Store{
+		"cookie": CookieStore{},
+		"memory": NewMemoryStore(),
+		"file":   fileStore,
+	}
+
+	for name, store := range stores {
+		manager := NewManager(store, codec, DefaultOptions)
+
+		// test: untouched sessions do not set a cookie
+		setCookie := serve(manager.Middleware(func(w *response.Writer, req *request.Request) {
+			require.NotNil(t, FromRequest(req), name)
+			respond(w)
+		}), "")
+		assert.Empty(t, setCookie, name)
+
+		// test: values survive across requests
+		setCookie = serve(manager.Middleware(func(w *response.Writer, req *request.Request) {
+			FromRequest(req).Set("user", "gopher")
+			respond(w)
+		}), "")
+		require.NotEmpty(t, setCookie, name)
+		assert.Contains(t, setCookie, "HttpOnly", name)
+		cookieHeader, _, _ := strings.Cut(setCookie, ";")
+
+		var firstID string
+		setCookie = serve(manager.Middleware(func(w *response.Writer, req *request.Request) {
+			s := FromRequest(req)
+			assert.False(t, s.IsNew(), name)
+			assert.Equal(t, "gopher", s.Get("user"), name)
+			firstID = s.ID
+			s.Regenerate()
+			respond(w)
+		}), cookieHeader)
+		require.NotEmpty(t, setCookie, name)
+		regenerated, _, _ := strings.Cut(setCookie, ";")
+
+		serve(manager.Middleware(func(w *response.Writer, req *request.Request) {
+			s := FromRequest(req)
+			assert.Equal(t, "gopher", s.Get("user"), name)
+			assert.NotEqual(t, firstID, s.ID, name)
+			respond(w)
+		}), regenerated)
+
+		// test: the pre-login id is gone from server side stores
+		if name != "cookie" {
+			serve(manager.Middleware(func(w *response.Writer, req *request.Request) {
+				assert.True(t, FromRequest(req).IsNew(), name)
+				respond(w)
+			}), cookieHeader)
+		}
+
+		// test: destroyed sessions expire the cookie
+		setCookie = serve(manager.Middleware(func(w *response.Writer, req *request.Request) {
+			FromRequest(req).Destroy()
+			respond(w)
+		}), regenerated)
+		assert.Contains(t, setCookie, "Max-Age=0", name)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	codec, err := NewCodec(KeyPair{HashKey: testKey(1)})
+	require.NoError(t, err)
+	store := NewMemoryStore()
+
+	options := DefaultOptions
+	options.IdleTimeout = time.Hour
+	options.AbsoluteTimeout = 2 * time.Hour
+	manager := NewManager(store, codec, options)
+
+	setCookie := serve(manager.Middleware(func(w *response.Writer, req *request.Request) {
+		FromRequest(req).Set("user", "gopher")
+		respond(w)
+	}), "")
+	cookieHeader, _, _ := strings.Cut(setCookie, ";")
+
+	// test: idle timeout
+	for id, s := range store.sessions {
+		s.ExpiresAt = time.Now().Add(-time.Second)
+		store.sessions[id] = s
+	}
+	serve(manager.Middleware(func(w *response.Writer, req *request.Request) {
+		assert.True(t, FromRequest(req).IsNew())
+		respond(w)
+	}), cookieHeader)
+
+	// test: the absolute timeout caps the idle one
+	s := newSession()
+	s.CreatedAt = time.Now().Add(-90 * time.Minute)
+	s.LastSeen = time.Now()
+	assert.WithinDuration(t, s.CreatedAt.Add(2*time.Hour), manager.expiry(s), time.Second)
+}
@@ -0,0 +1,231 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store persists sessions, the token returned by Save is what
+// the session cookie carries (after being signed by the Codec)
+type Store interface {
+	// returns nil and no error when the token references no session
+	Load(token string) (*Session, error)
+	Save(s *Session) (string, error)
+	Delete(id string) error
+}
+
+// CookieStore keeps the whole session in the cookie itself
+type CookieStore struct{}
+
+func (CookieStore) Load(token string) (*Session, error) {
+	s := &Session{}
+	err := json.Unmarshal([]byte(token), s)
+	if err != nil {
+		return nil, nil
+	}
+
+	return s, nil
+}
+
+func (CookieStore) Save(s *Session) (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+func (CookieStore) Delete(id string) error {
+	return nil
+}
+
+type MemoryStore struct {
+	mu        sync.Mutex
+	sessions  map[string]Session
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions:  map[string]Session{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *MemoryStore) Load(token string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[token]
+	if !ok {
+		return nil, nil
+	}
+
+	if s.expired(time.Now()) {
+		delete(m.sessions, token)
+		return nil, nil
+	}
+
+	return s.clone(), nil
+}
+
+func (m *MemoryStore) Save(s *Session) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[s.ID] = *s.clone()
+
+	// expired sessions are dropped at most once a minute
+	now := time.Now()
+	if now.Sub(m.lastSweep) > time.Minute {
+		m.sweep(now)
+	}
+
+	return s.ID, nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+
+	return nil
+}
+
+func (m *MemoryStore) sweep(now time.Time) {
+	for id, s := range m.sessions {
+		if s.expired(now) {
+			delete(m.sessions, id)
+		}
+	}
+
+	m.lastSweep = now
+}
+
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %v", err)
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) Load(token string) (*Session, error) {
+	path, ok := f.path(token)
+	if !ok {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %v", err)
+	}
+
+	s := &Session{}
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode session: %v", err)
+	}
+
+	if s.expired(time.Now()) {
+		os.Remove(path)
+		return nil, nil
+	}
+
+	return s, nil
+}
+
+func (f *FileStore) Save(s *Session) (string, error) {
+	path, ok := f.path(s.ID)
+	if !ok {
+		return "", fmt.Errorf("invalid session id")
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+
+	// write to a temp file and rename it so that
+	// readers never see a partially written session
+	tmp, err := os.CreateTemp(f.dir, ".session-")
+	if err != nil {
+		return "", fmt.Errorf("failed to save session: %v", err)
+	}
+
+	_, err = tmp.Write(data)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to save session: %v", err)
+	}
+
+	return s.ID, nil
+}
+
+func (f *FileStore) Delete(id string) error {
+	path, ok := f.path(id)
+	if !ok {
+		return nil
+	}
+
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (f *FileStore) Cleanup() error {
+	/*
+	* @brief: removes the files of expired sessions
+	*/
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		id, found := strings.CutSuffix(entry.Name(), ".json")
+		if !found {
+			continue
+		}
+
+		// Load drops expired sessions
+		f.Load(id)
+	}
+
+	return nil
+}
+
+func (f *FileStore) path(id string) (string, bool) {
+	// ids are base64url strings, anything else could escape the directory
+	if id == "" || strings.Trim(id, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_") != "" {
+		return "", false
+	}
+
+	return filepath.Join(f.dir, id+".json"), true
+}
@@ -0,0 +1,253 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+const DefaultKeepAlive = 15 * time.Second
+
+var (
+	ErrClosed       = errors.New("sse: stream closed")
+	ErrInvalidField = errors.New("sse: field contains a line break")
+)
+
+type Event struct {
+	ID    string
+	Event string
+	// may span several lines, each one is sent as a 'data' field
+	Data string
+	// tells the client how long to wait before reconnecting
+	Retry time.Duration
+}
+
+type Options struct {
+	// interval between keep-alive comments while no event is
+	// sent, DefaultKeepAlive if zero and disabled if negative
+	KeepAlive time.Duration
+	// reconnection delay advertised when the stream starts
+	Retry time.Duration
+}
+
+type Stream struct {
+	w           *response.Writer
+	lastEventID string
+
+	mu     sync.Mutex
+	closed bool
+	// set when something was written since the last keep-alive tick
+	active bool
+
+	done     chan struct{}
+	doneOnce sync.Once
+	// stops watching the request context
+	stopWatch func() bool
+}
+
+func NewStream(w *response.Writer, req *request.Request, opts *Options) (*Stream, error) {
+	/*
+	* @brief: starts a 'text/event-stream' response on w
+	*
+	* Done is closed as soon as the client goes away, handlers
+	* should return then. the stream must be closed before the
+	* handler returns so the keep-alive comments stop, Handler
+	* takes care of it
+	*/
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	h := headers.Headers{}
+	h.Add("Content-Type", "text/event-stream")
+	h.Add("Cache-Control", "no-cache")
+	h.Add("Transfer-Encoding", "chunked")
+	h.Add("Connection", "close")
+	// keeps reverse proxies from buffering the events
+	h.Add("X-Accel-Buffering", "no")
+
+	err := w.WriteStatusLine(response.CodeOK)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Stream{
+		w:    w,
+		done: make(chan struct{}),
+	}
+	s.lastEventID, _ = req.Headers.Get("Last-Event-ID")
+
+	if opts.Retry > 0 {
+		err = s.write("retry: " + strconv.FormatInt(opts.Retry.Milliseconds(), 10) + "\n\n")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the server cancels the request context when the client
+	// goes away
+	s.stopWatch = context.AfterFunc(req.Context(), s.disconnect)
+
+	keepAlive := opts.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = DefaultKeepAlive
+	}
+	if keepAlive > 0 {
+		go s.keepAlive(keepAlive)
+	}
+
+	return s, nil
+}
+
+func Handler(opts *Options, serve func(s *Stream, req *request.Request)) response.Handler {
+	/*
+	* @brief: starts a stream for every request and runs serve
+	* with it, the stream is closed when serve returns
+	*/
+	return func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, opts)
+		if err != nil {
+			return
+		}
+		defer s.Close()
+
+		serve(s, req)
+	}
+}
+
+func (s *Stream) LastEventID() string {
+	/*
+	* @brief: returns the id of the last event a reconnecting
+	* client received, empty on the first connection
+	*/
+	return s.lastEventID
+}
+
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Stream) Send(e Event) error {
+	/*
+	* @brief: sends an event, it is flushed to the client
+	* before Send returns
+	*/
+	if strings.ContainsAny(e.ID, "\r\n\x00") || strings.ContainsAny(e.Event, "\r\n") {
+		return ErrInvalidField
+	}
+
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	return s.write(b.String())
+}
+
+func (s *Stream) Comment(text string) error {
+	/*
+	* @brief: sends a comment line, ignored by clients but
+	* keeps idle connections from being dropped
+	*/
+	if strings.ContainsAny(text, "\r\n") {
+		return ErrInvalidField
+	}
+
+	return s.write(": " + text + "\n\n")
+}
+
+func (s *Stream) Close() error {
+	/*
+	* @brief: ends the response, no event or keep-alive comment
+	* is sent afterwards
+	*/
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.stopWatch()
+	s.disconnect()
+
+	return s.w.WriteChunkedBodyDone()
+}
+
+func (s *Stream) write(p string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	select {
+	case <-s.done:
+		return ErrClosed
+	default:
+	}
+
+	_, err := s.w.WriteChunkedBody([]byte(p))
+	if err != nil {
+		s.disconnect()
+		return fmt.Errorf("sse: %w", err)
+	}
+	s.active = true
+
+	return nil
+}
+
+func (s *Stream) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		idle := !s.active
+		s.active = false
+		s.mu.Unlock()
+
+		if idle {
+			s.Comment("keep-alive")
+		}
+	}
+}
+
+func (s *Stream) disconnect() {
+	s.doneOnce.Do(func() { close(s.done) })
+}
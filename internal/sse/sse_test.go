@@ -0,0 +1,146 @@
+package sse
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Servus/internal/request"
+	"Servus/internal/response"
+	"Servus/internal/server"
+)
+
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	head   string
+}
+
+func dial(t *testing.T, handler response.Handler, extra string) *testClient {
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte("GET /events HTTP/1.1\r\nHost: localhost\r\nAccept: text/event-stream\r\n" + extra + "\r\n"))
+	require.NoError(t, err)
+
+	c := &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	for {
+		line, err := c.reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		c.head += line
+	}
+
+	return c
+}
+
+func (c *testClient) chunk() string {
+	/*
+	* reads a single chunk of the body, empty once it ended
+	*/
+	line, err := c.reader.ReadString('\n')
+	require.NoError(c.t, err)
+	size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+	require.NoError(c.t, err)
+
+	data := make([]byte, size+2)
+	_, err = io.ReadFull(c.reader, data)
+	require.NoError(c.t, err)
+
+	return string(data[:size])
+}
+
+func TestStream(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, &Options{Retry: 3 * time.Second, KeepAlive: -1})
+		if err != nil {
+			return
+		}
+		defer s.Close()
+
+		s.Send(Event{ID: "42", Event: "resume", Data: "after " + s.LastEventID()})
+		s.Send(Event{Data: "line one\nline two\r\nline three"})
+		assert.ErrorIs(t, s.Send(Event{ID: "bad\nid"}), ErrInvalidField)
+	}
+
+	c := dial(t, handler, "Last-Event-ID: 41\r\n")
+	assert.Contains(t, c.head, "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, c.head, "content-type: text/event-stream\r\n")
+	assert.Contains(t, c.head, "transfer-encoding: chunked\r\n")
+
+	// test: every event is its own chunk
+	assert.Equal(t, "retry: 3000\n\n", c.chunk())
+	assert.Equal(t, "id: 42\nevent: resume\ndata: after 41\n\n", c.chunk())
+	assert.Equal(t, "data: line one\ndata: line two\ndata: line three\n\n", c.chunk())
+	assert.Equal(t, "", c.chunk())
+}
+
+func TestKeepAlive(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, &Options{KeepAlive: 10 * time.Millisecond})
+		if err != nil {
+			return
+		}
+		<-s.Done()
+	}
+
+	c := dial(t, handler, "")
+	assert.Equal(t, ": keep-alive\n\n", c.chunk())
+}
+
+func TestDisconnect(t *testing.T) {
+	stopped := make(chan struct{})
+	handler := func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, &Options{KeepAlive: -1})
+		if err != nil {
+			return
+		}
+		s.Send(Event{Data: "hello"})
+
+		select {
+		case <-s.Done():
+			assert.ErrorIs(t, s.Send(Event{Data: "gone"}), ErrClosed)
+			close(stopped)
+		case <-time.After(5 * time.Second):
+			t.Error("disconnect was not detected")
+		}
+	}
+
+	c := dial(t, handler, "")
+	assert.Equal(t, "data: hello\n\n", c.chunk())
+	c.conn.Close()
+
+	<-stopped
+}
+
+func TestHandler(t *testing.T) {
+	handler := Handler(&Options{KeepAlive: 5 * time.Millisecond}, func(s *Stream, req *request.Request) {
+		s.Send(Event{Data: "only"})
+		time.Sleep(20 * time.Millisecond)
+	})
+
+	c := dial(t, handler, "")
+	assert.Equal(t, "data: only\n\n", c.chunk())
+
+	// test: keep-alive comments stop with the handler
+	rest, err := io.ReadAll(c.reader)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(rest), "\r\n0\r\n\r\n") || string(rest) == "0\r\n\r\n", string(rest))
+	assert.Equal(t, 1, strings.Count(string(rest), "0\r\n\r\n"))
+}
@@ -0,0 +1,134 @@
+package timeout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+// ErrHandlerTimeout is returned by the writes of a handler that ran
+// out of time, it is also the cause of its context cancellation
+var ErrHandlerTimeout = errors.New("timeout: handler timed out")
+
+type Options struct {
+	// time the handler has to complete its response
+	Timeout time.Duration
+	// status of the response sent instead, CodeServiceUnavailable
+	// when zero. CodeGatewayTimeout suits handlers waiting on an
+	// upstream
+	Code response.StatusCode
+	// body of that response, the status code and text when empty
+	Body string
+	// 'text/plain' when empty
+	ContentType string
+}
+
+func Middleware(opts Options) response.Middleware {
+	/*
+	* @brief: runs the handler with a deadline on its context, if
+	* it has not completed its response in time the client gets
+	* the timeout response instead
+	*
+	* the handler runs in its own goroutine and its response is
+	* held in memory until it returns, so streamed responses only
+	* go out at the end. once the deadline passed its writes fail
+	* with ErrHandlerTimeout, and after the timeout response the
+	* middleware still waits for it to return: the request, its
+	* temp files and the worker running it are not released under
+	* a handler that is still busy. a handler that hijacks the
+	* connection is no longer timed and keeps its context
+	*
+	* panics when Timeout is not positive
+	*/
+	if opts.Timeout <= 0 {
+		panic(fmt.Sprintf("timeout: invalid timeout %v", opts.Timeout))
+	}
+
+	code := opts.Code
+	if code == 0 {
+		code = response.CodeServiceUnavailable
+	}
+
+	body := opts.Body
+	if body == "" {
+		body = fmt.Sprintf("%d %s", code, response.StatusText(code))
+	}
+
+	return func(next response.Handler) response.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			// cancelled at the deadline only once the response is
+			// discarded, a hijacked connection is not timed
+			ctx, cancel := context.WithCancelCause(req.Context())
+			defer cancel(context.Canceled)
+			req.SetContext(ctx)
+
+			timer := time.NewTimer(opts.Timeout)
+			defer timer.Stop()
+
+			buf := response.NewBuffer(w)
+			done := make(chan any, 1)
+			go func() {
+				defer func() {
+					done <- recover()
+				}()
+
+				next(buf.Writer(), req)
+			}()
+
+			timedOut := false
+			select {
+			case panicking := <-done:
+				if panicking != nil {
+					// rethrown for the server to recover
+					panic(panicking)
+				}
+				buf.Flush()
+				return
+
+			case <-timer.C:
+				timedOut = true
+			case <-ctx.Done():
+			}
+
+			if !buf.Discard(ErrHandlerTimeout) {
+				// hijacked, the handler owns the connection
+				rethrow(<-done)
+				return
+			}
+
+			// the client went away or the server is closing,
+			// there is nobody to answer
+			if !timedOut {
+				rethrow(<-done)
+				return
+			}
+			cancel(ErrHandlerTimeout)
+
+			h := headers.GetDefaultHeaders(len(body))
+			if opts.ContentType != "" {
+				h.AddOverride("Content-Type", opts.ContentType)
+			}
+			w.Response = &response.Response{
+				Code:    code,
+				Message: []byte(body),
+				Headers: h,
+			}
+			w.WriteResponse()
+
+			rethrow(<-done)
+		}
+	}
+}
+
+func rethrow(panicking any) {
+	// a late panic reaches the server, which only logs it once
+	// a response was sent
+	if panicking != nil {
+		panic(panicking)
+	}
+}
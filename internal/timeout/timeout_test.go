@@ -0,0 +1,153 @@
+package timeout
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Servus/internal/cookie"
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/requestid"
+	"Servus/internal/response"
+	"Servus/internal/server"
+)
+
+func TestTimeout(t *testing.T) {
+	errs := make(chan error, 1)
+	handler := func(w *response.Writer, req *request.Request) {
+		switch req.Path() {
+		case "/panic":
+			panic("boom")
+
+		case "/hijack":
+			// outlives the deadline on its own connection
+			conn, _, err := w.Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			time.Sleep(100 * time.Millisecond)
+			fmt.Fprintf(conn, "context: %v", req.Context().Err())
+			return
+
+		case "/slow":
+			// starts answering, then stalls past the deadline
+			w.WriteStatusLine(response.CodeOK)
+			w.WriteHeaders(headers.GetDefaultHeaders(4))
+			<-req.Context().Done()
+			time.Sleep(20 * time.Millisecond)
+
+			_, err := w.WriteBody([]byte("late"))
+			if context.Cause(req.Context()) != ErrHandlerTimeout {
+				err = context.Cause(req.Context())
+			}
+			errs <- err
+			return
+		}
+
+		w.SetCookie(&cookie.Cookie{Name: "seen", Value: "1"})
+		body := "fast"
+		w.Response = &response.Response{
+			Code:    response.CodeOK,
+			Message: []byte(body),
+			Headers: headers.GetDefaultHeaders(len(body)),
+		}
+		w.WriteResponse()
+	}
+
+	// whether the handler had returned when the middleware did
+	waited := make(chan bool, 1)
+	outer := func(next response.Handler) response.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			next(w, req)
+			if req.Path() == "/slow" {
+				waited <- len(errs) == 1
+			}
+		}
+	}
+
+	serve := func(opts Options) string {
+		s, err := server.Serve(0, response.Chain(handler,
+			outer,
+			requestid.Middleware(requestid.Options{}),
+			Middleware(opts),
+		))
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+
+		return s.Addr().String()
+	}
+	send := func(addr, path string) string {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		_, err = conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+
+		return string(resp)
+	}
+
+	addr := serve(Options{Timeout: 50 * time.Millisecond})
+
+	// test: responses in time go through with hooks and cookies
+	resp := send(addr, "/fast")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"), resp)
+	assert.Contains(t, resp, "x-request-id: ")
+	assert.Contains(t, resp, "set-cookie: seen=1\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nfast"), resp)
+
+	// test: a stalled response is replaced, nothing of it is sent
+	resp = send(addr, "/slow")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 503 Service Unavailable\r\n"), resp)
+	assert.Contains(t, resp, "x-request-id: ")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n503 Service Unavailable"), resp)
+	assert.NotContains(t, resp, "200 OK")
+
+	// test: the middleware returns after the handler
+	assert.True(t, <-waited)
+
+	// test: later writes fail
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, ErrHandlerTimeout)
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not return")
+	}
+
+	// test: a hijacking handler is no longer timed
+	assert.Equal(t, "context: <nil>", send(addr, "/hijack"))
+
+	// test: panics still reach the server
+	resp = send(addr, "/panic")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 500 Internal Server Error\r\n"), resp)
+
+	// test: configurable response
+	addr = serve(Options{
+		Timeout:     50 * time.Millisecond,
+		Code:        response.CodeGatewayTimeout,
+		Body:        `{"error":"upstream too slow"}`,
+		ContentType: "application/json",
+	})
+	resp = send(addr, "/slow")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 504 Gateway Timeout\r\n"), resp)
+	assert.Contains(t, resp, "content-type: application/json\r\n")
+	assert.True(t, strings.HasSuffix(resp, `{"error":"upstream too slow"}`), resp)
+	<-waited
+	<-errs
+
+	// test: a timeout that is not positive is refused
+	assert.Panics(t, func() { Middleware(Options{}) })
+	assert.Panics(t, func() { Middleware(Options{Timeout: -time.Second}) })
+}
@@ -0,0 +1,128 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// how often the certificate files are checked for changes
+const reloadCheckInterval = time.Second
+
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.RWMutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	lastCheck   time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	/*
+	* @brief: loads a certificate/key pair and reloads it whenever
+	* one of the files changes on disk
+	*
+	* files are checked lazily during handshakes, at most once every
+	* reloadCheckInterval, so no background goroutine is needed
+	*/
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	err := r.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *CertReloader) Certificate() (*tls.Certificate, error) {
+	r.mu.RLock()
+	cert := r.cert
+	due := time.Since(r.lastCheck) > reloadCheckInterval
+	r.mu.RUnlock()
+
+	if !due {
+		return cert, nil
+	}
+
+	changed, err := r.changed()
+	if err != nil || !changed {
+		// a file being replaced may be missing for a moment,
+		// keep serving the last good certificate meanwhile
+		r.touch()
+		return cert, nil
+	}
+
+	err = r.reload()
+	if err != nil {
+		r.touch()
+		return cert, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate()
+}
+
+func (r *CertReloader) reload() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat certificate: %v", err)
+	}
+
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat key: %v", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load key pair: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+	r.lastCheck = time.Now()
+
+	return nil
+}
+
+func (r *CertReloader) changed() (bool, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false, err
+	}
+
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return !certInfo.ModTime().Equal(r.certModTime) || !keyInfo.ModTime().Equal(r.keyModTime), nil
+}
+
+func (r *CertReloader) touch() {
+	r.mu.Lock()
+	r.lastCheck = time.Now()
+	r.mu.Unlock()
+}
@@ -0,0 +1,114 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type CertFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+type Options struct {
+	// default certificate, served when no host matches the SNI name
+	CertFile string
+	KeyFile  string
+	// certificates selected by SNI server name, names may
+	// be wildcards like "*.example.com"
+	Hosts map[string]CertFiles
+	// PEM bundle of the CAs signing client certificates, setting it enables mTLS
+	ClientCAFile string
+	// when false, client certificates are verified only if presented
+	RequireClientCert bool
+	// defaults to TLS 1.2
+	MinVersion uint16
+}
+
+type sniSelector struct {
+	hosts    map[string]*CertReloader
+	fallback *CertReloader
+}
+
+func New(options Options) (*tls.Config, error) {
+	/*
+	* @brief: builds a server tls.Config whose certificates are
+	* picked by SNI and hot reloaded from disk
+	*/
+	selector := &sniSelector{hosts: map[string]*CertReloader{}}
+
+	if options.CertFile != "" || options.KeyFile != "" {
+		reloader, err := NewCertReloader(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		selector.fallback = reloader
+	}
+
+	for host, files := range options.Hosts {
+		reloader, err := NewCertReloader(files.CertFile, files.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("certificate for %s: %v", host, err)
+		}
+		selector.hosts[strings.ToLower(host)] = reloader
+	}
+
+	if selector.fallback == nil && len(selector.hosts) == 0 {
+		return nil, fmt.Errorf("no certificate configured")
+	}
+
+	config := &tls.Config{
+		MinVersion:     options.MinVersion,
+		GetCertificate: selector.GetCertificate,
+	}
+
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	if options.ClientCAFile != "" {
+		pem, err := os.ReadFile(options.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in client CA file")
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if options.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return config, nil
+}
+
+func (s *sniSelector) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	reloader, ok := s.hosts[name]
+	if !ok {
+		// *.example.com matches a single label only
+		_, parent, found := strings.Cut(name, ".")
+		if found {
+			reloader, ok = s.hosts["*."+parent]
+		}
+	}
+
+	if !ok {
+		reloader = s.fallback
+	}
+
+	if reloader == nil {
+		return nil, fmt.Errorf("no certificate for server name %q", hello.ServerName)
+	}
+
+	return reloader.Certificate()
+}
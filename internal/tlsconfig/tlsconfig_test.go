@@ -0,0 +1,130 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeSelfSigned(t *testing.T, dir, name string, hosts ...string) CertFiles {
+	/*
+	* generates a self signed certificate for hosts and writes
+	* it to <dir>/<name>.crt and <dir>/<name>.key
+	*/
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	files := CertFiles{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	require.NoError(t, os.WriteFile(files.CertFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(files.KeyFile, keyPEM, 0o600))
+
+	return files
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	files := writeSelfSigned(t, dir, "first", "localhost")
+
+	reloader, err := NewCertReloader(files.CertFile, files.KeyFile)
+	require.NoError(t, err)
+	cert, err := reloader.Certificate()
+	require.NoError(t, err)
+	assert.Equal(t, "first", commonName(t, cert))
+
+	// test: replaced files are picked up
+	second := writeSelfSigned(t, dir, "second", "localhost")
+	require.NoError(t, os.Rename(second.CertFile, files.CertFile))
+	require.NoError(t, os.Rename(second.KeyFile, files.KeyFile))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(files.CertFile, future, future))
+	reloader.lastCheck = time.Time{}
+
+	cert, err = reloader.Certificate()
+	require.NoError(t, err)
+	assert.Equal(t, "second", commonName(t, cert))
+
+	// test: a broken file keeps the last good certificate
+	require.NoError(t, os.WriteFile(files.CertFile, []byte("garbage"), 0o600))
+	future = future.Add(time.Minute)
+	require.NoError(t, os.Chtimes(files.CertFile, future, future))
+	reloader.lastCheck = time.Time{}
+
+	cert, err = reloader.Certificate()
+	require.NoError(t, err)
+	assert.Equal(t, "second", commonName(t, cert))
+}
+
+func TestSNI(t *testing.T) {
+	dir := t.TempDir()
+	fallback := writeSelfSigned(t, dir, "fallback", "localhost")
+
+	config, err := New(Options{
+		CertFile: fallback.CertFile,
+		KeyFile:  fallback.KeyFile,
+		Hosts: map[string]CertFiles{
+			"api.example.com": writeSelfSigned(t, dir, "api", "api.example.com"),
+			"*.example.org":   writeSelfSigned(t, dir, "wildcard", "*.example.org"),
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+
+	tests := map[string]string{
+		"api.example.com":   "api",
+		"API.example.com.":  "api",
+		"www.example.org":   "wildcard",
+		"a.b.example.org":   "fallback",
+		"other.example.com": "fallback",
+		"":                  "fallback",
+	}
+
+	for serverName, expected := range tests {
+		cert, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+		require.NoError(t, err)
+		assert.Equal(t, expected, commonName(t, cert), serverName)
+	}
+
+	// test: no certificate at all
+	_, err = New(Options{})
+	require.Error(t, err)
+}
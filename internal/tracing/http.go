@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"time"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+func (t *Tracer) Serve(w *response.Writer, req *request.Request, readStart, readEnd time.Time, handler response.Handler) {
+	/*
+	* @brief: runs handler inside a server span continuing the
+	* trace of the request, it is called by the server when the
+	* tracer is set in server.Config
+	*
+	* the span gets 'parse', 'handler' and 'write' children: the
+	* time spent reading the request, running the handler until
+	* the response head goes out, and sending the response. the
+	* parse one is left out when readStart is zero
+	*
+	* the request context carries the handler span so handlers can
+	* add their own, and the request 'traceparent' is replaced with
+	* the server span so proxied requests continue the trace
+	*/
+	start := readStart
+	if start.IsZero() {
+		start = time.Now()
+	}
+
+	ctx := req.Context()
+	if parent, ok := Extract(req.Headers); ok {
+		ctx = ContextWithRemote(ctx, parent)
+	}
+
+	method := req.RequestLine.Method
+	route := ""
+	if t.route != nil {
+		route = t.route(req)
+	}
+
+	name := method
+	if route != "" {
+		name += " " + route
+	}
+
+	ctx, span := t.StartAt(ctx, name, SpanKindServer, start)
+	span.SetAttribute("http.request.method", method)
+	span.SetAttribute("url.path", req.Path())
+	span.SetAttribute("network.protocol.version", req.RequestLine.HttpVersion)
+	span.SetAttribute("http.request.body.size", len(req.Body))
+	if route != "" {
+		span.SetAttribute("http.route", route)
+	}
+	if agent, ok := req.Headers.Get("user-agent"); ok {
+		span.SetAttribute("user_agent.original", agent)
+	}
+
+	if !readStart.IsZero() {
+		_, parse := t.StartAt(ctx, "parse", SpanKindInternal, readStart)
+		parse.EndAt(readEnd)
+	}
+
+	Inject(span.SpanContext(), req.Headers)
+
+	handlerCtx, handlerSpan := t.StartAt(ctx, "handler", SpanKindInternal, time.Now())
+	req.SetContext(handlerCtx)
+
+	var writeStart time.Time
+	w.OnWriteHeaders(func(headers.Headers) {
+		writeStart = time.Now()
+	})
+
+	defer func() {
+		end := time.Now()
+		if writeStart.IsZero() {
+			handlerSpan.EndAt(end)
+		} else {
+			handlerSpan.EndAt(writeStart)
+			_, write := t.StartAt(ctx, "write", SpanKindInternal, writeStart)
+			write.EndAt(end)
+		}
+
+		status := int(w.StatusCode())
+		if status != 0 {
+			span.SetAttribute("http.response.status_code", status)
+		}
+		span.SetAttribute("http.response.body.size", w.BytesWritten())
+		// client errors are not failures of the server
+		if status >= 500 {
+			span.SetStatus(StatusError, "")
+		}
+		span.EndAt(end)
+	}()
+
+	handler(w, req)
+}
@@ -0,0 +1,225 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"Servus/internal/client"
+)
+
+const (
+	DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+	DefaultServiceName  = "servus"
+	DefaultOTLPTimeout  = 10 * time.Second
+
+	scopeName = "Servus/internal/tracing"
+)
+
+type OTLPOptions struct {
+	// collector url, DefaultOTLPEndpoint when empty
+	Endpoint string
+	// 'service.name' of the resource, DefaultServiceName when empty
+	ServiceName string
+	// sent with every export, e.g. for collector authentication
+	Headers map[string]string
+	// DefaultOTLPTimeout when zero
+	Timeout time.Duration
+}
+
+// OTLPExporter posts spans to an OpenTelemetry collector with the
+// OTLP/HTTP JSON encoding
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	headers     map[string]string
+	client      *client.Client
+}
+
+// the OTLP/JSON encoding of ExportTraceServiceRequest, ids are hex
+// and 64 bit integers are strings as protobuf's JSON mapping wants
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+func NewOTLPExporter(opts OTLPOptions) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint:    opts.Endpoint,
+		serviceName: opts.ServiceName,
+		headers:     opts.Headers,
+	}
+
+	if e.endpoint == "" {
+		e.endpoint = DefaultOTLPEndpoint
+	}
+	if e.serviceName == "" {
+		e.serviceName = DefaultServiceName
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultOTLPTimeout
+	}
+	e.client = client.New(client.Options{
+		DialTimeout:           timeout,
+		ResponseHeaderTimeout: timeout,
+		ReadTimeout:           timeout,
+		MaxRedirects:          -1,
+	})
+
+	return e
+}
+
+func (e *OTLPExporter) Export(spans []SpanData) error {
+	/*
+	* @brief: posts spans in a single request, any status
+	* other than 2xx is an error
+	*/
+	body, err := json.Marshal(e.encode(spans))
+	if err != nil {
+		return fmt.Errorf("encoding spans: %w", err)
+	}
+
+	req, err := client.NewRequest("POST", e.endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Headers.AddOverride("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Headers.AddOverride(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	// read so the connection can be reused
+	message, _ := resp.ReadBody()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector answered %d: %.200s", resp.StatusCode, message)
+	}
+
+	return nil
+}
+
+func (e *OTLPExporter) Close() {
+	e.client.Close()
+}
+
+func (e *OTLPExporter) encode(spans []SpanData) otlpRequest {
+	encoded := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		encoded[i] = otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			TraceState:        s.TraceState,
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        encodeAttributes(s.Attributes),
+			Status:            otlpStatus{Code: s.Status, Message: s.StatusMessage},
+		}
+		if s.ParentSpanID != (SpanID{}) {
+			encoded[i].ParentSpanID = s.ParentSpanID.String()
+		}
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: encodeAttributes(map[string]any{
+			"service.name": e.serviceName,
+		})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: scopeName},
+			Spans: encoded,
+		}},
+	}}}
+}
+
+func encodeAttributes(attributes map[string]any) []otlpKeyValue {
+	/*
+	* sorted by key, values of other types are sent as
+	* their fmt representation
+	*/
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	encoded := make([]otlpKeyValue, len(keys))
+	for i, key := range keys {
+		var value otlpValue
+		switch v := attributes[key].(type) {
+		case string:
+			value.StringValue = &v
+		case bool:
+			value.BoolValue = &v
+		case int:
+			n := strconv.FormatInt(int64(v), 10)
+			value.IntValue = &n
+		case int64:
+			n := strconv.FormatInt(v, 10)
+			value.IntValue = &n
+		case float64:
+			value.DoubleValue = &v
+		default:
+			s := fmt.Sprint(v)
+			value.StringValue = &s
+		}
+		encoded[i] = otlpKeyValue{Key: key, Value: value}
+	}
+
+	return encoded
+}
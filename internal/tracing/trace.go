@@ -0,0 +1,493 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = iota + 1
+	SpanKindServer
+	SpanKindClient
+)
+
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+const (
+	DefaultBatchSize     = 512
+	DefaultFlushInterval = 5 * time.Second
+	DefaultMaxQueue      = 2048
+
+	flagSampled = 0x01
+	// longest tracestate propagated, W3C Trace Context section 3.3.1.1
+	maxTraceStateLength = 512
+)
+
+// SpanContext identifies a span across process boundaries, it is
+// what 'traceparent' and 'tracestate' carry
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+	// received from another process
+	Remote bool
+}
+
+// SpanData is a finished span as exporters receive it
+type SpanData struct {
+	Name          string
+	Kind          SpanKind
+	TraceID       TraceID
+	SpanID        SpanID
+	ParentSpanID  SpanID
+	TraceState    string
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]any
+	Status        StatusCode
+	StatusMessage string
+}
+
+// Exporter sends finished spans somewhere, Export is called from
+// a single goroutine
+type Exporter interface {
+	Export(spans []SpanData) error
+}
+
+type Options struct {
+	Exporter Exporter
+	// fraction of new traces recorded, 1 when zero and none when
+	// negative. requests continuing a trace follow its decision
+	SampleRatio float64
+	// spans sent per Export call, DefaultBatchSize when zero
+	BatchSize int
+	// longest time a span waits to be exported,
+	// DefaultFlushInterval when zero
+	FlushInterval time.Duration
+	// spans waiting for export, later ones are dropped,
+	// DefaultMaxQueue when zero
+	MaxQueue int
+	// route of a request, added to the server span name and
+	// attributes when set. it must not return values chosen
+	// by clients
+	Route func(req *request.Request) string
+}
+
+// Tracer creates spans and hands the sampled ones to the exporter
+// in batches from a background goroutine
+type Tracer struct {
+	exporter      Exporter
+	sampleRatio   float64
+	batchSize     int
+	flushInterval time.Duration
+	route         func(req *request.Request) string
+
+	queue     chan SpanData
+	flush     chan chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type Span struct {
+	tracer  *Tracer
+	sampled bool
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+type contextKey struct{}
+
+func NewTracer(opts Options) *Tracer {
+	t := &Tracer{
+		exporter:      opts.Exporter,
+		sampleRatio:   opts.SampleRatio,
+		batchSize:     opts.BatchSize,
+		flushInterval: opts.FlushInterval,
+		route:         opts.Route,
+		flush:         make(chan chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	if t.sampleRatio == 0 {
+		t.sampleRatio = 1
+	}
+	if t.batchSize <= 0 {
+		t.batchSize = DefaultBatchSize
+	}
+	if t.flushInterval <= 0 {
+		t.flushInterval = DefaultFlushInterval
+	}
+
+	maxQueue := opts.MaxQueue
+	if maxQueue <= 0 {
+		maxQueue = DefaultMaxQueue
+	}
+	t.queue = make(chan SpanData, maxQueue)
+
+	go t.run()
+
+	return t
+}
+
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	/*
+	* @brief: starts an internal span, child of the span in ctx
+	*/
+	return t.StartAt(ctx, name, SpanKindInternal, time.Now())
+}
+
+func (t *Tracer) StartAt(ctx context.Context, name string, kind SpanKind, start time.Time) (context.Context, *Span) {
+	/*
+	* @brief: starts a span at the given time, child of the span
+	* in ctx or of the remote span put there by ContextWithRemote
+	*/
+	var parent SpanContext
+	if s := SpanFromContext(ctx); s != nil {
+		parent = s.SpanContext()
+	} else if remote, ok := ctx.Value(contextKey{}).(SpanContext); ok {
+		parent = remote
+	}
+
+	span := &Span{tracer: t}
+	span.data = SpanData{
+		Name:       name,
+		Kind:       kind,
+		SpanID:     newSpanID(),
+		Start:      start,
+		Attributes: map[string]any{},
+	}
+
+	if parent.TraceID != (TraceID{}) {
+		span.data.TraceID = parent.TraceID
+		span.data.ParentSpanID = parent.SpanID
+		span.data.TraceState = parent.TraceState
+		span.sampled = parent.Flags&flagSampled != 0
+	} else {
+		span.data.TraceID = newTraceID()
+		span.sampled = t.sample(span.data.TraceID)
+	}
+
+	return context.WithValue(ctx, contextKey{}, span), span
+}
+
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	/*
+	* @brief: exports the spans still queued and stops the
+	* background goroutine, spans ended afterwards are dropped
+	*/
+	done := make(chan struct{})
+	select {
+	case t.flush <- done:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	t.closeOnce.Do(func() { close(t.done) })
+
+	return nil
+}
+
+func (t *Tracer) sample(id TraceID) bool {
+	/*
+	* the decision depends on the trace ID only, so every
+	* service sampling at the same ratio agrees
+	*/
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	if t.sampleRatio <= 0 {
+		return false
+	}
+
+	return float64(binary.BigEndian.Uint64(id[8:])>>11)/(1<<53) < t.sampleRatio
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(t.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, t.batchSize)
+	export := func() {
+		if len(batch) == 0 || t.exporter == nil {
+			batch = batch[:0]
+			return
+		}
+
+		err := t.exporter.Export(batch)
+		if err != nil {
+			log.Printf("tracing: failed to export %d spans: %v", len(batch), err)
+		}
+		batch = make([]SpanData, 0, t.batchSize)
+	}
+
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= t.batchSize {
+				export()
+			}
+
+		case <-ticker.C:
+			export()
+
+		case done := <-t.flush:
+			for len(t.queue) > 0 {
+				batch = append(batch, <-t.queue)
+				if len(batch) >= t.batchSize {
+					export()
+				}
+			}
+			export()
+			close(done)
+
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.ended {
+		s.data.Attributes[key] = value
+	}
+}
+
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.ended {
+		s.data.Status = code
+		s.data.StatusMessage = message
+	}
+}
+
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.ended {
+		s.data.Name = name
+	}
+}
+
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+func (s *Span) EndAt(end time.Time) {
+	/*
+	* @brief: finishes the span, later calls are ignored
+	*/
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = end
+	data := s.data
+	s.mu.Unlock()
+
+	if !s.sampled {
+		return
+	}
+
+	select {
+	case <-s.tracer.done:
+	case s.tracer.queue <- data:
+	default:
+		// the exporter cannot keep up, spans are dropped
+		// rather than slowing requests down
+	}
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sc := SpanContext{
+		TraceID:    s.data.TraceID,
+		SpanID:     s.data.SpanID,
+		TraceState: s.data.TraceState,
+	}
+	if s.sampled {
+		sc.Flags = flagSampled
+	}
+
+	return sc
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	/*
+	* returns the current span, nil if there is none. the
+	* methods of a nil span do nothing
+	*/
+	s, _ := ctx.Value(contextKey{}).(*Span)
+
+	return s
+}
+
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	/*
+	* @brief: makes sc, received from another process, the
+	* parent of the spans started from ctx
+	*/
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+func Extract(h headers.Headers) (SpanContext, bool) {
+	/*
+	* @brief: reads the span context of 'traceparent' and
+	* 'tracestate', version 00 and unknown higher versions
+	* are accepted
+	*/
+	value, ok := h.Get("traceparent")
+	if !ok {
+		return SpanContext{}, false
+	}
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) {
+
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	if !decodeHex(parts[0], make([]byte, 1)) || !decodeHex(parts[1], sc.TraceID[:]) ||
+		!decodeHex(parts[2], sc.SpanID[:]) {
+
+		return SpanContext{}, false
+	}
+
+	flags := make([]byte, 1)
+	if !decodeHex(parts[3], flags) {
+		return SpanContext{}, false
+	}
+	sc.Flags = flags[0]
+
+	if sc.TraceID == (TraceID{}) || sc.SpanID == (SpanID{}) {
+		return SpanContext{}, false
+	}
+
+	state, _ := h.Get("tracestate")
+	state = strings.TrimSpace(state)
+	if len(state) <= maxTraceStateLength {
+		sc.TraceState = state
+	}
+	sc.Remote = true
+
+	return sc, true
+}
+
+func Inject(sc SpanContext, h headers.Headers) {
+	/*
+	* @brief: writes sc as 'traceparent' and 'tracestate'
+	*/
+	h.AddOverride("traceparent", sc.Traceparent())
+	if sc.TraceState != "" {
+		h.AddOverride("tracestate", sc.TraceState)
+	} else {
+		h.Delete("tracestate")
+	}
+}
+
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + hex.EncodeToString([]byte{sc.Flags & flagSampled})
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func decodeHex(s string, dst []byte) bool {
+	/*
+	* only lowercase hex of the exact length is valid
+	*/
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+
+	_, err := hex.Decode(dst, []byte(s))
+
+	return err == nil
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id == (TraceID{}) {
+		rand.Read(id[:])
+	}
+
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id == (SpanID{}) {
+		rand.Read(id[:])
+	}
+
+	return id
+}
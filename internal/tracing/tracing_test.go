@@ -0,0 +1,199 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+)
+
+type recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (r *recorder) Export(spans []SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+
+	return nil
+}
+
+// collector stands in for an OpenTelemetry collector, it answers
+// every export with status and hands the requests over
+func collector(t *testing.T, status string) (string, chan *request.Request) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	received := make(chan *request.Request, 8)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			req, err := request.RequestFromReader(conn)
+			if err == nil {
+				received <- req
+				conn.Write([]byte("HTTP/1.1 " + status + "\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+			}
+			conn.Close()
+		}
+	}()
+
+	return "http://" + l.Addr().String() + "/v1/traces", received
+}
+
+func TestPropagation(t *testing.T) {
+	h := headers.Headers{}
+	h.Add("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.Add("tracestate", "vendor=value")
+
+	// test: traceparent and tracestate are parsed
+	sc, ok := Extract(h)
+	require.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.Equal(t, byte(1), sc.Flags)
+	assert.Equal(t, "vendor=value", sc.TraceState)
+	assert.True(t, sc.Remote)
+
+	// test: invalid values are ignored
+	for _, value := range []string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		h := headers.Headers{}
+		h.Add("traceparent", value)
+		_, ok := Extract(h)
+		assert.False(t, ok, value)
+	}
+
+	// test: future versions may append fields
+	h = headers.Headers{}
+	h.Add("traceparent", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	_, ok = Extract(h)
+	assert.True(t, ok)
+
+	// test: child spans continue the remote trace and are injected
+	r := &recorder{}
+	tracer := NewTracer(Options{Exporter: r})
+	ctx := ContextWithRemote(context.Background(), sc)
+	ctx, parent := tracer.Start(ctx, "parent")
+	_, child := tracer.Start(ctx, "child")
+
+	out := headers.Headers{}
+	Inject(child.SpanContext(), out)
+	traceparent, _ := out.Get("traceparent")
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+child.SpanContext().SpanID.String()+"-01", traceparent)
+	tracestate, _ := out.Get("tracestate")
+	assert.Equal(t, "vendor=value", tracestate)
+
+	child.End()
+	parent.End()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	require.Len(t, r.spans, 2)
+	assert.Equal(t, "child", r.spans[0].Name)
+	assert.Equal(t, parent.SpanContext().SpanID, r.spans[0].ParentSpanID)
+	assert.Equal(t, sc.SpanID, r.spans[1].ParentSpanID)
+
+	// test: unsampled traces are propagated but not exported
+	sc.Flags = 0
+	r = &recorder{}
+	tracer = NewTracer(Options{Exporter: r})
+	_, span := tracer.Start(ContextWithRemote(context.Background(), sc), "dropped")
+	span.End()
+	assert.True(t, strings.HasSuffix(span.SpanContext().Traceparent(), "-00"))
+	require.NoError(t, tracer.Shutdown(context.Background()))
+	assert.Empty(t, r.spans)
+}
+
+func TestOTLPExport(t *testing.T) {
+	endpoint, received := collector(t, "200 OK")
+	exporter := NewOTLPExporter(OTLPOptions{
+		Endpoint:    endpoint,
+		ServiceName: "checkout",
+		Headers:     map[string]string{"Authorization": "Bearer token"},
+	})
+	defer exporter.Close()
+
+	tracer := NewTracer(Options{Exporter: exporter, BatchSize: 2})
+	ctx, span := tracer.Start(context.Background(), "work")
+	span.SetAttribute("items", 3)
+	span.SetAttribute("retried", true)
+	span.SetAttribute("ratio", 0.5)
+	span.SetStatus(StatusError, "failed")
+	_, child := tracer.Start(ctx, "step")
+	child.End()
+	span.End()
+
+	// test: spans are exported once a batch is full
+	var req *request.Request
+	select {
+	case req = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no export received")
+	}
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	assert.Equal(t, "/v1/traces", req.Path())
+	contentType, _ := req.Headers.Get("content-type")
+	assert.Equal(t, "application/json", contentType)
+	auth, _ := req.Headers.Get("authorization")
+	assert.Equal(t, "Bearer token", auth)
+
+	// test: the body is an OTLP/JSON ExportTraceServiceRequest
+	var body struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []map[string]any `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []map[string]any `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	require.NoError(t, json.Unmarshal(req.Body, &body))
+	require.Len(t, body.ResourceSpans, 1)
+	assert.Equal(t, []map[string]any{
+		{"key": "service.name", "value": map[string]any{"stringValue": "checkout"}},
+	}, body.ResourceSpans[0].Resource.Attributes)
+
+	spans := body.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	assert.Equal(t, "step", spans[0]["name"])
+	assert.Equal(t, span.SpanContext().SpanID.String(), spans[0]["parentSpanId"])
+	assert.Equal(t, span.SpanContext().TraceID.String(), spans[1]["traceId"])
+	assert.NotContains(t, spans[1], "parentSpanId")
+	assert.Equal(t, float64(SpanKindInternal), spans[1]["kind"])
+	assert.Equal(t, map[string]any{"code": float64(StatusError), "message": "failed"}, spans[1]["status"])
+	assert.Equal(t, []any{
+		map[string]any{"key": "items", "value": map[string]any{"intValue": "3"}},
+		map[string]any{"key": "ratio", "value": map[string]any{"doubleValue": 0.5}},
+		map[string]any{"key": "retried", "value": map[string]any{"boolValue": true}},
+	}, spans[1]["attributes"])
+	assert.IsType(t, "", spans[1]["startTimeUnixNano"])
+
+	// test: collector errors are reported
+	endpoint, _ = collector(t, "503 Service Unavailable")
+	exporter = NewOTLPExporter(OTLPOptions{Endpoint: endpoint})
+	defer exporter.Close()
+	assert.ErrorContains(t, exporter.Export([]SpanData{{Name: "lost"}}), "collector answered 503")
+}
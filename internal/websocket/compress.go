@@ -0,0 +1,102 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+	"sync"
+)
+
+// permessage-deflate as described by RFC 7692, both sides are asked to
+// reset their context after every message so each one is compressed on
+// its own
+const deflateResponse = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+// the empty stored block a sync flush ends with, stripped on the wire
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// appended when inflating so the reader sees a final block instead of EOF
+var inflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+var flateWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
+}
+
+func compressMessage(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&buf)
+
+	_, err := w.Write(p)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
+
+func decompressMessage(p []byte, limit int64) ([]byte, error) {
+	/*
+	* inflates a message, refusing to produce more than limit
+	* bytes so small frames cannot expand without bound
+	*/
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(p), bytes.NewReader(inflateTail)))
+	defer r.Close()
+
+	out, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, &CloseError{CloseInvalidPayload, "invalid compressed data"}
+	}
+
+	if int64(len(out)) > limit {
+		return nil, &CloseError{CloseMessageTooBig, "message too big"}
+	}
+
+	return out, nil
+}
+
+func acceptDeflate(offers string) bool {
+	/*
+	* reports whether one of the permessage-deflate offers in a
+	* 'Sec-WebSocket-Extensions' header can be accepted
+	*
+	* the compressor always uses a 32KiB window, so offers
+	* limiting the server window below 15 bits are declined
+	*/
+	for _, offer := range strings.Split(offers, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+
+		ok := true
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+
+			switch strings.TrimSpace(name) {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				ok = ok && value == "15"
+			default:
+				ok = false
+			}
+		}
+
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
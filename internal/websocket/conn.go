@@ -0,0 +1,365 @@
+package websocket
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	DefaultMaxMessageSize = 1 << 20
+	DefaultFragmentSize   = 32 << 10
+
+	// how long Close waits for the peer to answer the close frame
+	closeTimeout = 5 * time.Second
+)
+
+var (
+	ErrCloseSent      = errors.New("websocket: close frame already sent")
+	ErrInvalidOpcode  = errors.New("websocket: invalid opcode for this operation")
+	ErrControlTooLong = errors.New("websocket: control frame payload over 125 bytes")
+)
+
+type Conn struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	subprotocol string
+	compress    bool
+
+	// owned by the reading goroutine
+	readLimit   int64
+	readErr     error
+	pingHandler func(data []byte) error
+	pongHandler func(data []byte) error
+
+	writeMu      sync.Mutex
+	fragmentSize int
+	closeSent    bool
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, opts *Options) *Conn {
+	c := &Conn{
+		conn:         conn,
+		reader:       reader,
+		readLimit:    opts.MaxMessageSize,
+		fragmentSize: opts.FragmentSize,
+	}
+
+	if c.readLimit <= 0 {
+		c.readLimit = DefaultMaxMessageSize
+	}
+
+	if c.fragmentSize <= 0 {
+		c.fragmentSize = DefaultFragmentSize
+	}
+
+	c.pingHandler = func(data []byte) error {
+		err := c.WriteControl(OpPong, data)
+		if errors.Is(err, ErrCloseSent) {
+			// a pong is pointless once we are closing
+			return nil
+		}
+		return err
+	}
+	c.pongHandler = func(data []byte) error { return nil }
+
+	return c
+}
+
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadLimit(limit int64) {
+	/*
+	* @brief: sets the maximum size of a message, larger
+	* messages close the connection with 1009. zero or less
+	* restores DefaultMaxMessageSize
+	*/
+	if limit <= 0 {
+		limit = DefaultMaxMessageSize
+	}
+	c.readLimit = limit
+}
+
+func (c *Conn) SetPingHandler(h func(data []byte) error) {
+	/*
+	* @brief: replaces the default ping handler, which
+	* answers with a pong carrying the same data
+	*/
+	if h == nil {
+		h = func(data []byte) error { return nil }
+	}
+	c.pingHandler = h
+}
+
+func (c *Conn) SetPongHandler(h func(data []byte) error) {
+	if h == nil {
+		h = func(data []byte) error { return nil }
+	}
+	c.pongHandler = h
+}
+
+func (c *Conn) ReadMessage() (Opcode, []byte, error) {
+	/*
+	* @brief: reads the next text or binary message, joining
+	* its fragments and answering control frames on the way
+	*
+	* once the peer closes the connection a *CloseError is
+	* returned, protocol violations fail the connection
+	*/
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+
+	var (
+		msgType    Opcode
+		compressed bool
+		message    []byte
+		started    bool
+	)
+
+	for {
+		// what is left of the limit, never negative since every
+		// frame was held to it. a message at the limit can still
+		// end with an empty continuation
+		f, err := readFrame(c.reader, c.readLimit-int64(len(message)), c.compress)
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		if !f.masked {
+			return 0, nil, c.fail(&CloseError{CloseProtocolError, "unmasked client frame"})
+		}
+
+		if f.opcode.isControl() {
+			err = c.handleControl(f)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		if f.opcode == OpContinuation {
+			if !started {
+				return 0, nil, c.fail(&CloseError{CloseProtocolError, "continuation without a message"})
+			}
+			if f.rsv1 {
+				return 0, nil, c.fail(&CloseError{CloseProtocolError, "reserved bit on a continuation frame"})
+			}
+		} else {
+			if started {
+				return 0, nil, c.fail(&CloseError{CloseProtocolError, "new message before the previous one ended"})
+			}
+			started = true
+			msgType = f.opcode
+			compressed = f.rsv1
+		}
+
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+
+		if compressed {
+			message, err = decompressMessage(message, c.readLimit)
+			if err != nil {
+				return 0, nil, c.fail(err)
+			}
+		}
+
+		if msgType == OpText && !utf8.Valid(message) {
+			return 0, nil, c.fail(&CloseError{CloseInvalidPayload, "invalid utf-8 in text message"})
+		}
+
+		return msgType, message, nil
+	}
+}
+
+func (c *Conn) handleControl(f *frame) error {
+	switch f.opcode {
+	case OpPing:
+		err := c.pingHandler(f.payload)
+		if err != nil {
+			return c.fail(err)
+		}
+
+	case OpPong:
+		err := c.pongHandler(f.payload)
+		if err != nil {
+			return c.fail(err)
+		}
+
+	case OpClose:
+		closeErr, err := parseClosePayload(f.payload)
+		if err != nil {
+			return c.fail(err)
+		}
+
+		if closeErr.Code != CloseNoStatus && !utf8.ValidString(closeErr.Reason) {
+			return c.fail(&CloseError{CloseInvalidPayload, "invalid utf-8 in close reason"})
+		}
+
+		// echo the status code to complete the close handshake
+		code := closeErr.Code
+		if code == CloseNoStatus {
+			code = CloseNormal
+		}
+		c.WriteClose(code, "")
+		c.conn.Close()
+		c.readErr = closeErr
+
+		return closeErr
+	}
+
+	return nil
+}
+
+func (c *Conn) fail(err error) error {
+	/*
+	* fails the connection, protocol errors are reported to
+	* the peer with a close frame before the socket is closed
+	*/
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		c.WriteClose(closeErr.Code, closeErr.Reason)
+	} else {
+		closeErr = &CloseError{Code: CloseAbnormal, Reason: err.Error()}
+	}
+
+	c.conn.Close()
+	c.readErr = closeErr
+
+	return closeErr
+}
+
+func (c *Conn) WriteMessage(op Opcode, data []byte) error {
+	/*
+	* @brief: sends a text or binary message, compressed when
+	* permessage-deflate was negotiated and split into
+	* fragments of at most the configured fragment size
+	*/
+	if op != OpText && op != OpBinary {
+		return ErrInvalidOpcode
+	}
+
+	compressed := false
+	if c.compress {
+		deflated, err := compressMessage(data)
+		if err != nil {
+			return err
+		}
+		data = deflated
+		compressed = true
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrCloseSent
+	}
+
+	first := true
+	for first || len(data) > 0 {
+		n := min(len(data), c.fragmentSize)
+		f := &frame{
+			fin:     n == len(data),
+			rsv1:    first && compressed,
+			opcode:  OpContinuation,
+			payload: data[:n],
+		}
+		if first {
+			f.opcode = op
+		}
+
+		err := writeFrame(c.conn, f)
+		if err != nil {
+			return err
+		}
+
+		data = data[n:]
+		first = false
+	}
+
+	return nil
+}
+
+func (c *Conn) WriteControl(op Opcode, data []byte) error {
+	/*
+	* @brief: sends a ping, pong or close frame, it may be
+	* called concurrently with WriteMessage
+	*/
+	if !op.isControl() || !op.isValid() {
+		return ErrInvalidOpcode
+	}
+
+	if len(data) > maxControlPayload {
+		return ErrControlTooLong
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrCloseSent
+	}
+
+	if op == OpClose {
+		c.closeSent = true
+	}
+
+	return writeFrame(c.conn, &frame{fin: true, opcode: op, payload: data})
+}
+
+func (c *Conn) WriteClose(code int, reason string) error {
+	/*
+	* @brief: starts the close handshake, no message may be
+	* written afterwards and ReadMessage returns a *CloseError
+	* once the peer answers
+	*/
+	return c.WriteControl(OpClose, closePayload(code, reason))
+}
+
+func (c *Conn) Close() error {
+	/*
+	* @brief: performs the close handshake with a normal closure
+	* and closes the connection
+	*
+	* Close reads until the peer answers, so it must not be used
+	* while another goroutine is in ReadMessage; such goroutines
+	* should call WriteClose and let ReadMessage finish instead
+	*/
+	err := c.WriteClose(CloseNormal, "")
+	if err != nil && !errors.Is(err, ErrCloseSent) {
+		c.conn.Close()
+		return err
+	}
+
+	if c.readErr == nil {
+		c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+		for {
+			_, _, err = c.ReadMessage()
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	return c.conn.Close()
+}
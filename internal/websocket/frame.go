@@ -0,0 +1,254 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+type Opcode uint8
+
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xa
+)
+
+const (
+	finBit  = 0x80
+	rsv1Bit = 0x40
+	rsv2Bit = 0x20
+	rsv3Bit = 0x10
+	maskBit = 0x80
+
+	// control frames carry at most 125 bytes, RFC 6455 section 5.5
+	maxControlPayload = 125
+)
+
+// close status codes from RFC 6455 section 7.4.1
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseAbnormal        = 1006
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseMandatoryExt    = 1010
+	CloseInternalError   = 1011
+)
+
+type frame struct {
+	fin     bool
+	rsv1    bool
+	opcode  Opcode
+	masked  bool
+	mask    [4]byte
+	payload []byte
+}
+
+// CloseError is returned by reads once the peer closed the connection
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with code %d %s", e.Code, e.Reason)
+}
+
+func (op Opcode) isControl() bool {
+	return op&0x8 != 0
+}
+
+func (op Opcode) isValid() bool {
+	switch op {
+	case OpContinuation, OpText, OpBinary, OpClose, OpPing, OpPong:
+		return true
+	}
+
+	return false
+}
+
+func readFrame(r io.Reader, maxPayload int64, allowRSV1 bool) (*frame, error) {
+	/*
+	* @brief: reads and unmasks a single frame, payloads over
+	* maxPayload bytes are refused before being read. there is
+	* no limit when maxPayload is negative
+	*/
+	var header [2]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return nil, err
+	}
+
+	f := &frame{
+		fin:    header[0]&finBit != 0,
+		rsv1:   header[0]&rsv1Bit != 0,
+		opcode: Opcode(header[0] & 0x0f),
+		masked: header[1]&maskBit != 0,
+	}
+
+	if header[0]&(rsv2Bit|rsv3Bit) != 0 || (f.rsv1 && !allowRSV1) {
+		return nil, &CloseError{CloseProtocolError, "reserved bits set"}
+	}
+
+	if !f.opcode.isValid() {
+		return nil, &CloseError{CloseProtocolError, fmt.Sprintf("unknown opcode %d", f.opcode)}
+	}
+
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(r, ext[:])
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(r, ext[:])
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+		if length < 0 {
+			return nil, &CloseError{CloseProtocolError, "invalid payload length"}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if f.opcode.isControl() {
+		if !f.fin {
+			return nil, &CloseError{CloseProtocolError, "fragmented control frame"}
+		}
+		if length > maxControlPayload {
+			return nil, &CloseError{CloseProtocolError, "control frame too long"}
+		}
+		if f.rsv1 {
+			return nil, &CloseError{CloseProtocolError, "compressed control frame"}
+		}
+	} else if maxPayload >= 0 && length > maxPayload {
+		return nil, &CloseError{CloseMessageTooBig, "message too big"}
+	}
+
+	if f.masked {
+		_, err = io.ReadFull(r, f.mask[:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f.payload = make([]byte, length)
+	_, err = io.ReadFull(r, f.payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.masked {
+		maskBytes(f.mask, f.payload)
+	}
+
+	return f, nil
+}
+
+func writeFrame(w io.Writer, f *frame) error {
+	/*
+	* @brief: serializes f, masking the payload if f.masked
+	*/
+	buf := make([]byte, 0, 14+len(f.payload))
+
+	b0 := byte(f.opcode)
+	if f.fin {
+		b0 |= finBit
+	}
+	if f.rsv1 {
+		b0 |= rsv1Bit
+	}
+	buf = append(buf, b0)
+
+	var b1 byte
+	if f.masked {
+		b1 = maskBit
+	}
+
+	length := len(f.payload)
+	switch {
+	case length < 126:
+		buf = append(buf, b1|byte(length))
+	case length <= 0xffff:
+		buf = append(buf, b1|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(length))
+	default:
+		buf = append(buf, b1|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(length))
+	}
+
+	if f.masked {
+		buf = append(buf, f.mask[:]...)
+		start := len(buf)
+		buf = append(buf, f.payload...)
+		maskBytes(f.mask, buf[start:])
+	} else {
+		buf = append(buf, f.payload...)
+	}
+
+	_, err := w.Write(buf)
+
+	return err
+}
+
+func maskBytes(mask [4]byte, p []byte) {
+	for i := range p {
+		p[i] ^= mask[i%4]
+	}
+}
+
+func closePayload(code int, reason string) []byte {
+	if code == CloseNoStatus {
+		return nil
+	}
+
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+
+	return append(payload, reason...)
+}
+
+func parseClosePayload(payload []byte) (*CloseError, error) {
+	/*
+	* returns the status code and reason carried by a close
+	* frame, an empty payload means no status was given
+	*/
+	if len(payload) == 0 {
+		return &CloseError{Code: CloseNoStatus}, nil
+	}
+
+	if len(payload) == 1 {
+		return nil, &CloseError{CloseProtocolError, "invalid close payload"}
+	}
+
+	code := int(binary.BigEndian.Uint16(payload))
+	if !validCloseCode(code) {
+		return nil, &CloseError{CloseProtocolError, fmt.Sprintf("invalid close code %d", code)}
+	}
+
+	return &CloseError{Code: code, Reason: string(payload[2:])}, nil
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		// registered and private codes
+		return true
+	case code >= 1000 && code <= 1011:
+		// 1004, 1005 and 1006 must never be sent on the wire
+		return code != 1004 && code != CloseNoStatus && code != CloseAbnormal
+	}
+
+	return false
+}
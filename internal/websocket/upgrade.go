@@ -0,0 +1,217 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"Servus/internal/headers"
+	"Servus/internal/request"
+	"Servus/internal/response"
+)
+
+// appended to the client key to compute 'Sec-WebSocket-Accept'
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+type Options struct {
+	// subprotocols supported by the server, in order of preference
+	Subprotocols []string
+	// decides whether the 'Origin' of a request is allowed, by
+	// default only requests without one or from the same host are
+	CheckOrigin func(req *request.Request) bool
+	// negotiates permessage-deflate when the client offers it
+	EnableCompression bool
+	MaxMessageSize    int64
+	// outgoing messages are split into frames of this size
+	FragmentSize int
+}
+
+type HandshakeError struct {
+	Code   response.StatusCode
+	Reason string
+}
+
+func (e *HandshakeError) Error() string {
+	return "websocket: handshake failed: " + e.Reason
+}
+
+func Upgrade(w *response.Writer, req *request.Request, opts *Options) (*Conn, error) {
+	/*
+	* @brief: performs the server side of the opening handshake
+	* and takes over the connection of w
+	*
+	* on failure an error response is written and a *HandshakeError
+	* returned. the connection is hijacked, so the socket may
+	* outlive the handler and must be closed by the caller
+	*/
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	h, handshakeErr := checkHandshake(req, opts)
+	if handshakeErr == nil && w.Hijacker == nil {
+		handshakeErr = &HandshakeError{response.CodeInternalServerError, "connection cannot be hijacked"}
+	}
+	if handshakeErr != nil {
+		writeHandshakeError(w, handshakeErr)
+		return nil, handshakeErr
+	}
+
+	err := w.WriteStatusLine(response.CodeSwitchingProtocols)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	// bytes sent right after the handshake were read with the request
+	reader := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn))
+
+	c := newConn(conn, reader, opts)
+	c.subprotocol = h["sec-websocket-protocol"]
+	c.compress = h["sec-websocket-extensions"] != ""
+
+	return c, nil
+}
+
+func IsUpgradeRequest(req *request.Request) bool {
+	upgrade, _ := req.Headers.Get("Upgrade")
+	connection, _ := req.Headers.Get("Connection")
+
+	return hasToken(upgrade, "websocket") && hasToken(connection, "upgrade")
+}
+
+func checkHandshake(req *request.Request, opts *Options) (headers.Headers, *HandshakeError) {
+	/*
+	* validates the opening handshake of req and returns the
+	* headers of the 101 response
+	*/
+	if req.RequestLine.HttpVersion != "1.1" {
+		return nil, &HandshakeError{response.CodeBadRequest, "websocket requires HTTP/1.1"}
+	}
+
+	if req.RequestLine.Method != "GET" {
+		return nil, &HandshakeError{response.CodeMethodNotAllowed, "method must be GET"}
+	}
+
+	if !IsUpgradeRequest(req) {
+		return nil, &HandshakeError{response.CodeBadRequest, "missing 'Upgrade: websocket'"}
+	}
+
+	version, _ := req.Headers.Get("Sec-WebSocket-Version")
+	if strings.TrimSpace(version) != "13" {
+		return nil, &HandshakeError{response.CodeUpgradeRequired, "unsupported version"}
+	}
+
+	key, _ := req.Headers.Get("Sec-WebSocket-Key")
+	key = strings.TrimSpace(key)
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 16 {
+		return nil, &HandshakeError{response.CodeBadRequest, "invalid 'Sec-WebSocket-Key'"}
+	}
+
+	checkOrigin := opts.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return nil, &HandshakeError{response.CodeForbidden, "origin not allowed"}
+	}
+
+	h := headers.Headers{
+		"upgrade":              "websocket",
+		"connection":           "Upgrade",
+		"sec-websocket-accept": acceptKey(key),
+	}
+
+	offered, _ := req.Headers.Get("Sec-WebSocket-Protocol")
+	protocol := selectSubprotocol(offered, opts.Subprotocols)
+	if protocol != "" {
+		h["sec-websocket-protocol"] = protocol
+	}
+
+	extensions, _ := req.Headers.Get("Sec-WebSocket-Extensions")
+	if opts.EnableCompression && acceptDeflate(extensions) {
+		h["sec-websocket-extensions"] = deflateResponse
+	}
+
+	return h, nil
+}
+
+func writeHandshakeError(w *response.Writer, err *HandshakeError) {
+	body := fmt.Sprintf("%d %s: %s", err.Code, response.StatusText(err.Code), err.Reason)
+	h := headers.GetDefaultHeaders(len(body))
+	if err.Code == response.CodeUpgradeRequired {
+		h.AddOverride("Sec-WebSocket-Version", "13")
+	}
+
+	w.Response = &response.Response{
+		Code:    err.Code,
+		Message: []byte(body),
+		Headers: h,
+	}
+	w.WriteResponse()
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func selectSubprotocol(offered string, supported []string) string {
+	/*
+	* picks the first protocol supported by the server that
+	* the client offered, empty if there is none
+	*/
+	for _, protocol := range supported {
+		for _, part := range strings.Split(offered, ",") {
+			// unlike header tokens, subprotocols are case sensitive
+			if strings.TrimSpace(part) == protocol {
+				return protocol
+			}
+		}
+	}
+
+	return ""
+}
+
+func sameOrigin(req *request.Request) bool {
+	origin, ok := req.Headers.Get("Origin")
+	if !ok {
+		// not a browser, nothing to protect against
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	host, _ := req.Headers.Get("Host")
+
+	return strings.EqualFold(u.Host, host)
+}
+
+func hasToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,241 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Servus/internal/request"
+	"Servus/internal/response"
+	"Servus/internal/server"
+)
+
+const testKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	status string
+	header map[string]string
+}
+
+func echoHandler(opts *Options) response.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		c, err := Upgrade(w, req, opts)
+		if err != nil {
+			return
+		}
+
+		for {
+			op, message, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			err = c.WriteMessage(op, message)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func dial(t *testing.T, handler response.Handler, extra string) *testClient {
+	/*
+	* serves handler, sends an opening handshake with the extra
+	* header lines and reads the response head
+	*/
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte("GET /live HTTP/1.1\r\nHost: localhost\r\n" +
+		"Connection: Upgrade\r\nUpgrade: websocket\r\n" + extra + "\r\n"))
+	require.NoError(t, err)
+
+	c := &testClient{t: t, conn: conn, reader: bufio.NewReader(conn), header: map[string]string{}}
+	c.status, err = c.reader.ReadString('\n')
+	require.NoError(t, err)
+	for {
+		line, err := c.reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		key, value, _ := strings.Cut(strings.TrimSpace(line), ":")
+		c.header[strings.ToLower(key)] = strings.TrimSpace(value)
+	}
+
+	return c
+}
+
+func (c *testClient) write(f *frame) {
+	f.masked = true
+	f.mask = [4]byte{1, 2, 3, 4}
+	require.NoError(c.t, writeFrame(c.conn, f))
+}
+
+func (c *testClient) read() *frame {
+	f, err := readFrame(c.reader, -1, true)
+	require.NoError(c.t, err)
+	require.False(c.t, f.masked)
+
+	return f
+}
+
+func (c *testClient) readClose() int {
+	f := c.read()
+	require.Equal(c.t, OpClose, f.opcode)
+
+	return int(binary.BigEndian.Uint16(f.payload))
+}
+
+func TestHandshake(t *testing.T) {
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey(testKey))
+
+	opts := &Options{Subprotocols: []string{"v2.dashboard", "v1.dashboard"}}
+
+	// test: accepted handshake with subprotocol negotiation
+	c := dial(t, echoHandler(opts), "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+testKey+"\r\n"+
+		"Sec-WebSocket-Protocol: v1.dashboard, v2.dashboard\r\n")
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", c.status)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", c.header["sec-websocket-accept"])
+	assert.Equal(t, "v2.dashboard", c.header["sec-websocket-protocol"])
+	assert.NotContains(t, c.header, "sec-websocket-extensions")
+
+	// test: unsupported version
+	c = dial(t, echoHandler(opts), "Sec-WebSocket-Version: 8\r\nSec-WebSocket-Key: "+testKey+"\r\n")
+	assert.Equal(t, "HTTP/1.1 426 Upgrade Required\r\n", c.status)
+	assert.Equal(t, "13", c.header["sec-websocket-version"])
+
+	// test: malformed key
+	c = dial(t, echoHandler(opts), "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: c2hvcnQ=\r\n")
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n", c.status)
+
+	// test: cross origin requests are refused by default
+	c = dial(t, echoHandler(opts), "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+testKey+"\r\n"+
+		"Origin: https://evil.example\r\n")
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", c.status)
+}
+
+func TestMessages(t *testing.T) {
+	c := dial(t, echoHandler(&Options{FragmentSize: 4}), "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+testKey+"\r\n")
+	require.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", c.status)
+
+	// test: fragmented message with a ping in between
+	c.write(&frame{opcode: OpText, payload: []byte("hello ")})
+	c.write(&frame{fin: true, opcode: OpPing, payload: []byte("are you there")})
+	c.write(&frame{fin: true, opcode: OpContinuation, payload: []byte("world")})
+
+	pong := c.read()
+	assert.Equal(t, OpPong, pong.opcode)
+	assert.Equal(t, "are you there", string(pong.payload))
+
+	// test: the echo comes back in fragments of 4 bytes
+	first := c.read()
+	assert.Equal(t, OpText, first.opcode)
+	assert.False(t, first.fin)
+	message := string(first.payload)
+	for {
+		f := c.read()
+		assert.Equal(t, OpContinuation, f.opcode)
+		assert.LessOrEqual(t, len(f.payload), 4)
+		message += string(f.payload)
+		if f.fin {
+			break
+		}
+	}
+	assert.Equal(t, "hello world", message)
+
+	// test: close handshake
+	c.write(&frame{fin: true, opcode: OpClose, payload: closePayload(CloseGoingAway, "bye")})
+	assert.Equal(t, CloseGoingAway, c.readClose())
+	_, err := c.reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestProtocolErrors(t *testing.T) {
+	extra := "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: " + testKey + "\r\n"
+
+	// test: client frames must be masked
+	c := dial(t, echoHandler(nil), extra)
+	require.NoError(t, writeFrame(c.conn, &frame{fin: true, opcode: OpText, payload: []byte("hi")}))
+	assert.Equal(t, CloseProtocolError, c.readClose())
+
+	// test: messages over the size limit
+	c = dial(t, echoHandler(&Options{MaxMessageSize: 8}), extra)
+	c.write(&frame{opcode: OpBinary, payload: []byte("12345")})
+	c.write(&frame{fin: true, opcode: OpContinuation, payload: []byte("67890")})
+	assert.Equal(t, CloseMessageTooBig, c.readClose())
+
+	// test: the limit holds across many fragments once reached
+	c = dial(t, echoHandler(&Options{MaxMessageSize: 10}), extra)
+	c.write(&frame{opcode: OpBinary, payload: []byte("1234567890")})
+	c.write(&frame{opcode: OpContinuation})
+	c.write(&frame{fin: true, opcode: OpContinuation, payload: []byte("0123456789")})
+	assert.Equal(t, CloseMessageTooBig, c.readClose())
+
+	// test: a message exactly at the limit is accepted
+	c = dial(t, echoHandler(&Options{MaxMessageSize: 10}), extra)
+	c.write(&frame{opcode: OpText, payload: []byte("12345")})
+	c.write(&frame{opcode: OpContinuation, payload: []byte("67890")})
+	c.write(&frame{fin: true, opcode: OpContinuation})
+	f := c.read()
+	assert.Equal(t, OpText, f.opcode)
+	assert.Equal(t, "1234567890", string(f.payload))
+
+	// test: text messages must be utf-8
+	c = dial(t, echoHandler(nil), extra)
+	c.write(&frame{fin: true, opcode: OpText, payload: []byte{0xff, 0xfe}})
+	assert.Equal(t, CloseInvalidPayload, c.readClose())
+
+	// test: control frames cannot be fragmented
+	c = dial(t, echoHandler(nil), extra)
+	c.write(&frame{opcode: OpPing})
+	assert.Equal(t, CloseProtocolError, c.readClose())
+}
+
+func TestCompression(t *testing.T) {
+	c := dial(t, echoHandler(&Options{EnableCompression: true}), "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+testKey+"\r\n"+
+		"Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n")
+	require.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", c.status)
+	assert.Equal(t, deflateResponse, c.header["sec-websocket-extensions"])
+
+	text := strings.Repeat("compress me ", 100)
+	deflated, err := compressMessage([]byte(text))
+	require.NoError(t, err)
+	assert.Less(t, len(deflated), len(text))
+
+	c.write(&frame{fin: true, rsv1: true, opcode: OpText, payload: deflated})
+	f := c.read()
+	assert.True(t, f.rsv1)
+	inflated, err := decompressMessage(f.payload, DefaultMaxMessageSize)
+	require.NoError(t, err)
+	assert.Equal(t, text, string(inflated))
+
+	// test: the inflated size is held to the message limit
+	c = dial(t, echoHandler(&Options{EnableCompression: true, MaxMessageSize: 100}), "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+testKey+"\r\n"+
+		"Sec-WebSocket-Extensions: permessage-deflate\r\n")
+	deflated, err = compressMessage([]byte(strings.Repeat("a", 5000)))
+	require.NoError(t, err)
+	require.Less(t, len(deflated), 100)
+	c.write(&frame{fin: true, rsv1: true, opcode: OpText, payload: deflated})
+	assert.Equal(t, CloseMessageTooBig, c.readClose())
+
+	// test: a limited server window cannot be honoured
+	assert.False(t, acceptDeflate("permessage-deflate; server_max_window_bits=10"))
+	assert.True(t, acceptDeflate("x-webkit-deflate-frame, permessage-deflate; server_no_context_takeover"))
+}